@@ -0,0 +1,11 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	common.ServeWithConfig(w, r, common.HandleDatabaseStats)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+func runCheckConfig(args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configFile := configFlag(fs)
+	ping := fs.Bool("ping", false, "also connect to the database")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	problems := cfg.Validate()
+
+	if *ping && cfg.DatabaseURL != "" {
+		if err := common.PingDatabase(cfg); err != nil {
+			problems = append(problems, fmt.Errorf("DATABASE_URL: %w", err))
+		}
+	}
+
+	fmt.Printf("sources: %v\n", cfg.Sources)
+	fmt.Printf("relays:  %v (min acks %d)\n", cfg.NostrRelays, cfg.NostrMinRelayAcks)
+
+	if len(problems) == 0 {
+		fmt.Println("configuration ok")
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Printf("  - %v\n", problem)
+	}
+
+	return fmt.Errorf("found %d problems", len(problems))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "republish", usage: "republish stored invoices to nostr relays", run: runRepublish},
+	{name: "restore", usage: "rebuild the invoices table from nostr events", run: runRestore},
+	{name: "reprocess", usage: "re-run archived webhook payloads through the current parsers", run: runReprocess},
+	{name: "reconcile", usage: "store settled NWC wallet payments that never reached the invoices table", run: runReconcile},
+	{name: "backfill-alby", usage: "store invoices from the alby api that never reached the invoices table", run: runBackfillAlby},
+	{name: "backfill-helipad", usage: "store boosts, streams and sent boosts from the helipad api", run: runBackfillHelipad},
+	{name: "migrate", usage: "apply or revert database schema migrations", run: runMigrate},
+	{name: "check-config", usage: "validate configuration before going live", run: runCheckConfig},
+	{name: "serve", usage: "run the api and static boards as a standalone http server", run: runServe},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		if err := cmd.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: scoreboard <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-17s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "env file to load before reading the environment (KEY=VALUE per line)")
+}
+
+func loadConfig(path string) (*common.Config, error) {
+	if path != "" {
+		if err := common.LoadEnvFile(path); err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+	}
+
+	cfg, err := common.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
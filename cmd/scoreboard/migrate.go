@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.Int("to", -1, "target version (up: latest by default, down: the previous version by default)")
+	configFile := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: scoreboard migrate [flags] [up|down|status]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	direction := "up"
+	if fs.NArg() > 0 {
+		direction = fs.Arg(0)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DatabaseURL == "" {
+		return fmt.Errorf("DATABASE_URL or POSTGRES_URL is required")
+	}
+
+	store, err := common.OpenStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	var done []common.Migration
+
+	switch direction {
+	case "status":
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-24s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	case "up":
+		target := *to
+		if target < 0 {
+			target = 0
+		}
+		done, err = store.MigrateUp(ctx, target)
+	case "down":
+		target := *to
+		if target < 0 {
+			target = previousVersion(statuses)
+		}
+		done, err = store.MigrateDown(ctx, target)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown direction %q", direction)
+	}
+
+	for _, migration := range done {
+		fmt.Printf("%s %04d %s\n", direction, migration.Version, migration.Name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+
+	return nil
+}
+
+// previousVersion returns the version just below the newest applied
+// migration, so a bare "down" reverts one step.
+func previousVersion(statuses []common.MigrationStatus) int {
+	applied := []int{}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied = append(applied, status.Version)
+		}
+	}
+
+	if len(applied) < 2 {
+		return 0
+	}
+
+	return applied[len(applied)-2]
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+func runReconcile(args []string) error {
+	return runRecovery("reconcile", args, func(cfg *common.Config) error {
+		if cfg.NWCURI == "" {
+			return fmt.Errorf("NWC_URI is required to reconcile wallet transactions")
+		}
+		return nil
+	}, common.ReconcileNWC)
+}
+
+func runBackfillAlby(args []string) error {
+	return runRecovery("backfill-alby", args, func(cfg *common.Config) error {
+		if cfg.KVRestAPIURL == "" || cfg.KVRestAPIToken == "" {
+			return fmt.Errorf("KV_REST_API_URL and KV_REST_API_TOKEN are required to read the alby token")
+		}
+		return nil
+	}, common.BackfillAlby)
+}
+
+func runBackfillHelipad(args []string) error {
+	return runRecovery("backfill-helipad", args, func(cfg *common.Config) error {
+		if cfg.HelipadURL == "" {
+			return fmt.Errorf("HELIPAD_URL is required to read helipad history")
+		}
+		return nil
+	}, common.BackfillHelipad)
+}
+
+// runRecovery parses the flags shared by the recovery commands and prints
+// the report.
+func runRecovery(name string, args []string, check func(cfg *common.Config) error, run common.RecoveryFunc) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	since := fs.String("since", "", "start of the window (YYYY-MM-DD or RFC3339, defaults to 24 hours ago)")
+	until := fs.String("until", "", "end of the window (YYYY-MM-DD or RFC3339, defaults to now)")
+	dryRun := fs.Bool("dry-run", false, "list missing payments without storing or publishing them")
+	configFile := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	if err := check(cfg); err != nil {
+		return err
+	}
+
+	to, err := parseDate(*until)
+	if err != nil {
+		return err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	from, err := parseDate(*since)
+	if err != nil {
+		return err
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := run(ctx, cfg, from, to, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "recovered"
+	if *dryRun {
+		verb = "missing"
+	}
+
+	fmt.Printf("transactions=%d settled=%d existing=%d %s=%d failed=%d\n", report.Transactions, report.Settled, report.Existing, verb, len(report.Recovered), len(report.Failed))
+	for _, hash := range report.Recovered {
+		fmt.Printf("%s %s\n", verb, hash)
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d payments could not be recovered: %s", len(report.Failed), strings.Join(report.Failed, ", "))
+	}
+
+	return nil
+}
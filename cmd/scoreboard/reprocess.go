@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+type idList []int64
+
+func (l *idList) String() string {
+	return fmt.Sprint([]int64(*l))
+}
+
+func (l *idList) Set(val string) error {
+	id, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid payload id %q", val)
+	}
+	*l = append(*l, id)
+	return nil
+}
+
+func runReprocess(args []string) error {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	status := fs.String("status", "failed", "comma-separated payload statuses to select (received, processed, duplicate, ignored, failed)")
+	source := fs.String("source", "", "only payloads from this source (alby, nwc, helipad, ...)")
+	since := fs.String("since", "", "only payloads received on or after this date (YYYY-MM-DD or RFC3339)")
+	until := fs.String("until", "", "only payloads received on or before this date (YYYY-MM-DD or RFC3339)")
+	limit := fs.Int("limit", 0, "maximum number of payloads to reprocess (0 for all)")
+	dryRun := fs.Bool("dry-run", false, "parse payloads with the current parsers without storing anything")
+	var ids idList
+	fs.Var(&ids, "id", "reprocess this payload id regardless of status (repeatable)")
+	configFile := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	filter := common.PayloadFilter{IDs: ids, Limit: *limit}
+
+	if len(ids) == 0 {
+		if filter.Statuses, err = common.ParsePayloadStatuses(*status); err != nil {
+			return err
+		}
+	}
+
+	if *source != "" {
+		filter.Sources = []string{*source}
+	}
+
+	if filter.Since, err = parseDate(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseDate(*until); err != nil {
+		return err
+	}
+
+	payloads, err := common.ListPayloads(cfg, filter)
+	if err != nil {
+		return fmt.Errorf("failed to load payloads: %w", err)
+	}
+
+	log.Printf("found %d payloads to reprocess", len(payloads))
+
+	report, err := common.ReprocessPayloads(cfg, payloads, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("payloads=%d processed=%d duplicate=%d ignored=%d failed=%d\n", report.Payloads, report.Processed, report.Duplicate, report.Ignored, report.Failed)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d payloads still fail", report.Failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+type relayList []string
+
+func (r *relayList) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *relayList) Set(val string) error {
+	*r = append(*r, val)
+	return nil
+}
+
+func parseDate(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if tm, err := time.Parse(layout, val); err == nil {
+			return tm, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC3339", val)
+}
+
+func runRepublish(args []string) error {
+	fs := flag.NewFlagSet("republish", flag.ExitOnError)
+	since := fs.String("since", "", "only invoices created on or after this date (YYYY-MM-DD or RFC3339)")
+	until := fs.String("until", "", "only invoices created on or before this date (YYYY-MM-DD or RFC3339)")
+	eventGuid := fs.String("event-guid", "", "only invoices for this event guid")
+	dryRun := fs.Bool("dry-run", false, "list matching invoices without publishing")
+	var relays relayList
+	fs.Var(&relays, "relay", "relay to publish to (repeatable, defaults to the configured relays)")
+	configFile := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	filter := common.InvoiceFilter{EventGuid: *eventGuid, Direction: common.DirectionIncoming}
+
+	if filter.Since, err = parseDate(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseDate(*until); err != nil {
+		return err
+	}
+
+	if filter.Since.IsZero() && filter.Until.IsZero() && filter.EventGuid == "" {
+		return fmt.Errorf("one of -since, -until or -event-guid is required")
+	}
+
+	if len(relays) == 0 {
+		relays = cfg.NostrRelays
+	}
+
+	invoices, err := common.LoadInvoices(cfg, filter)
+	if err != nil {
+		return fmt.Errorf("failed to load invoices: %w", err)
+	}
+
+	// Streams and automatic payments are stored but never published.
+	published := invoices[:0]
+	for _, invoice := range invoices {
+		if invoice.IsPublished() {
+			published = append(published, invoice)
+		}
+	}
+	invoices = published
+
+	log.Printf("found %d invoices to republish", len(invoices))
+
+	reports := map[string]*common.RelayReport{}
+	for i, invoice := range invoices {
+		if *dryRun {
+			fmt.Printf("%s %s %v sats\n", invoice.CreatedAt, invoice.PaymentHash, invoice.Amount)
+			continue
+		}
+
+		results, err := common.RepublishInvoice(cfg, invoice, relays)
+		if err != nil {
+			return fmt.Errorf("failed to republish %s: %w", invoice.PaymentHash, err)
+		}
+
+		common.TallyRelayResults(reports, results)
+		log.Printf("[%d/%d] republished %s to %d/%d relays", i+1, len(invoices), invoice.PaymentHash, countPublished(results), len(results))
+	}
+
+	urls := make([]string, 0, len(reports))
+	for url := range reports {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	for _, url := range urls {
+		report := reports[url]
+		fmt.Printf("%-40s published=%d failed=%d", report.URL, report.Published, report.Failed)
+		if report.LastError != "" {
+			fmt.Printf(" last_error=%q", report.LastError)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func countPublished(results []common.RelayResult) int {
+	published := 0
+	for _, result := range results {
+		if result.Err == nil {
+			published++
+		}
+	}
+	return published
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	since := fs.String("since", "", "only events published on or after this date (YYYY-MM-DD or RFC3339)")
+	until := fs.String("until", "", "only events published on or before this date (YYYY-MM-DD or RFC3339)")
+	dryRun := fs.Bool("dry-run", false, "verify and decode events without writing to the database")
+	var relays relayList
+	fs.Var(&relays, "relay", "relay to restore from (repeatable, defaults to the configured relays)")
+	configFile := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	sinceTime, err := parseDate(*since)
+	if err != nil {
+		return err
+	}
+
+	untilTime, err := parseDate(*until)
+	if err != nil {
+		return err
+	}
+
+	if len(relays) == 0 {
+		relays = cfg.NostrRelays
+	}
+
+	events, err := common.FetchNostrRecords(cfg, relays, sinceTime, untilTime)
+	if err != nil {
+		return fmt.Errorf("failed to fetch events: %w", err)
+	}
+
+	log.Printf("fetched %d unique events", len(events))
+
+	report, err := common.RestoreInvoices(cfg, events, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("events=%d invalid=%d restored=%d existing=%d failed=%d\n", report.Events, report.Invalid, report.Restored, report.Existing, report.Failed)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d invoices failed to restore", report.Failed)
+	}
+
+	return nil
+}
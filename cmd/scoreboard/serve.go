@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ericpp/scoreboard/common"
+)
+
+var privatePaths = []string{"/api/", "/cmd/", "/common/"}
+
+var privateExtensions = []string{".go", ".mod", ".sum", ".jsonl", ".env"}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	root := fs.String("root", ".", "directory containing the static boards")
+	configFile := configFlag(fs)
+	outboxInterval := fs.Duration("outbox-interval", time.Minute, "how often to retry unpublished nostr events (0 disables)")
+	reconcileInterval := fs.Duration("reconcile-interval", 0, "how often to reconcile NWC wallet transactions against stored invoices (0 disables)")
+	reconcileWindow := fs.Duration("reconcile-window", 24*time.Hour, "how far back each NWC reconciliation looks")
+	migrate := fs.Bool("migrate", true, "apply pending database migrations before serving")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		return fmt.Errorf("configuration has %d problems, run check-config for details", len(errs))
+	}
+
+	if *migrate {
+		applied, err := common.MigrateDatabase(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+
+		for _, migration := range applied {
+			log.Printf("applied migration %04d %s", migration.Version, migration.Name)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newRouter(cfg, *root),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Ends open boost streams on shutdown instead of waiting them out.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	if *outboxInterval > 0 {
+		go common.RunOutboxWorker(ctx, cfg, *outboxInterval)
+	}
+
+	if cfg.SourceEnabled(common.SourceLND) {
+		go common.RunLNDSubscriber(ctx, cfg)
+	}
+
+	if cfg.SourceEnabled(common.SourceNWCRelay) {
+		go common.RunNWCListener(ctx, cfg)
+	}
+
+	if *reconcileInterval > 0 {
+		if cfg.NWCURI != "" {
+			go common.RunRecoveryJob(ctx, cfg, "NWC reconciliation", common.ReconcileNWC, *reconcileInterval, *reconcileWindow)
+		} else {
+			log.Print("NWC reconciliation not started: NWC_URI is not set")
+		}
+	}
+
+	if cfg.SourceEnabled(common.SourceHelipadAPI) {
+		go common.RunHelipadPoller(ctx, cfg)
+	}
+
+	if cfg.SourceEnabled(common.SourceZap) {
+		go common.RunZapIngester(ctx, cfg)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s, serving boards from %s", *addr, *root)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
+
+func newRouter(cfg *common.Config, root string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/api/boosts", common.HandleBoosts(cfg))
+	mux.Handle("/api/boosts/stream", common.HandleBoostStream(cfg))
+	mux.Handle("/api/leaderboard", common.HandleLeaderboard(cfg))
+	mux.Handle("/api/stats", common.HandleStats(cfg))
+	mux.Handle("/api/ws", common.HandleWebSocket(cfg))
+	mux.Handle("/api/webhook", common.HandleAlbyWebhook(cfg))
+	mux.Handle("/api/nwc", common.HandleNWCWebhook(cfg))
+	mux.Handle("/api/helipad", common.HandleHelipadWebhook(cfg))
+	mux.Handle("/api/cln", common.HandleCLNWebhook(cfg))
+	mux.Handle("/api/btcpay", common.HandleBTCPayWebhook(cfg))
+	mux.Handle("/api/lnbits", common.HandleLNbitsWebhook(cfg))
+	mux.Handle("/api/phoenixd", common.HandlePhoenixdWebhook(cfg))
+	mux.Handle("/api/callback", common.HandleAlbyCallback(cfg))
+	mux.Handle("/api/refresh-token", common.HandleRefreshToken(cfg))
+	mux.Handle("/api/outbox", common.HandleOutbox(cfg))
+	mux.Handle("/api/reconcile", common.HandleReconcile(cfg))
+	mux.Handle("/api/alby-backfill", common.HandleAlbyBackfill(cfg))
+	mux.Handle("/api/helipad-backfill", common.HandleHelipadBackfill(cfg))
+	mux.Handle("/api/db-stats", common.HandleDatabaseStats(cfg))
+	mux.Handle("/api/moderation", common.HandleModeration(cfg))
+
+	mux.Handle("/", staticHandler(root))
+
+	return logRequests(mux)
+}
+
+func staticHandler(root string) http.Handler {
+	files := http.FileServer(http.Dir(root))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clean := path.Clean("/" + r.URL.Path)
+
+		for _, segment := range strings.Split(clean, "/") {
+			if strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		for _, prefix := range privatePaths {
+			if strings.HasPrefix(clean+"/", prefix) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		for _, ext := range privateExtensions {
+			if strings.HasSuffix(clean, ext) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+	})
+}
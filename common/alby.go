@@ -0,0 +1,352 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var albyAPIURL = "https://api.getalby.com"
+
+// albyInvoicePageSize is the largest page the invoices API returns.
+const albyInvoicePageSize = 100
+
+var errAlbyUnauthorized = errors.New("alby rejected the access token")
+
+type KVResult struct {
+	Result string `json:"result"`
+}
+
+type AlbyToken struct {
+	AccessToken      string  `json:"access_token"`
+	ExpiresIn        float64 `json:"expires_in"`
+	RefreshToken     string  `json:"refresh_token"`
+	Scope            string  `json:"scope"`
+	TokenType        string  `json:"token_type"`
+	Error            string  `json:"error,omitempty"`
+	ErrorDescription string  `json:"error_description,omitempty"`
+}
+
+func kvRequest(cfg *Config, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", cfg.KVRestAPIURL, path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.KVRestAPIToken))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func GetAccessToken(cfg *Config) (*AlbyToken, error) {
+	body, err := kvRequest(cfg, "GET", "get/authToken", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result KVResult
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	var token AlbyToken
+
+	if err := json.Unmarshal([]byte(result.Result), &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func SetAccessToken(cfg *Config, token AlbyToken) error {
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return setRawAccessToken(cfg, string(encoded))
+}
+
+func setRawAccessToken(cfg *Config, token string) error {
+	body, err := kvRequest(cfg, "POST", "set/authToken", strings.NewReader(token))
+	if err != nil {
+		return err
+	}
+
+	log.Print(string(body))
+
+	return nil
+}
+
+func requestAlbyToken(form url.Values) ([]byte, error) {
+	req, err := http.NewRequest("POST", albyAPIURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Scoreboard")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func RefreshAccessToken(cfg *Config, currToken *AlbyToken) (*AlbyToken, error) {
+	refToken := cfg.AlbyRefreshToken
+	if currToken != nil {
+		refToken = currToken.RefreshToken
+	}
+
+	form := url.Values{}
+	form.Add("client_id", cfg.AlbyClientID)
+	form.Add("client_secret", cfg.AlbyClientSecret)
+	form.Add("grant_type", "refresh_token")
+	form.Add("refresh_token", refToken)
+
+	body, err := requestAlbyToken(form)
+	if err != nil {
+		return nil, err
+	}
+
+	var token AlbyToken
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		log.Print(string(body))
+		return nil, err
+	}
+
+	if token.Error != "" {
+		return nil, errors.New(token.ErrorDescription)
+	}
+
+	if err := SetAccessToken(cfg, token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func HandleAlbyCallback(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") == "" {
+			return
+		}
+
+		form := url.Values{}
+		form.Add("code", r.FormValue("code"))
+		form.Add("grant_type", "authorization_code")
+		form.Add("redirect_uri", cfg.AlbyRedirectURI)
+		form.Add("client_id", cfg.AlbyClientID)
+		form.Add("client_secret", cfg.AlbyClientSecret)
+
+		body, err := requestAlbyToken(form)
+		if err != nil {
+			log.Printf("failed to request alby token: %v", err)
+			http.Error(w, "Failed to request access token", http.StatusBadGateway)
+			return
+		}
+
+		if err := setRawAccessToken(cfg, string(body)); err != nil {
+			log.Printf("failed to store alby token: %v", err)
+		}
+
+		fmt.Fprint(w, string(body))
+	}
+}
+
+func HandleRefreshToken(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := GetAccessToken(cfg)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+
+		if token != nil {
+			if _, err := RefreshAccessToken(cfg, token); err != nil {
+				log.Print(err)
+			}
+		}
+
+		fmt.Fprint(w, "OK")
+	}
+}
+
+// fetchAlbyIncomingInvoices returns one page of settled and unsettled
+// incoming invoices created between from and until.
+func fetchAlbyIncomingInvoices(ctx context.Context, token *AlbyToken, from, until time.Time, page int) ([]json.RawMessage, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("items", strconv.Itoa(albyInvoicePageSize))
+	query.Set("q[created_at_gt]", strconv.FormatInt(from.Unix(), 10))
+	if !until.IsZero() {
+		query.Set("q[created_at_lt]", strconv.FormatInt(until.Unix(), 10))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, albyAPIURL+"/invoices/incoming?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("User-Agent", "Scoreboard")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("alby request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errAlbyUnauthorized
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("alby returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var invoices []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&invoices); err != nil {
+		return nil, fmt.Errorf("failed to decode alby invoices: %w", err)
+	}
+
+	return invoices, nil
+}
+
+// albyInvoiceSettled reports whether an invoice from the API has been paid.
+// The svix webhook only fires for settled invoices, so the rest are skipped.
+func albyInvoiceSettled(raw json.RawMessage) bool {
+	var state struct {
+		Settled *bool  `json:"settled"`
+		State   string `json:"state"`
+	}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return false
+	}
+
+	if state.Settled != nil {
+		return *state.Settled
+	}
+
+	return state.State == "" || strings.EqualFold(state.State, "settled")
+}
+
+// BackfillAlby pages through the Alby incoming invoices API with the OAuth
+// token from the KV store and runs every settled invoice through the alby
+// webhook pipeline, so invoices that are already stored are left alone. An
+// expired token is refreshed once and the page retried. With dryRun the
+// missing invoices are reported but not stored.
+func BackfillAlby(ctx context.Context, cfg *Config, from, until time.Time, dryRun bool) (ReconcileReport, error) {
+	report := ReconcileReport{From: from, Until: until, Recovered: []string{}, Failed: []string{}}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	token, err := GetAccessToken(cfg)
+	if err != nil || token.AccessToken == "" {
+		log.Printf("no stored alby access token, refreshing: %v", err)
+		if token, err = RefreshAccessToken(cfg, nil); err != nil {
+			return report, fmt.Errorf("failed to refresh alby token: %w", err)
+		}
+	}
+
+	refreshed := false
+	for page := 1; ; page++ {
+		invoices, err := fetchAlbyIncomingInvoices(ctx, token, from, until, page)
+		if errors.Is(err, errAlbyUnauthorized) && !refreshed {
+			refreshed = true
+			if token, err = RefreshAccessToken(cfg, token); err != nil {
+				return report, fmt.Errorf("failed to refresh alby token: %w", err)
+			}
+			invoices, err = fetchAlbyIncomingInvoices(ctx, token, from, until, page)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Transactions += len(invoices)
+
+		parsed := []IncomingInvoice{}
+		raws := []json.RawMessage{}
+		hashes := []string{}
+		for _, raw := range invoices {
+			if !albyInvoiceSettled(raw) {
+				continue
+			}
+
+			invoice, err := ParseInvoiceFromJson(raw)
+			if err != nil {
+				log.Printf("skipping unreadable alby invoice: %v", err)
+				continue
+			}
+
+			if invoice.PaymentHash == "" {
+				log.Printf("skipping alby invoice %s without a payment hash", invoice.Identifier)
+				continue
+			}
+
+			parsed = append(parsed, invoice)
+			raws = append(raws, raw)
+			hashes = append(hashes, invoice.PaymentHash)
+		}
+		report.Settled += len(parsed)
+
+		existing, err := store.ExistingPaymentHashes(ctx, hashes)
+		if err != nil {
+			return report, fmt.Errorf("failed to look up stored invoices: %w", err)
+		}
+
+		for i, invoice := range parsed {
+			if existing[invoice.PaymentHash] {
+				report.Existing++
+				continue
+			}
+
+			if dryRun {
+				report.Recovered = append(report.Recovered, invoice.PaymentHash)
+				continue
+			}
+
+			result, err := NewPipeline(cfg, AlbySource{}).Ingest(nil, raws[i])
+			if err != nil {
+				log.Printf("failed to backfill alby invoice %s: %v", invoice.PaymentHash, err)
+				report.Failed = append(report.Failed, invoice.PaymentHash)
+				continue
+			}
+
+			if !result.IsNew {
+				report.Existing++
+				continue
+			}
+
+			report.Recovered = append(report.Recovered, invoice.PaymentHash)
+		}
+
+		if len(invoices) < albyInvoicePageSize {
+			return report, nil
+		}
+	}
+}
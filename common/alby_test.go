@@ -0,0 +1,155 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAlby serves the KV store, the OAuth token endpoint and the incoming
+// invoices API. Only the refreshed token is accepted by the invoices API.
+type fakeAlby struct {
+	mu        sync.Mutex
+	stored    string
+	refreshes int
+	invoices  []map[string]any
+}
+
+func newFakeAlby(t *testing.T, invoices []map[string]any) (*fakeAlby, *httptest.Server) {
+	t.Helper()
+
+	alby := &fakeAlby{stored: `{"access_token":"expired","refresh_token":"refresh-1"}`, invoices: invoices}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get/authToken", func(w http.ResponseWriter, r *http.Request) {
+		alby.mu.Lock()
+		defer alby.mu.Unlock()
+		json.NewEncoder(w).Encode(KVResult{Result: alby.stored})
+	})
+	mux.HandleFunc("/set/authToken", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alby.mu.Lock()
+		alby.stored = string(body)
+		alby.mu.Unlock()
+		fmt.Fprint(w, `{"result":"OK"}`)
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh-1" {
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"bad refresh token"}`)
+			return
+		}
+		alby.mu.Lock()
+		alby.refreshes++
+		alby.mu.Unlock()
+		fmt.Fprint(w, `{"access_token":"fresh","refresh_token":"refresh-2","expires_in":7200}`)
+	})
+	mux.HandleFunc("/invoices/incoming", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		items, _ := strconv.Atoi(r.URL.Query().Get("items"))
+		start := min((page-1)*items, len(alby.invoices))
+		end := min(start+items, len(alby.invoices))
+		json.NewEncoder(w).Encode(alby.invoices[start:end])
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	orig := albyAPIURL
+	albyAPIURL = server.URL
+	t.Cleanup(func() { albyAPIURL = orig })
+
+	return alby, server
+}
+
+func albyTestInvoice(hash string, settled bool) map[string]any {
+	return map[string]any{
+		"amount":        21,
+		"value":         21,
+		"comment":       "boost " + hash,
+		"created_at":    "2023-11-14T22:13:20.000Z",
+		"creation_date": 1700000000,
+		"identifier":    hash,
+		"payment_hash":  hash,
+		"type":          "incoming",
+		"settled":       settled,
+		"state":         map[bool]string{true: "SETTLED", false: "CREATED"}[settled],
+	}
+}
+
+func TestBackfillAlby(t *testing.T) {
+	invoices := []map[string]any{}
+	for i := range albyInvoicePageSize + 5 {
+		invoices = append(invoices, albyTestInvoice(fmt.Sprintf("hash%03d", i), true))
+	}
+	invoices = append(invoices, albyTestInvoice("unpaid", false))
+
+	alby, server := newFakeAlby(t, invoices)
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.KVRestAPIURL = server.URL
+	cfg.KVRestAPIToken = "kv-token"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	existing, _ := json.Marshal(invoices[0])
+	if _, err := NewPipeline(cfg, AlbySource{}).Process(existing); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	report, err := BackfillAlby(context.Background(), cfg, time.Unix(1600000000, 0), time.Time{}, false)
+	if err != nil {
+		t.Fatalf("BackfillAlby() error = %v", err)
+	}
+
+	if report.Transactions != len(invoices) || report.Settled != len(invoices)-1 || report.Existing != 1 {
+		t.Errorf("report = %+v, want every invoice seen and the stored one skipped", report)
+	}
+	if len(report.Recovered) != albyInvoicePageSize+4 || slices.Contains(report.Recovered, "unpaid") {
+		t.Errorf("len(Recovered) = %d, want %d settled invoices", len(report.Recovered), albyInvoicePageSize+4)
+	}
+
+	alby.mu.Lock()
+	refreshes := alby.refreshes
+	alby.mu.Unlock()
+	if refreshes != 1 {
+		t.Errorf("refreshed %d times, want once after the expired token was rejected", refreshes)
+	}
+
+	token, err := GetAccessToken(cfg)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	if token.AccessToken != "fresh" || token.RefreshToken != "refresh-2" {
+		t.Errorf("stored token = %+v, want the refreshed token", token)
+	}
+
+	stored, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(stored) != albyInvoicePageSize+5 {
+		t.Errorf("stored %d invoices, want %d", len(stored), albyInvoicePageSize+5)
+	}
+}
@@ -0,0 +1,239 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type PayloadStatus string
+
+const (
+	PayloadReceived  PayloadStatus = "received"
+	PayloadProcessed PayloadStatus = "processed"
+	PayloadDuplicate PayloadStatus = "duplicate"
+	PayloadIgnored   PayloadStatus = "ignored"
+	PayloadFailed    PayloadStatus = "failed"
+)
+
+// redactedHeaders are never written to the archive.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+type ArchivedPayload struct {
+	ID          int64         `json:"id"`
+	Source      string        `json:"source"`
+	Headers     http.Header   `json:"headers"`
+	Payload     []byte        `json:"payload"`
+	Status      PayloadStatus `json:"status"`
+	Stage       PipelineStage `json:"stage,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	PaymentHash string        `json:"payment_hash,omitempty"`
+	Attempts    int           `json:"attempts"`
+	ReceivedAt  time.Time     `json:"received_at"`
+	ProcessedAt *time.Time    `json:"processed_at"`
+}
+
+type PayloadOutcome struct {
+	Status      PayloadStatus
+	Stage       PipelineStage
+	Error       string
+	PaymentHash string
+}
+
+type PayloadFilter struct {
+	IDs      []int64
+	Sources  []string
+	Statuses []PayloadStatus
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Newest   bool // most recently archived first
+}
+
+type ReprocessReport struct {
+	Payloads  int `json:"payloads"`
+	Processed int `json:"processed"`
+	Duplicate int `json:"duplicate"`
+	Ignored   int `json:"ignored"`
+	Failed    int `json:"failed"`
+}
+
+func (r *ReprocessReport) add(status PayloadStatus) {
+	switch status {
+	case PayloadProcessed:
+		r.Processed++
+	case PayloadDuplicate:
+		r.Duplicate++
+	case PayloadIgnored:
+		r.Ignored++
+	default:
+		r.Failed++
+	}
+}
+
+var payloadSources = map[string]func(cfg *Config) Source{}
+
+// RegisterSource makes a source available to the reprocess command under the
+// name its handler archives payloads with.
+func RegisterSource(name string, build func(cfg *Config) Source) {
+	payloadSources[name] = build
+}
+
+func SourceByName(cfg *Config, name string) (Source, bool) {
+	build, ok := payloadSources[name]
+	if !ok {
+		return nil, false
+	}
+	return build(cfg), true
+}
+
+func init() {
+	RegisterSource("alby", func(*Config) Source { return AlbySource{} })
+	RegisterSource("nwc", func(*Config) Source { return NWCSource{} })
+	RegisterSource("helipad", func(*Config) Source { return HelipadSource{} })
+	RegisterSource("lnd", func(*Config) Source { return LNDSource{} })
+	RegisterSource("cln", func(*Config) Source { return CLNSource{} })
+	RegisterSource("lnbits", func(*Config) Source { return LNbitsSource{} })
+	RegisterSource("btcpay", func(cfg *Config) Source { return BTCPaySource{Config: cfg} })
+	RegisterSource("phoenixd", func(cfg *Config) Source { return PhoenixdSource{Config: cfg} })
+	RegisterSource("zap", func(cfg *Config) Source {
+		zappers, err := ZapperPubKeys(context.Background(), cfg)
+		if err != nil {
+			log.Printf("zap receipts will be rejected: %v", err)
+		}
+		return ZapSource{Zappers: zappers}
+	})
+}
+
+func archivedHeaders(headers http.Header) http.Header {
+	cleaned := headers.Clone()
+	if cleaned == nil {
+		return http.Header{}
+	}
+
+	for _, key := range redactedHeaders {
+		cleaned.Del(key)
+	}
+
+	return cleaned
+}
+
+func payloadOutcome(result PipelineResult, err error) PayloadOutcome {
+	outcome := PayloadOutcome{PaymentHash: result.Invoice.PaymentHash}
+
+	var pipelineErr *PipelineError
+	switch {
+	case err == nil && result.IsNew:
+		outcome.Status = PayloadProcessed
+	case err == nil:
+		outcome.Status = PayloadDuplicate
+	case errors.Is(err, ErrIgnoredPayload):
+		outcome.Status = PayloadIgnored
+	case errors.As(err, &pipelineErr):
+		outcome.Status = PayloadFailed
+		outcome.Stage = pipelineErr.Stage
+		outcome.Error = pipelineErr.Err.Error()
+	default:
+		outcome.Status = PayloadFailed
+		outcome.Error = err.Error()
+	}
+
+	return outcome
+}
+
+func (s DatabaseStore) ArchivePayload(payload ArchivedPayload) (int64, error) {
+	store, err := SharedStore(s.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	return store.ArchivePayload(context.Background(), payload)
+}
+
+func (s DatabaseStore) RecordPayloadOutcome(id int64, outcome PayloadOutcome) error {
+	store, err := SharedStore(s.Config)
+	if err != nil {
+		return err
+	}
+
+	return store.RecordPayloadOutcome(context.Background(), id, outcome)
+}
+
+func ListPayloads(cfg *Config, filter PayloadFilter) ([]ArchivedPayload, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.ListPayloads(context.Background(), filter)
+}
+
+// ReprocessPayloads runs archived payloads through the current parsers and
+// pipeline again. With dryRun set, payloads are only parsed and nothing is
+// written.
+func ReprocessPayloads(cfg *Config, payloads []ArchivedPayload, dryRun bool) (ReprocessReport, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return ReprocessReport{}, err
+	}
+
+	report := ReprocessReport{Payloads: len(payloads)}
+	for _, payload := range payloads {
+		source, ok := SourceByName(cfg, payload.Source)
+		if !ok {
+			log.Printf("payload %d: unknown source %q", payload.ID, payload.Source)
+			report.Failed++
+			continue
+		}
+
+		var outcome PayloadOutcome
+		if dryRun {
+			invoice, err := parsePayload(source, payload.Payload, payload.ReceivedAt)
+			outcome = payloadOutcome(PipelineResult{Invoice: invoice, IsNew: true}, parseError(err))
+		} else {
+			result, err := NewPipeline(cfg, source).ProcessReceived(payload.Payload, payload.ReceivedAt)
+			outcome = payloadOutcome(result, err)
+
+			if err := store.RecordPayloadOutcome(context.Background(), payload.ID, outcome); err != nil {
+				return report, fmt.Errorf("failed to record outcome for payload %d: %w", payload.ID, err)
+			}
+		}
+
+		if outcome.Status == PayloadFailed {
+			log.Printf("payload %d from %s failed at %s: %s", payload.ID, payload.Source, outcome.Stage, outcome.Error)
+		}
+
+		report.add(outcome.Status)
+	}
+
+	return report, nil
+}
+
+// parseError attributes a source error to the parse stage, unless the source
+// already gave it a stage.
+func parseError(err error) error {
+	var pipelineErr *PipelineError
+	if err == nil || errors.Is(err, ErrIgnoredPayload) || errors.As(err, &pipelineErr) {
+		return err
+	}
+	return &PipelineError{Stage: StageParse, Err: err}
+}
+
+// ParsePayloadStatuses parses a comma-separated list of payload statuses.
+func ParsePayloadStatuses(val string) ([]PayloadStatus, error) {
+	statuses := []PayloadStatus{}
+	for _, item := range splitList(val) {
+		status := PayloadStatus(strings.ToLower(item))
+		switch status {
+		case PayloadReceived, PayloadProcessed, PayloadDuplicate, PayloadIgnored, PayloadFailed:
+			statuses = append(statuses, status)
+		default:
+			return nil, fmt.Errorf("unknown payload status %q", item)
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type archiveTestSource struct {
+	fixed bool
+}
+
+func (archiveTestSource) Name() string {
+	return "archive-test"
+}
+
+func (s archiveTestSource) Parse(payload []byte) (IncomingInvoice, error) {
+	var body struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return IncomingInvoice{}, err
+	}
+	if !s.fixed {
+		return IncomingInvoice{}, errors.New("parser bug")
+	}
+	return IncomingInvoice{PaymentHash: body.Hash, CreationDate: 1700000000, Boostagram: &Boostagram{}}, nil
+}
+
+func TestSQLStoreArchivePayloads(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+	received := time.Unix(1700000000, 0).UTC()
+
+	for i, source := range []string{"nwc", "helipad", "nwc"} {
+		_, err := store.ArchivePayload(ctx, ArchivedPayload{
+			Source:     source,
+			Headers:    http.Header{"Content-Type": {"application/json"}},
+			Payload:    []byte(`{"n":` + string(rune('0'+i)) + `}`),
+			ReceivedAt: received.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("ArchivePayload() error = %v", err)
+		}
+	}
+
+	if err := store.RecordPayloadOutcome(ctx, 1, PayloadOutcome{Status: PayloadFailed, Stage: StageParse, Error: "bad json"}); err != nil {
+		t.Fatalf("RecordPayloadOutcome() error = %v", err)
+	}
+	if err := store.RecordPayloadOutcome(ctx, 3, PayloadOutcome{Status: PayloadProcessed, PaymentHash: "hash-3"}); err != nil {
+		t.Fatalf("RecordPayloadOutcome() error = %v", err)
+	}
+
+	failed, err := store.ListPayloads(ctx, PayloadFilter{Statuses: []PayloadStatus{PayloadFailed}})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if len(failed) != 1 || failed[0].ID != 1 {
+		t.Fatalf("ListPayloads(failed) = %+v, want payload 1", failed)
+	}
+
+	payload := failed[0]
+	if string(payload.Payload) != `{"n":0}` || payload.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("payload = %s with headers %v, want the stored payload", payload.Payload, payload.Headers)
+	}
+	if payload.Stage != StageParse || payload.Error != "bad json" || payload.Attempts != 1 || payload.ProcessedAt == nil {
+		t.Errorf("payload outcome = %+v, want parse failure after one attempt", payload)
+	}
+	if !payload.ReceivedAt.Equal(received) {
+		t.Errorf("ReceivedAt = %s, want %s", payload.ReceivedAt, received)
+	}
+
+	nwc, err := store.ListPayloads(ctx, PayloadFilter{Sources: []string{"nwc"}, Since: received.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if len(nwc) != 1 || nwc[0].ID != 3 || nwc[0].PaymentHash != "hash-3" {
+		t.Fatalf("ListPayloads(nwc since) = %+v, want payload 3", nwc)
+	}
+
+	byID, err := store.ListPayloads(ctx, PayloadFilter{IDs: []int64{2, 3}, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if len(byID) != 1 || byID[0].ID != 2 {
+		t.Fatalf("ListPayloads(ids) = %+v, want payload 2", byID)
+	}
+}
+
+func TestReprocessPayloads(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	RegisterSource("archive-test", func(*Config) Source { return archiveTestSource{} })
+	t.Cleanup(func() { delete(payloadSources, "archive-test") })
+
+	source, _ := SourceByName(cfg, "archive-test")
+	pipeline := NewPipeline(cfg, source)
+	pipeline.HandlePayload(httptest.NewRecorder(), nil, []byte(`{"hash":"hash-1"}`))
+
+	payloads, err := ListPayloads(cfg, PayloadFilter{Statuses: []PayloadStatus{PayloadFailed}})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if len(payloads) != 1 || payloads[0].Stage != StageParse {
+		t.Fatalf("failed payloads = %+v, want one parse failure", payloads)
+	}
+
+	RegisterSource("archive-test", func(*Config) Source { return archiveTestSource{fixed: true} })
+
+	report, err := ReprocessPayloads(cfg, payloads, true)
+	if err != nil {
+		t.Fatalf("ReprocessPayloads(dry run) error = %v", err)
+	}
+	if report.Processed != 1 {
+		t.Errorf("dry run report = %+v, want one processed", report)
+	}
+	if invoices, _ := LoadInvoices(cfg, InvoiceFilter{}); len(invoices) != 0 {
+		t.Fatalf("dry run saved %d invoices, want none", len(invoices))
+	}
+
+	report, err = ReprocessPayloads(cfg, payloads, false)
+	if err != nil {
+		t.Fatalf("ReprocessPayloads() error = %v", err)
+	}
+	if report.Processed != 1 || report.Failed != 0 {
+		t.Errorf("report = %+v, want one processed", report)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != "hash-1" {
+		t.Fatalf("invoices = %+v, want hash-1", invoices)
+	}
+
+	payloads, err = ListPayloads(cfg, PayloadFilter{IDs: []int64{payloads[0].ID}})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if payloads[0].Status != PayloadProcessed || payloads[0].Attempts != 2 || payloads[0].PaymentHash != "hash-1" {
+		t.Errorf("payload after reprocess = %+v, want processed on the second attempt", payloads[0])
+	}
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"strings"
 )
@@ -39,3 +42,44 @@ func ValidateHelipadToken(authHeader, expectedToken string) (status int, ok bool
 
 	return http.StatusOK, true
 }
+
+// ValidateBTCPaySignature checks a BTCPay-Sig header, which carries the
+// hex HMAC-SHA256 of the request body as "sha256=<hex>".
+func ValidateBTCPaySignature(sigHeader, secret string, payload []byte) (status int, ok bool) {
+	if secret == "" {
+		return http.StatusInternalServerError, false
+	}
+
+	sig, found := strings.CutPrefix(sigHeader, "sha256=")
+	if !found || !validHMAC(sig, secret, payload) {
+		return http.StatusUnauthorized, false
+	}
+
+	return http.StatusOK, true
+}
+
+// ValidatePhoenixdSignature checks an X-Phoenix-Signature header, the bare
+// hex HMAC-SHA256 of the request body.
+func ValidatePhoenixdSignature(sigHeader, secret string, payload []byte) (status int, ok bool) {
+	if secret == "" {
+		return http.StatusInternalServerError, false
+	}
+
+	if sigHeader == "" || !validHMAC(sigHeader, secret, payload) {
+		return http.StatusUnauthorized, false
+	}
+
+	return http.StatusOK, true
+}
+
+func validHMAC(hexSig, secret string, payload []byte) bool {
+	got, err := hex.DecodeString(hexSig)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
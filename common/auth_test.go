@@ -2,6 +2,7 @@ package common
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -121,3 +122,74 @@ func TestValidateHelipadToken(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateBTCPaySignature(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{"type":"InvoiceSettled"}`)
+	valid := btcpayTestSig("btcpay-secret", string(payload))
+
+	tests := []struct {
+		name       string
+		sigHeader  string
+		secret     string
+		wantStatus int
+		wantOK     bool
+	}{
+		{name: "valid signature", sigHeader: valid, secret: "btcpay-secret", wantStatus: http.StatusOK, wantOK: true},
+		{name: "missing header", sigHeader: "", secret: "btcpay-secret", wantStatus: http.StatusUnauthorized},
+		{name: "missing prefix", sigHeader: strings.TrimPrefix(valid, "sha256="), secret: "btcpay-secret", wantStatus: http.StatusUnauthorized},
+		{name: "not hex", sigHeader: "sha256=zz", secret: "btcpay-secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong secret", sigHeader: valid, secret: "other", wantStatus: http.StatusUnauthorized},
+		{name: "secret not set", sigHeader: valid, secret: "", wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			status, ok := ValidateBTCPaySignature(tt.sigHeader, tt.secret, payload)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidatePhoenixdSignature(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{"type":"payment_received"}`)
+	valid := phoenixdTestSig("phoenixd-secret", string(payload))
+
+	tests := []struct {
+		name       string
+		sigHeader  string
+		secret     string
+		wantStatus int
+		wantOK     bool
+	}{
+		{name: "valid signature", sigHeader: valid, secret: "phoenixd-secret", wantStatus: http.StatusOK, wantOK: true},
+		{name: "missing header", sigHeader: "", secret: "phoenixd-secret", wantStatus: http.StatusUnauthorized},
+		{name: "not hex", sigHeader: "zz", secret: "phoenixd-secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong secret", sigHeader: valid, secret: "other", wantStatus: http.StatusUnauthorized},
+		{name: "secret not set", sigHeader: valid, secret: "", wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			status, ok := ValidatePhoenixdSignature(tt.sigHeader, tt.secret, payload)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// Bolt11Invoice holds the parts of a BOLT11 payment request we check zaps
+// against. Signatures and routing hints are not decoded.
+type Bolt11Invoice struct {
+	AmountMsat      int64
+	PaymentHash     string
+	DescriptionHash string
+	Timestamp       int64
+}
+
+const (
+	bolt11TagPaymentHash     = 1
+	bolt11TagDescriptionHash = 23
+	bolt11SignatureWords     = 104
+	bolt11TimestampWords     = 7
+)
+
+func DecodeBolt11(invoice string) (Bolt11Invoice, error) {
+	hrp, words, err := bech32.DecodeNoLimit(strings.ToLower(strings.TrimPrefix(invoice, "lightning:")))
+	if err != nil {
+		return Bolt11Invoice{}, fmt.Errorf("invalid bolt11: %w", err)
+	}
+
+	amount, err := bolt11AmountMsat(hrp)
+	if err != nil {
+		return Bolt11Invoice{}, err
+	}
+
+	if len(words) < bolt11TimestampWords+bolt11SignatureWords {
+		return Bolt11Invoice{}, errors.New("bolt11 is too short")
+	}
+
+	decoded := Bolt11Invoice{AmountMsat: amount}
+	for _, word := range words[:bolt11TimestampWords] {
+		decoded.Timestamp = decoded.Timestamp<<5 | int64(word)
+	}
+
+	fields := words[bolt11TimestampWords : len(words)-bolt11SignatureWords]
+	for len(fields) >= 3 {
+		tag := fields[0]
+		length := int(fields[1])<<5 | int(fields[2])
+		if len(fields) < 3+length {
+			return Bolt11Invoice{}, errors.New("bolt11 tagged field overruns the data")
+		}
+		data := fields[3 : 3+length]
+		fields = fields[3+length:]
+
+		if tag != bolt11TagPaymentHash && tag != bolt11TagDescriptionHash {
+			continue
+		}
+
+		// Both are 256-bit hashes; other lengths must be skipped per BOLT11.
+		if length != 52 {
+			continue
+		}
+
+		hash, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return Bolt11Invoice{}, fmt.Errorf("invalid bolt11 hash field: %w", err)
+		}
+
+		if tag == bolt11TagPaymentHash {
+			decoded.PaymentHash = hex.EncodeToString(hash)
+		} else {
+			decoded.DescriptionHash = hex.EncodeToString(hash)
+		}
+	}
+
+	return decoded, nil
+}
+
+// bolt11AmountMsat reads the amount from the human-readable part, e.g.
+// "lnbc2500u" is 2500 micro-bitcoin. Invoices without an amount return 0.
+func bolt11AmountMsat(hrp string) (int64, error) {
+	if !strings.HasPrefix(hrp, "ln") {
+		return 0, fmt.Errorf("bolt11 prefix %q is not a lightning invoice", hrp)
+	}
+
+	rest := hrp[2:]
+	start := strings.IndexAny(rest, "0123456789")
+	if start < 0 {
+		return 0, nil
+	}
+
+	amount := rest[start:]
+	multiplier := byte(0)
+	if last := amount[len(amount)-1]; last < '0' || last > '9' {
+		multiplier = last
+		amount = amount[:len(amount)-1]
+	}
+
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bolt11 amount %q", rest[start:])
+	}
+
+	switch multiplier {
+	case 0:
+		return value * 100_000_000_000, nil
+	case 'm':
+		return value * 100_000_000, nil
+	case 'u':
+		return value * 100_000, nil
+	case 'n':
+		return value * 100, nil
+	case 'p':
+		if value%10 != 0 {
+			return 0, fmt.Errorf("bolt11 amount %q is not a whole millisatoshi", rest[start:])
+		}
+		return value / 10, nil
+	default:
+		return 0, fmt.Errorf("unknown bolt11 multiplier %q", multiplier)
+	}
+}
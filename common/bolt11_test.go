@@ -0,0 +1,116 @@
+package common
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// testBolt11 encodes an unsigned invoice carrying a payment hash and a
+// description hash. DecodeBolt11 does not check signatures.
+func testBolt11(t *testing.T, hrp, paymentHash, descriptionHash string) string {
+	t.Helper()
+
+	words := make([]byte, bolt11TimestampWords)
+	for _, field := range []struct {
+		tag  byte
+		hash string
+	}{{bolt11TagPaymentHash, paymentHash}, {bolt11TagDescriptionHash, descriptionHash}} {
+		raw, err := hex.DecodeString(field.hash)
+		if err != nil {
+			t.Fatalf("invalid hash %q: %v", field.hash, err)
+		}
+		data, err := bech32.ConvertBits(raw, 8, 5, true)
+		if err != nil {
+			t.Fatalf("ConvertBits() error = %v", err)
+		}
+		words = append(words, field.tag, byte(len(data)>>5), byte(len(data)&31))
+		words = append(words, data...)
+	}
+	words = append(words, make([]byte, bolt11SignatureWords)...)
+
+	invoice, err := bech32.Encode(hrp, words)
+	if err != nil {
+		t.Fatalf("bech32.Encode() error = %v", err)
+	}
+	return invoice
+}
+
+func TestDecodeBolt11(t *testing.T) {
+	t.Parallel()
+
+	// From the BOLT11 specification examples.
+	invoice := "lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpuaztrnwngzn3kdzw5hydlzf03qdgm2hdq27cqv3agm2awhz5se903vruatfhq77w3ls4evs3ch9zw97j25emudupq63nyw24cg27h2rspfj9srp"
+
+	decoded, err := DecodeBolt11(invoice)
+	if err != nil {
+		t.Fatalf("DecodeBolt11() error = %v", err)
+	}
+
+	if decoded.AmountMsat != 250_000_000 {
+		t.Errorf("AmountMsat = %d, want 250000000", decoded.AmountMsat)
+	}
+
+	if decoded.PaymentHash != "0001020304050607080900010203040506070809000102030405060708090102" {
+		t.Errorf("PaymentHash = %q, want the spec payment hash", decoded.PaymentHash)
+	}
+
+	if decoded.Timestamp != 1496314658 {
+		t.Errorf("Timestamp = %d, want 1496314658", decoded.Timestamp)
+	}
+}
+
+func TestDecodeBolt11DescriptionHash(t *testing.T) {
+	t.Parallel()
+
+	paymentHash := "0101010101010101010101010101010101010101010101010101010101010101"
+	descriptionHash := "0202020202020202020202020202020202020202020202020202020202020202"
+
+	decoded, err := DecodeBolt11(testBolt11(t, "lnbc210n", paymentHash, descriptionHash))
+	if err != nil {
+		t.Fatalf("DecodeBolt11() error = %v", err)
+	}
+
+	if decoded.AmountMsat != 21000 {
+		t.Errorf("AmountMsat = %d, want 21000", decoded.AmountMsat)
+	}
+	if decoded.PaymentHash != paymentHash {
+		t.Errorf("PaymentHash = %q, want %q", decoded.PaymentHash, paymentHash)
+	}
+	if decoded.DescriptionHash != descriptionHash {
+		t.Errorf("DescriptionHash = %q, want %q", decoded.DescriptionHash, descriptionHash)
+	}
+}
+
+func TestBolt11AmountMsat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		hrp     string
+		want    int64
+		wantErr bool
+	}{
+		{hrp: "lnbc", want: 0},
+		{hrp: "lnbc1", want: 100_000_000_000},
+		{hrp: "lnbc2m", want: 200_000_000},
+		{hrp: "lnbc2500u", want: 250_000_000},
+		{hrp: "lnbc210n", want: 21_000},
+		{hrp: "lntb10p", want: 1},
+		{hrp: "lnbcrt5u", want: 500_000},
+		{hrp: "lnbc15p", wantErr: true},
+		{hrp: "lnbc5x", wantErr: true},
+		{hrp: "bc1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := bolt11AmountMsat(tt.hrp)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("bolt11AmountMsat(%q) error = %v, wantErr %v", tt.hrp, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("bolt11AmountMsat(%q) = %d, want %d", tt.hrp, got, tt.want)
+		}
+	}
+}
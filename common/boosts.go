@@ -0,0 +1,212 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBoostPageSize = 25
+	maxBoostPageSize     = 1000
+)
+
+// ErrInvalidBoostQuery is returned for malformed paging or filter
+// parameters, which HandleBoosts reports as a bad request.
+var ErrInvalidBoostQuery = errors.New("invalid boost query")
+
+// BoostCursor marks a position in the (creation_date, identifier) ordering.
+// Before selects the boosts newer than the position instead of older.
+type BoostCursor struct {
+	CreationDate float64 `json:"d"`
+	Identifier   string  `json:"i"`
+	Before       bool    `json:"b,omitempty"`
+}
+
+func (c BoostCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeBoostCursor(val string) (BoostCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return BoostCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidBoostQuery)
+	}
+
+	var cursor BoostCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Identifier == "" {
+		return BoostCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidBoostQuery)
+	}
+
+	return cursor, nil
+}
+
+type IncomingBoost struct {
+	Amount       float64     `json:"amount"`
+	Boostagram   interface{} `json:"boostagram"`
+	CreatedAt    string      `json:"created_at"`
+	CreationDate float64     `json:"creation_date"`
+	Identifier   string      `json:"identifier"`
+	Value        float64     `json:"value"`
+	Direction    string      `json:"direction"`
+}
+
+func GetBoosts(ctx context.Context, cfg *Config, query map[string]string) ([]IncomingBoost, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetBoosts(ctx, query)
+}
+
+func HandleBoosts(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the form data to populate r.Form
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		query := boostQuery(r)
+
+		if r.FormValue("page") != "" {
+			query["page"] = r.FormValue("page")
+		}
+
+		if r.FormValue("items") != "" {
+			query["items"] = r.FormValue("items")
+		}
+
+		if r.FormValue("cursor") != "" {
+			query["cursor"] = r.FormValue("cursor")
+		}
+
+		boosts, err := GetBoosts(r.Context(), cfg, query)
+		if errors.Is(err, ErrInvalidBoostQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "Link")
+
+		if links := boostPageLinks(r, boosts); len(links) > 0 {
+			w.Header().Set("Link", strings.Join(links, ", "))
+		}
+
+		js, err := json.Marshal(boosts)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, string(js))
+	}
+}
+
+// boostQuery reads the filter parameters shared by the boost endpoints. The
+// request form must already be parsed.
+func boostQuery(r *http.Request) map[string]string {
+	return boostFormQuery(r.Form)
+}
+
+func boostFormQuery(form url.Values) map[string]string {
+	query := make(map[string]string)
+
+	if form.Get("since") != "" {
+		query["q[since]"] = form.Get("since")
+	}
+
+	if form.Get("created_at_lt") != "" {
+		query["q[created_at_lt]"] = form.Get("created_at_lt")
+	}
+
+	if form.Get("created_at_gt") != "" {
+		query["q[created_at_gt]"] = form.Get("created_at_gt")
+	}
+
+	if form.Get("direction") != "" {
+		query["q[direction]"] = form.Get("direction")
+	}
+
+	// Handle multiple podcast values
+	if len(form["podcast"]) > 0 {
+		if len(form["podcast"]) == 1 {
+			query["q[podcast]"] = form["podcast"][0]
+		} else {
+			// Join multiple values with comma
+			query["q[podcast]"] = strings.Join(form["podcast"], ",")
+		}
+	}
+
+	// Handle multiple eventGuid values
+	if len(form["eventGuid"]) > 0 {
+		if len(form["eventGuid"]) == 1 {
+			query["q[eventGuid]"] = form["eventGuid"][0]
+		} else {
+			// Join multiple values with comma
+			query["q[eventGuid]"] = strings.Join(form["eventGuid"], ",")
+		}
+	}
+
+	// Handle multiple episodeGuid values
+	if len(form["episodeGuid"]) > 0 {
+		if len(form["episodeGuid"]) == 1 {
+			query["q[episodeGuid]"] = form["episodeGuid"][0]
+		} else {
+			// Join multiple values with comma
+			query["q[episodeGuid]"] = strings.Join(form["episodeGuid"], ",")
+		}
+	}
+
+	return query
+}
+
+// boostPageLinks returns RFC 8288 links to the neighbouring pages, keeping
+// the request's filters and page size. The body stays a plain array so older
+// clients are unaffected. next is only given for a full page; prev is given
+// for any non-empty page so a client can poll it for newer boosts.
+func boostPageLinks(r *http.Request, boosts []IncomingBoost) []string {
+	if len(boosts) == 0 {
+		return nil
+	}
+
+	items := defaultBoostPageSize
+	if val, err := strconv.Atoi(r.FormValue("items")); err == nil {
+		items = min(val, maxBoostPageSize)
+	}
+
+	link := func(cursor BoostCursor, rel string) string {
+		query := r.URL.Query()
+		query.Del("page")
+		query.Set("cursor", cursor.Encode())
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+
+	first, last := boosts[0], boosts[len(boosts)-1]
+	links := []string{}
+
+	if len(boosts) >= items {
+		links = append(links, link(BoostCursor{CreationDate: last.CreationDate, Identifier: last.Identifier}, "next"))
+	}
+
+	links = append(links, link(BoostCursor{CreationDate: first.CreationDate, Identifier: first.Identifier, Before: true}, "prev"))
+
+	return links
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var linkPattern = regexp.MustCompile(`<([^>]+)>; rel="(\w+)"`)
+
+func boostLinks(t *testing.T, header http.Header) map[string]string {
+	t.Helper()
+
+	links := map[string]string{}
+	for _, match := range linkPattern.FindAllStringSubmatch(header.Get("Link"), -1) {
+		links[match[2]] = match[1]
+	}
+	return links
+}
+
+func TestHandleBoostsCursorLinks(t *testing.T) {
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	for i, hash := range []string{"hash-1", "hash-2", "hash-3"} {
+		if _, err := store.SaveInvoiceIfNew(context.Background(), testStoreInvoice(hash, float64(1700000001+i), Boostagram{Podcast: "Show"})); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	get := func(target string) ([]IncomingBoost, map[string]string) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		HandleBoosts(cfg)(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+
+		var boosts []IncomingBoost
+		if err := json.Unmarshal(rec.Body.Bytes(), &boosts); err != nil {
+			t.Fatalf("failed to decode boosts: %v", err)
+		}
+		return boosts, boostLinks(t, rec.Header())
+	}
+
+	boosts, links := get("/api/boosts?items=2&podcast=Show")
+	if len(boosts) != 2 || boosts[0].Identifier != "id-hash-3" {
+		t.Fatalf("first page = %+v, want the two newest boosts", boosts)
+	}
+	if links["next"] == "" || links["prev"] == "" {
+		t.Fatalf("links = %v, want next and prev", links)
+	}
+
+	boosts, links = get(links["next"])
+	if len(boosts) != 1 || boosts[0].Identifier != "id-hash-1" {
+		t.Fatalf("second page = %+v, want the oldest boost", boosts)
+	}
+	if _, ok := links["next"]; ok {
+		t.Errorf("links = %v, want no next link after a short page", links)
+	}
+
+	boosts, _ = get(links["prev"])
+	if len(boosts) != 2 || boosts[0].Identifier != "id-hash-3" || boosts[1].Identifier != "id-hash-2" {
+		t.Errorf("prev page = %+v, want the first page again", boosts)
+	}
+
+	// page and items without a cursor still page by offset.
+	boosts, _ = get("/api/boosts?items=1&page=2")
+	if len(boosts) != 1 || boosts[0].Identifier != "id-hash-2" {
+		t.Errorf("page 2 = %+v, want the middle boost", boosts)
+	}
+
+	rec := httptest.NewRecorder()
+	HandleBoosts(cfg)(rec, httptest.NewRequest(http.MethodGet, "/api/boosts?cursor=%21%21", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
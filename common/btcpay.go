@@ -0,0 +1,260 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const btcpayInvoiceSettled = "InvoiceSettled"
+
+// Metadata keys BTCPay and its plugins use for the LNURL comment and payer
+// details, in order of preference.
+var (
+	btcpayCommentKeys   = []string{"comment", "lnurlComment"}
+	btcpayPayerNameKeys = []string{"payerName", "buyerName"}
+	btcpayEmailKeys     = []string{"payerEmail", "buyerEmail"}
+)
+
+type BTCPayWebhook struct {
+	DeliveryID string         `json:"deliveryId"`
+	Type       string         `json:"type"`
+	Timestamp  int64          `json:"timestamp"`
+	StoreID    string         `json:"storeId"`
+	InvoiceID  string         `json:"invoiceId"`
+	Metadata   map[string]any `json:"metadata"`
+}
+
+// BTCPayInvoice is the subset of the Greenfield invoice we need.
+type BTCPayInvoice struct {
+	ID          string         `json:"id"`
+	StoreID     string         `json:"storeId"`
+	Amount      string         `json:"amount"`
+	Currency    string         `json:"currency"`
+	Status      string         `json:"status"`
+	CreatedTime int64          `json:"createdTime"`
+	Metadata    map[string]any `json:"metadata"`
+}
+
+type btcpayPaymentMethod struct {
+	PaymentMethodID   string `json:"paymentMethodId"`
+	CryptoCode        string `json:"cryptoCode"`
+	Currency          string `json:"currency"`
+	PaymentMethodPaid string `json:"paymentMethodPaid"`
+}
+
+type BTCPaySource struct {
+	Config *Config
+}
+
+func (BTCPaySource) Name() string {
+	return "btcpay"
+}
+
+func (s BTCPaySource) Parse(payload []byte) (IncomingInvoice, error) {
+	var webhook BTCPayWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal BTCPay webhook: %w", err)
+	}
+
+	if webhook.Type != btcpayInvoiceSettled {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	if webhook.InvoiceID == "" {
+		return IncomingInvoice{}, errors.New("BTCPay webhook has no invoiceId")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewBTCPayClient(s.Config)
+
+	invoice, err := client.Invoice(ctx, webhook.StoreID, webhook.InvoiceID)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+
+	sats, err := btcpayInvoiceSats(invoice)
+	if err != nil {
+		methods, err := client.PaymentMethods(ctx, webhook.StoreID, webhook.InvoiceID)
+		if err != nil {
+			return IncomingInvoice{}, err
+		}
+		sats = btcpayPaidSats(methods)
+	}
+
+	return BTCPayInvoiceToInvoice(webhook, invoice, sats), nil
+}
+
+// BTCPayInvoiceToInvoice maps a settled invoice onto IncomingInvoice. Metadata
+// in the webhook wins over the fetched invoice, since it reflects the invoice
+// at the time it settled.
+func BTCPayInvoiceToInvoice(webhook BTCPayWebhook, btcpay BTCPayInvoice, sats float64) IncomingInvoice {
+	metadata := map[string]any{}
+	for key, val := range btcpay.Metadata {
+		metadata[key] = val
+	}
+	for key, val := range webhook.Metadata {
+		metadata[key] = val
+	}
+
+	created := webhook.Timestamp
+	if created == 0 {
+		created = btcpay.CreatedTime
+	}
+
+	identifier := "btcpay-" + webhook.InvoiceID
+
+	invoice := IncomingInvoice{
+		Amount:       sats,
+		Value:        sats,
+		Description:  btcpayMetadataString(metadata, "itemDesc", "orderId"),
+		PaymentHash:  identifier,
+		Identifier:   identifier,
+		Type:         "incoming",
+		CreationDate: float64(created),
+		CreatedAt:    time.Unix(created, 0).UTC().Format(time.RFC3339),
+		Metadata: &InvoiceMetadata{
+			Comment: btcpayMetadataString(metadata, btcpayCommentKeys...),
+		},
+	}
+
+	name := btcpayMetadataString(metadata, btcpayPayerNameKeys...)
+	email := btcpayMetadataString(metadata, btcpayEmailKeys...)
+	if name != "" || email != "" {
+		invoice.Metadata.PayerData = &InvoicePayerData{Name: name, Email: email}
+	}
+
+	// Only the comment and payer name are taken from metadata, so this
+	// cannot fail.
+	_ = applyInvoiceMetadata(&invoice)
+
+	return invoice
+}
+
+func btcpayMetadataString(metadata map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if val, ok := metadata[key].(string); ok && strings.TrimSpace(val) != "" {
+			return strings.TrimSpace(val)
+		}
+	}
+	return ""
+}
+
+// btcpayInvoiceSats converts invoices priced in bitcoin. Fiat invoices return
+// an error so the caller falls back to what was actually paid.
+func btcpayInvoiceSats(invoice BTCPayInvoice) (float64, error) {
+	amount, err := strconv.ParseFloat(invoice.Amount, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BTCPay amount %q", invoice.Amount)
+	}
+
+	switch strings.ToUpper(invoice.Currency) {
+	case "BTC":
+		return math.Round(amount * 1e8), nil
+	case "SATS":
+		return math.Round(amount), nil
+	default:
+		return 0, fmt.Errorf("BTCPay invoice is priced in %s", invoice.Currency)
+	}
+}
+
+func btcpayPaidSats(methods []btcpayPaymentMethod) float64 {
+	var sats float64
+	for _, method := range methods {
+		code := method.Currency
+		if code == "" {
+			code = method.CryptoCode
+		}
+		if !strings.EqualFold(code, "BTC") {
+			continue
+		}
+
+		paid, err := strconv.ParseFloat(method.PaymentMethodPaid, 64)
+		if err != nil {
+			continue
+		}
+		sats += math.Round(paid * 1e8)
+	}
+	return sats
+}
+
+// BTCPayClient talks to the Greenfield API of a BTCPay Server instance.
+type BTCPayClient struct {
+	BaseURL string
+	APIKey  string
+	StoreID string
+	Client  *http.Client
+}
+
+func NewBTCPayClient(cfg *Config) *BTCPayClient {
+	return &BTCPayClient{
+		BaseURL: cfg.BTCPayURL,
+		APIKey:  cfg.BTCPayAPIKey,
+		StoreID: cfg.BTCPayStoreID,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *BTCPayClient) Invoice(ctx context.Context, storeID, invoiceID string) (BTCPayInvoice, error) {
+	var invoice BTCPayInvoice
+	err := c.get(ctx, storeID, "/invoices/"+url.PathEscape(invoiceID), &invoice)
+	return invoice, err
+}
+
+func (c *BTCPayClient) PaymentMethods(ctx context.Context, storeID, invoiceID string) ([]btcpayPaymentMethod, error) {
+	var methods []btcpayPaymentMethod
+	err := c.get(ctx, storeID, "/invoices/"+url.PathEscape(invoiceID)+"/payment-methods", &methods)
+	return methods, err
+}
+
+func (c *BTCPayClient) get(ctx context.Context, storeID, path string, out any) error {
+	if c.BaseURL == "" || c.APIKey == "" {
+		return fetchError(errors.New("BTCPAY_URL and BTCPAY_API_KEY are required to look up invoices"))
+	}
+
+	if c.StoreID != "" {
+		if storeID != "" && storeID != c.StoreID {
+			return fmt.Errorf("webhook is for store %s, expected %s", storeID, c.StoreID)
+		}
+		storeID = c.StoreID
+	}
+
+	if storeID == "" {
+		return errors.New("BTCPay webhook has no storeId")
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/stores/%s%s", c.BaseURL, url.PathEscape(storeID), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+c.APIKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fetchError(fmt.Errorf("BTCPay request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fetchError(fmt.Errorf("BTCPay returned %s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fetchError(fmt.Errorf("failed to decode BTCPay response: %w", err))
+	}
+
+	return nil
+}
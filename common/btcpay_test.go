@@ -0,0 +1,209 @@
+package common
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const btcpayTestWebhook = `{"deliveryId":"d1","type":"InvoiceSettled","timestamp":1700000000,"storeId":"store1","invoiceId":"inv1","metadata":{"itemDesc":"Sticker pack","comment":"rss::payment::boost https://example.com/payment/1","payerName":"alice"}}`
+
+// fakeBTCPay serves the Greenfield invoice endpoints for invoice inv1.
+func fakeBTCPay(t *testing.T, invoice string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/stores/store1/invoices/inv1":
+			w.Write([]byte(invoice))
+		case "/api/v1/stores/store1/invoices/inv1/payment-methods":
+			w.Write([]byte(`[{"paymentMethodId":"BTC-LN","currency":"BTC","paymentMethodPaid":"0.00021"},{"paymentMethodId":"BTC-CHAIN","currency":"BTC","paymentMethodPaid":"0"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func btcpayTestConfig(url string) *Config {
+	return &Config{BTCPayURL: url, BTCPayAPIKey: "api-key", BTCPayWebhookSecret: "btcpay-secret"}
+}
+
+func btcpayTestSig(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestBTCPaySourceParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		invoice string
+		want    float64
+	}{
+		{name: "priced in BTC", invoice: `{"id":"inv1","amount":"0.0005","currency":"BTC","createdTime":1699999000}`, want: 50000},
+		{name: "priced in sats", invoice: `{"id":"inv1","amount":"1234","currency":"SATS"}`, want: 1234},
+		{name: "fiat uses amount paid", invoice: `{"id":"inv1","amount":"5.00","currency":"USD"}`, want: 21000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := fakeBTCPay(t, tt.invoice)
+			invoice, err := BTCPaySource{Config: btcpayTestConfig(server.URL)}.Parse([]byte(btcpayTestWebhook))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if invoice.Amount != tt.want || invoice.Value != tt.want {
+				t.Errorf("Amount = %v, want %v", invoice.Amount, tt.want)
+			}
+			if invoice.PaymentHash != "btcpay-inv1" || invoice.Identifier != "btcpay-inv1" {
+				t.Errorf("PaymentHash = %q, want btcpay-inv1", invoice.PaymentHash)
+			}
+			if invoice.Comment != "rss::payment::boost https://example.com/payment/1" {
+				t.Errorf("Comment = %q, want the rss::payment comment", invoice.Comment)
+			}
+			if invoice.PayerName != "alice" || invoice.Description != "Sticker pack" {
+				t.Errorf("invoice = %+v, want payer and description from metadata", invoice)
+			}
+			if invoice.CreationDate != 1700000000 {
+				t.Errorf("CreationDate = %v, want the webhook timestamp", invoice.CreationDate)
+			}
+		})
+	}
+}
+
+func TestBTCPaySourceParseUsesInvoiceMetadata(t *testing.T) {
+	t.Parallel()
+
+	server := fakeBTCPay(t, `{"id":"inv1","amount":"100","currency":"SATS","metadata":{"lnurlComment":"great show","buyerName":"bob"}}`)
+	payload := `{"type":"InvoiceSettled","timestamp":1700000000,"storeId":"store1","invoiceId":"inv1"}`
+
+	invoice, err := BTCPaySource{Config: btcpayTestConfig(server.URL)}.Parse([]byte(payload))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if invoice.Comment != "great show" || invoice.PayerName != "bob" {
+		t.Errorf("invoice = %+v, want comment and payer from the fetched invoice", invoice)
+	}
+}
+
+func TestBTCPaySourceParseErrors(t *testing.T) {
+	t.Parallel()
+
+	server := fakeBTCPay(t, `{"id":"inv1","amount":"100","currency":"SATS"}`)
+	source := BTCPaySource{Config: btcpayTestConfig(server.URL)}
+
+	if _, err := source.Parse([]byte(`{"type":"InvoiceCreated","storeId":"store1","invoiceId":"inv1"}`)); err != ErrIgnoredPayload {
+		t.Errorf("other event error = %v, want ErrIgnoredPayload", err)
+	}
+
+	var pipelineErr *PipelineError
+	_, err := source.Parse([]byte(`{"type":"InvoiceSettled","storeId":"store1","invoiceId":"missing"}`))
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != StageFetch {
+		t.Errorf("unknown invoice error = %v, want a fetch error", err)
+	}
+
+	cfg := btcpayTestConfig(server.URL)
+	cfg.BTCPayStoreID = "store2"
+	_, err = (BTCPaySource{Config: cfg}).Parse([]byte(btcpayTestWebhook))
+	if err == nil || errors.As(err, &pipelineErr) {
+		t.Errorf("other store error = %v, want a parse error", err)
+	}
+
+	if _, err := (BTCPaySource{Config: &Config{}}).Parse([]byte(btcpayTestWebhook)); err == nil {
+		t.Error("unconfigured API error = nil, want error")
+	}
+}
+
+func TestHandleBTCPayWebhook(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	server := fakeBTCPay(t, `{"id":"inv1","amount":"2100","currency":"SATS"}`)
+	cfg.BTCPayURL = server.URL
+	cfg.BTCPayAPIKey = "api-key"
+	cfg.BTCPayWebhookSecret = "btcpay-secret"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	payload := `{"type":"InvoiceSettled","timestamp":1700000000,"storeId":"store1","invoiceId":"inv1","metadata":{"comment":"love the merch","payerName":"carol"}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/btcpay", strings.NewReader(payload))
+	req.Header.Set("BTCPay-Sig", btcpayTestSig("wrong-secret", payload))
+	rec := httptest.NewRecorder()
+	HandleBTCPayWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status with bad signature = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/btcpay", strings.NewReader(payload))
+	req.Header.Set("BTCPay-Sig", btcpayTestSig("btcpay-secret", payload))
+	rec = httptest.NewRecorder()
+	HandleBTCPayWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != "btcpay-inv1" || invoices[0].Amount != 2100 || invoices[0].PayerName != "carol" {
+		t.Fatalf("invoices = %+v, want the BTCPay payment", invoices)
+	}
+}
+
+func TestHandleBTCPayWebhookLookupFailure(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upgrading", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	cfg.BTCPayURL = server.URL
+	cfg.BTCPayAPIKey = "api-key"
+	cfg.BTCPayWebhookSecret = "btcpay-secret"
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/btcpay", strings.NewReader(btcpayTestWebhook))
+	req.Header.Set("BTCPay-Sig", btcpayTestSig("btcpay-secret", btcpayTestWebhook))
+	rec := httptest.NewRecorder()
+	HandleBTCPayWebhook(cfg)(rec, req)
+
+	// BTCPay only redelivers webhooks that fail with a server error.
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLNInvoicePayment is the body of Core Lightning's invoice_payment
+// notification. Keysend payments carry their TLV records in ExtraTLVs.
+type CLNInvoicePayment struct {
+	Label     string        `json:"label"`
+	Preimage  string        `json:"preimage"`
+	Msat      clnMsat       `json:"msat"`
+	ExtraTLVs []clnExtraTLV `json:"extratlvs"`
+}
+
+type clnExtraTLV struct {
+	Type  uint64 `json:"type"`
+	Value string `json:"value"`
+}
+
+// clnMsat accepts both plain numbers and the "1000msat" strings used by
+// older Core Lightning releases.
+type clnMsat int64
+
+func (m *clnMsat) UnmarshalJSON(data []byte) error {
+	val := strings.TrimSuffix(strings.Trim(string(data), `"`), "msat")
+
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+
+	*m = clnMsat(num)
+	return nil
+}
+
+type clnNotification struct {
+	Method         string          `json:"method"`
+	Params         json.RawMessage `json:"params"`
+	InvoicePayment json.RawMessage `json:"invoice_payment"`
+}
+
+type CLNSource struct{}
+
+func (CLNSource) Name() string {
+	return "cln"
+}
+
+func (s CLNSource) Parse(payload []byte) (IncomingInvoice, error) {
+	return s.ParseReceived(payload, time.Now())
+}
+
+// ParseReceived dates the payment by when the notification was received,
+// since invoice_payment carries no timestamp.
+func (CLNSource) ParseReceived(payload []byte, received time.Time) (IncomingInvoice, error) {
+	payment, err := ParseCLNInvoicePayment(payload)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+
+	return CLNInvoicePaymentToInvoice(payment, received)
+}
+
+// ParseCLNInvoicePayment accepts the notification as a plugin forwards it:
+// either the bare {"invoice_payment": {...}} object or the full JSON-RPC
+// notification with it under params.
+func ParseCLNInvoicePayment(payload []byte) (CLNInvoicePayment, error) {
+	var notification clnNotification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return CLNInvoicePayment{}, fmt.Errorf("failed to unmarshal CLN payload: %w", err)
+	}
+
+	if notification.Method != "" && notification.Method != "invoice_payment" {
+		return CLNInvoicePayment{}, ErrIgnoredPayload
+	}
+
+	body := notification.InvoicePayment
+	if len(body) == 0 && len(notification.Params) > 0 {
+		var params clnNotification
+		if err := json.Unmarshal(notification.Params, &params); err != nil {
+			return CLNInvoicePayment{}, fmt.Errorf("failed to unmarshal CLN params: %w", err)
+		}
+
+		body = params.InvoicePayment
+		if len(body) == 0 {
+			body = notification.Params
+		}
+	}
+
+	if len(body) == 0 {
+		return CLNInvoicePayment{}, errors.New("payload has no invoice_payment")
+	}
+
+	var payment CLNInvoicePayment
+	if err := json.Unmarshal(body, &payment); err != nil {
+		return CLNInvoicePayment{}, fmt.Errorf("failed to unmarshal invoice_payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+func CLNInvoicePaymentToInvoice(payment CLNInvoicePayment, received time.Time) (IncomingInvoice, error) {
+	preimage, err := hex.DecodeString(payment.Preimage)
+	if err != nil || len(preimage) != 32 {
+		return IncomingInvoice{}, fmt.Errorf("invalid preimage %q", payment.Preimage)
+	}
+
+	hash := sha256.Sum256(preimage)
+	paymentHash := hex.EncodeToString(hash[:])
+	sats := float64(int64(payment.Msat) / 1000)
+
+	invoice := IncomingInvoice{
+		Amount:       sats,
+		Value:        sats,
+		Description:  payment.Label,
+		PaymentHash:  paymentHash,
+		Identifier:   paymentHash,
+		Type:         "incoming",
+		CreationDate: float64(received.Unix()),
+		CreatedAt:    received.UTC().Format(time.RFC3339),
+	}
+
+	if len(payment.ExtraTLVs) > 0 {
+		records := make([]InvoiceTLVRecord, 0, len(payment.ExtraTLVs))
+		for _, tlv := range payment.ExtraTLVs {
+			records = append(records, InvoiceTLVRecord{Type: int64(tlv.Type), Value: tlv.Value})
+		}
+		invoice.Metadata = &InvoiceMetadata{TLVRecords: records}
+	}
+
+	if err := applyInvoiceMetadata(&invoice); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to apply invoice metadata: %w", err)
+	}
+
+	return invoice, nil
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const clnTestPreimage = "0101010101010101010101010101010101010101010101010101010101010101"
+
+func clnTestPaymentHash() string {
+	preimage, _ := hex.DecodeString(clnTestPreimage)
+	hash := sha256.Sum256(preimage)
+	return hex.EncodeToString(hash[:])
+}
+
+func clnTestBoostagramHex(t *testing.T) string {
+	t.Helper()
+
+	boostagram, err := json.Marshal(Boostagram{Action: "boost", Podcast: "Podcasting 2.0", SenderName: "bob", Message: "hi"})
+	if err != nil {
+		t.Fatalf("failed to marshal boostagram: %v", err)
+	}
+	return hex.EncodeToString(boostagram)
+}
+
+func TestParseCLNInvoicePayment(t *testing.T) {
+	t.Parallel()
+
+	tlv := clnTestBoostagramHex(t)
+	inner := fmt.Sprintf(`{"label":"keysend-1700000000","preimage":"%s","msat":%%s,"extratlvs":[{"type":7629169,"value":"%s"}]}`, clnTestPreimage, tlv)
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{name: "notification body", payload: `{"invoice_payment":` + fmt.Sprintf(inner, `21000`) + `}`},
+		{name: "msat string", payload: `{"invoice_payment":` + fmt.Sprintf(inner, `"21000msat"`) + `}`},
+		{name: "json-rpc", payload: `{"jsonrpc":"2.0","method":"invoice_payment","params":{"invoice_payment":` + fmt.Sprintf(inner, `21000`) + `}}`},
+		{name: "json-rpc flat params", payload: `{"jsonrpc":"2.0","method":"invoice_payment","params":` + fmt.Sprintf(inner, `21000`) + `}`},
+	}
+
+	received := time.Unix(1700000000, 0)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			payment, err := ParseCLNInvoicePayment([]byte(tt.payload))
+			if err != nil {
+				t.Fatalf("ParseCLNInvoicePayment() error = %v", err)
+			}
+
+			invoice, err := CLNInvoicePaymentToInvoice(payment, received)
+			if err != nil {
+				t.Fatalf("CLNInvoicePaymentToInvoice() error = %v", err)
+			}
+
+			if invoice.PaymentHash != clnTestPaymentHash() {
+				t.Errorf("PaymentHash = %q, want sha256 of the preimage", invoice.PaymentHash)
+			}
+			if invoice.Amount != 21 || invoice.Description != "keysend-1700000000" {
+				t.Errorf("invoice = %+v, want 21 sats with the label as description", invoice)
+			}
+			if invoice.CreationDate != 1700000000 {
+				t.Errorf("CreationDate = %v, want 1700000000", invoice.CreationDate)
+			}
+			if invoice.Boostagram == nil || invoice.Boostagram.SenderName != "bob" || invoice.Boostagram.Message != "hi" {
+				t.Errorf("Boostagram = %+v, want the podcast TLV", invoice.Boostagram)
+			}
+		})
+	}
+}
+
+func TestParseCLNInvoicePaymentErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ParseCLNInvoicePayment([]byte(`{"method":"invoice_creation","params":{}}`)); err != ErrIgnoredPayload {
+		t.Errorf("other notification error = %v, want ErrIgnoredPayload", err)
+	}
+
+	if _, err := ParseCLNInvoicePayment([]byte(`{"label":"x"}`)); err == nil {
+		t.Error("missing invoice_payment error = nil, want error")
+	}
+
+	if _, err := (CLNSource{}).Parse([]byte(`{"invoice_payment":{"label":"x","preimage":"zz","msat":1000}}`)); err == nil {
+		t.Error("invalid preimage error = nil, want error")
+	}
+
+	if _, err := (CLNSource{}).Parse([]byte(`{"invoice_payment":{"label":"x","preimage":"` + clnTestPreimage + `","msat":1000,"extratlvs":[{"type":"7629169msat","value":"00"}]}}`)); err == nil {
+		t.Error("msat TLV type error = nil, want error")
+	}
+}
+
+func TestHandleCLNWebhook(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.CLNWebhookToken = "cln-token"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	payload := fmt.Sprintf(`{"invoice_payment":{"label":"keysend-1","preimage":"%s","msat":"50000msat","extratlvs":[{"type":7629169,"value":"%s"}]}}`, clnTestPreimage, clnTestBoostagramHex(t))
+
+	rec := httptest.NewRecorder()
+	HandleCLNWebhook(cfg)(rec, httptest.NewRequest(http.MethodPost, "/api/cln", strings.NewReader(payload)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/cln", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer cln-token")
+	rec = httptest.NewRecorder()
+	HandleCLNWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != clnTestPaymentHash() || invoices[0].Amount != 50 {
+		t.Fatalf("invoices = %+v, want the CLN payment", invoices)
+	}
+}
+
+func TestReprocessCLNPayloadKeepsReceivedTime(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	received := time.Unix(1700000000, 0).UTC()
+	payload := fmt.Sprintf(`{"invoice_payment":{"label":"keysend-1","preimage":"%s","msat":50000}}`, clnTestPreimage)
+	if _, err := (DatabaseStore{Config: cfg}).ArchivePayload(ArchivedPayload{Source: "cln", Payload: []byte(payload), Status: PayloadFailed, ReceivedAt: received}); err != nil {
+		t.Fatalf("ArchivePayload() error = %v", err)
+	}
+
+	payloads, err := ListPayloads(cfg, PayloadFilter{Sources: []string{"cln"}})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if report, err := ReprocessPayloads(cfg, payloads, false); err != nil || report.Processed != 1 {
+		t.Fatalf("ReprocessPayloads() = %+v, %v, want one processed", report, err)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].CreationDate != float64(received.Unix()) {
+		t.Fatalf("invoices = %+v, want the payment dated when it was received", invoices)
+	}
+}
@@ -0,0 +1,499 @@
+package common
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	SourceAlby       = "alby"
+	SourceAlbyOAuth  = "alby-oauth"
+	SourceNWC        = "nwc"
+	SourceHelipad    = "helipad"
+	SourceLND        = "lnd"
+	SourceCLN        = "cln"
+	SourceBTCPay     = "btcpay"
+	SourceLNbits     = "lnbits"
+	SourcePhoenixd   = "phoenixd"
+	SourceZap        = "zap"
+	SourceNWCRelay   = "nwc-relay"
+	SourceHelipadAPI = "helipad-api"
+)
+
+var DefaultSources = []string{SourceAlby, SourceNWC, SourceHelipad}
+
+type Config struct {
+	DatabaseURL          string
+	DatabaseMaxOpenConns int
+	DatabaseMaxIdleConns int
+	DatabaseQueryTimeout time.Duration
+
+	NostrPublicKey         string
+	NostrSecretKey         string
+	NostrRelays            []string
+	NostrMinRelayAcks      int
+	NostrOutboxMaxAttempts int
+	NostrZapTargets        []string
+	NostrZapLookback       time.Duration
+	// Zap receipts are only accepted from these keys, plus the nostrPubkey
+	// published by the NostrZapLNURL lightning address.
+	NostrZapperPubKeys []string
+	NostrZapLNURL      string
+
+	Sources []string
+
+	AlbyWebhookSecret string
+	AlbyClientID      string
+	AlbyClientSecret  string
+	AlbyRedirectURI   string
+	AlbyRefreshToken  string
+
+	KVRestAPIURL   string
+	KVRestAPIToken string
+
+	NWCWebhookToken string
+	NWCURI          string
+	HelipadToken    string
+	CronSecret      string
+
+	HelipadURL          string
+	HelipadPassword     string
+	HelipadPollInterval time.Duration
+
+	LNDRestURL     string
+	LNDMacaroon    string
+	LNDTLSCertPath string
+
+	CLNWebhookToken string
+
+	BTCPayURL           string
+	BTCPayAPIKey        string
+	BTCPayStoreID       string
+	BTCPayWebhookSecret string
+
+	LNbitsWebhookToken string
+
+	PhoenixdURL           string
+	PhoenixdPassword      string
+	PhoenixdWebhookSecret string
+}
+
+func LoadConfig() (*Config, error) {
+	cfg := &Config{
+		DatabaseURL:           os.Getenv("DATABASE_URL"),
+		NostrRelays:           NostrRelays,
+		Sources:               DefaultSources,
+		AlbyWebhookSecret:     os.Getenv("ALBY_WEBHOOK"),
+		AlbyClientID:          os.Getenv("ALBY_CLIENT_ID"),
+		AlbyClientSecret:      os.Getenv("ALBY_CLIENT_SECRET"),
+		AlbyRedirectURI:       os.Getenv("ALBY_REDIRECT_URI"),
+		AlbyRefreshToken:      os.Getenv("ALBY_REFRESH_TOKEN"),
+		KVRestAPIURL:          strings.TrimRight(os.Getenv("KV_REST_API_URL"), "/"),
+		KVRestAPIToken:        os.Getenv("KV_REST_API_TOKEN"),
+		NWCWebhookToken:       os.Getenv("NWC_WEBHOOK_TOKEN"),
+		NWCURI:                os.Getenv("NWC_URI"),
+		HelipadToken:          os.Getenv("HELIPAD_TOKEN"),
+		HelipadURL:            strings.TrimRight(os.Getenv("HELIPAD_URL"), "/"),
+		HelipadPassword:       os.Getenv("HELIPAD_PASSWORD"),
+		CronSecret:            os.Getenv("CRON_SECRET"),
+		LNDRestURL:            strings.TrimRight(os.Getenv("LND_REST_URL"), "/"),
+		LNDMacaroon:           os.Getenv("LND_MACAROON"),
+		LNDTLSCertPath:        os.Getenv("LND_TLS_CERT_PATH"),
+		CLNWebhookToken:       os.Getenv("CLN_WEBHOOK_TOKEN"),
+		BTCPayURL:             strings.TrimRight(os.Getenv("BTCPAY_URL"), "/"),
+		BTCPayAPIKey:          os.Getenv("BTCPAY_API_KEY"),
+		BTCPayStoreID:         os.Getenv("BTCPAY_STORE_ID"),
+		BTCPayWebhookSecret:   os.Getenv("BTCPAY_WEBHOOK_SECRET"),
+		LNbitsWebhookToken:    os.Getenv("LNBITS_WEBHOOK_TOKEN"),
+		PhoenixdURL:           strings.TrimRight(os.Getenv("PHOENIXD_URL"), "/"),
+		PhoenixdPassword:      os.Getenv("PHOENIXD_PASSWORD"),
+		PhoenixdWebhookSecret: os.Getenv("PHOENIXD_WEBHOOK_SECRET"),
+	}
+
+	var errs []error
+
+	if cfg.DatabaseURL == "" {
+		cfg.DatabaseURL = os.Getenv("POSTGRES_URL")
+	}
+
+	if val := os.Getenv("NOSTR_NPUB"); val != "" {
+		pk, err := decodeNostrKey(val, "npub")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("NOSTR_NPUB: %w", err))
+		}
+		cfg.NostrPublicKey = pk
+	}
+
+	if val := os.Getenv("NOSTR_NSEC"); val != "" {
+		sk, err := decodeNostrKey(val, "nsec")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("NOSTR_NSEC: %w", err))
+		}
+		cfg.NostrSecretKey = sk
+	}
+
+	if val := os.Getenv("LND_MACAROON_PATH"); val != "" && cfg.LNDMacaroon == "" {
+		macaroon, err := os.ReadFile(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("LND_MACAROON_PATH: %w", err))
+		}
+		cfg.LNDMacaroon = hex.EncodeToString(macaroon)
+	}
+
+	if val := os.Getenv("NOSTR_RELAYS"); val != "" {
+		cfg.NostrRelays = splitList(val)
+	}
+
+	if val := os.Getenv("SCOREBOARD_SOURCES"); val != "" {
+		cfg.Sources = splitList(val)
+	}
+
+	if val := os.Getenv("NOSTR_ZAP_TARGETS"); val != "" {
+		cfg.NostrZapTargets = splitList(val)
+	}
+
+	for _, val := range splitList(os.Getenv("NOSTR_ZAPPER_PUBKEYS")) {
+		pk := val
+		if !nostr.IsValid32ByteHex(val) {
+			var err error
+			if pk, err = decodeNostrKey(val, "npub"); err != nil {
+				errs = append(errs, fmt.Errorf("NOSTR_ZAPPER_PUBKEYS: %w", err))
+				continue
+			}
+		}
+		cfg.NostrZapperPubKeys = append(cfg.NostrZapperPubKeys, pk)
+	}
+	cfg.NostrZapLNURL = os.Getenv("NOSTR_ZAP_LNURL")
+
+	var err error
+	if cfg.NostrMinRelayAcks, err = envInt("NOSTR_MIN_RELAY_ACKS", 1); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.NostrOutboxMaxAttempts, err = envInt("NOSTR_OUTBOX_MAX_ATTEMPTS", 12); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.NostrZapLookback, err = envDuration("NOSTR_ZAP_LOOKBACK", 24*time.Hour); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.HelipadPollInterval, err = envDuration("HELIPAD_POLL_INTERVAL", time.Minute); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DatabaseMaxOpenConns, err = envInt("DATABASE_MAX_OPEN_CONNS", 10); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DatabaseMaxIdleConns, err = envInt("DATABASE_MAX_IDLE_CONNS", 5); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DatabaseQueryTimeout, err = envDuration("DATABASE_QUERY_TIMEOUT", 10*time.Second); err != nil {
+		errs = append(errs, err)
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("DATABASE_URL or POSTGRES_URL is required"))
+	} else if strings.HasPrefix(c.DatabaseURL, sqlitePrefix) {
+		if sqlitePath(c.DatabaseURL) == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_URL: %q has no database path", c.DatabaseURL))
+		}
+	} else if err := validateURL(c.DatabaseURL, "postgres", "postgresql"); err != nil {
+		errs = append(errs, fmt.Errorf("DATABASE_URL: %w", err))
+	}
+
+	if c.NostrPublicKey == "" {
+		errs = append(errs, errors.New("NOSTR_NPUB is required"))
+	}
+
+	if c.NostrSecretKey == "" {
+		errs = append(errs, errors.New("NOSTR_NSEC is required"))
+	}
+
+	if c.NostrPublicKey != "" && c.NostrSecretKey != "" {
+		if pk, err := nostr.GetPublicKey(c.NostrSecretKey); err != nil || pk != c.NostrPublicKey {
+			errs = append(errs, errors.New("NOSTR_NSEC does not match NOSTR_NPUB"))
+		}
+	}
+
+	if len(c.NostrRelays) == 0 {
+		errs = append(errs, errors.New("NOSTR_RELAYS must list at least one relay"))
+	}
+
+	for _, relay := range c.NostrRelays {
+		if err := validateURL(relay, "wss", "ws"); err != nil {
+			errs = append(errs, fmt.Errorf("NOSTR_RELAYS: %s: %w", relay, err))
+		}
+	}
+
+	if c.DatabaseMaxIdleConns > c.DatabaseMaxOpenConns {
+		errs = append(errs, fmt.Errorf("DATABASE_MAX_IDLE_CONNS is %d but DATABASE_MAX_OPEN_CONNS is only %d", c.DatabaseMaxIdleConns, c.DatabaseMaxOpenConns))
+	}
+
+	if c.NostrMinRelayAcks > len(c.NostrRelays) {
+		errs = append(errs, fmt.Errorf("NOSTR_MIN_RELAY_ACKS is %d but only %d relays are configured", c.NostrMinRelayAcks, len(c.NostrRelays)))
+	}
+
+	for _, source := range c.Sources {
+		switch source {
+		case SourceAlby:
+			if c.AlbyWebhookSecret == "" {
+				errs = append(errs, errors.New("ALBY_WEBHOOK is required for the alby source"))
+			}
+		case SourceAlbyOAuth:
+			if c.AlbyClientID == "" || c.AlbyClientSecret == "" {
+				errs = append(errs, errors.New("ALBY_CLIENT_ID and ALBY_CLIENT_SECRET are required for the alby-oauth source"))
+			}
+			if err := validateURL(c.AlbyRedirectURI, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("ALBY_REDIRECT_URI: %w", err))
+			}
+			if err := validateURL(c.KVRestAPIURL, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("KV_REST_API_URL: %w", err))
+			}
+			if c.KVRestAPIToken == "" {
+				errs = append(errs, errors.New("KV_REST_API_TOKEN is required for the alby-oauth source"))
+			}
+		case SourceNWC:
+			if c.NWCWebhookToken == "" {
+				errs = append(errs, errors.New("NWC_WEBHOOK_TOKEN is required for the nwc source"))
+			}
+		case SourceHelipad:
+			if c.HelipadToken == "" {
+				errs = append(errs, errors.New("HELIPAD_TOKEN is required for the helipad source"))
+			}
+		case SourceLND:
+			if err := validateURL(c.LNDRestURL, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("LND_REST_URL: %w", err))
+			}
+			if c.LNDMacaroon == "" {
+				errs = append(errs, errors.New("LND_MACAROON or LND_MACAROON_PATH is required for the lnd source"))
+			} else if _, err := hex.DecodeString(c.LNDMacaroon); err != nil {
+				errs = append(errs, errors.New("LND_MACAROON must be hex encoded"))
+			}
+		case SourceCLN:
+			if c.CLNWebhookToken == "" {
+				errs = append(errs, errors.New("CLN_WEBHOOK_TOKEN is required for the cln source"))
+			}
+		case SourceBTCPay:
+			if c.BTCPayWebhookSecret == "" {
+				errs = append(errs, errors.New("BTCPAY_WEBHOOK_SECRET is required for the btcpay source"))
+			}
+			if err := validateURL(c.BTCPayURL, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("BTCPAY_URL: %w", err))
+			}
+			if c.BTCPayAPIKey == "" {
+				errs = append(errs, errors.New("BTCPAY_API_KEY is required for the btcpay source"))
+			}
+		case SourceLNbits:
+			if c.LNbitsWebhookToken == "" {
+				errs = append(errs, errors.New("LNBITS_WEBHOOK_TOKEN is required for the lnbits source"))
+			}
+		case SourcePhoenixd:
+			if c.PhoenixdWebhookSecret == "" {
+				errs = append(errs, errors.New("PHOENIXD_WEBHOOK_SECRET is required for the phoenixd source"))
+			}
+			if err := validateURL(c.PhoenixdURL, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("PHOENIXD_URL: %w", err))
+			}
+			if c.PhoenixdPassword == "" {
+				errs = append(errs, errors.New("PHOENIXD_PASSWORD is required for the phoenixd source"))
+			}
+		case SourceNWCRelay:
+			if c.NWCURI == "" {
+				errs = append(errs, errors.New("NWC_URI is required for the nwc-relay source"))
+			} else if _, err := ParseNWCURI(c.NWCURI); err != nil {
+				errs = append(errs, fmt.Errorf("NWC_URI: %w", err))
+			}
+		case SourceHelipadAPI:
+			if err := validateURL(c.HelipadURL, "https", "http"); err != nil {
+				errs = append(errs, fmt.Errorf("HELIPAD_URL: %w", err))
+			}
+			if c.HelipadPollInterval <= 0 {
+				errs = append(errs, errors.New("HELIPAD_POLL_INTERVAL must be positive for the helipad-api source"))
+			}
+		case SourceZap:
+			if len(c.NostrZapTargets) == 0 {
+				errs = append(errs, errors.New("NOSTR_ZAP_TARGETS is required for the zap source"))
+			} else if _, err := ParseZapTargets(c.NostrZapTargets); err != nil {
+				errs = append(errs, fmt.Errorf("NOSTR_ZAP_TARGETS: %w", err))
+			}
+			if len(c.NostrZapperPubKeys) == 0 && c.NostrZapLNURL == "" {
+				errs = append(errs, errors.New("NOSTR_ZAPPER_PUBKEYS or NOSTR_ZAP_LNURL is required for the zap source"))
+			} else if c.NostrZapLNURL != "" && !strings.Contains(c.NostrZapLNURL, "@") {
+				errs = append(errs, errors.New("NOSTR_ZAP_LNURL must be a lightning address such as name@example.com"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("SCOREBOARD_SOURCES: unknown source %q", source))
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) SourceEnabled(name string) bool {
+	for _, source := range c.Sources {
+		if source == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	serveConfigOnce sync.Once
+	serveConfig     *Config
+)
+
+// ServeWithConfig runs handler with the configuration loaded on the first
+// request of this instance. Settings that fail to load are logged and left at
+// their defaults, so a bad value only breaks the handlers that rely on it.
+func ServeWithConfig(w http.ResponseWriter, r *http.Request, handler func(*Config) http.HandlerFunc) {
+	serveConfigOnce.Do(func() {
+		var err error
+		if serveConfig, err = LoadConfig(); err != nil {
+			log.Printf("invalid configuration: %v", err)
+		}
+	})
+
+	handler(serveConfig)(w, r)
+}
+
+func decodeNostrKey(val, prefix string) (string, error) {
+	kind, decoded, err := nip19.Decode(val)
+	if err != nil {
+		return "", err
+	}
+
+	if kind != prefix {
+		return "", fmt.Errorf("expected %s, got %s", prefix, kind)
+	}
+
+	key, ok := decoded.(string)
+	if !ok {
+		return "", fmt.Errorf("%s did not decode to string", prefix)
+	}
+
+	return key, nil
+}
+
+func validateURL(val string, schemes ...string) error {
+	if val == "" {
+		return errors.New("is required")
+	}
+
+	parsed, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", val)
+	}
+
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("scheme must be one of %s", strings.Join(schemes, ", "))
+}
+
+func splitList(val string) []string {
+	items := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envInt(key string, fallback int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	num, err := strconv.Atoi(val)
+	if err != nil || num < 1 {
+		return fallback, fmt.Errorf("%s must be a positive integer, got %q", key, val)
+	}
+
+	return num, nil
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+
+	dur, err := time.ParseDuration(val)
+	if err != nil || dur <= 0 {
+		return fallback, fmt.Errorf("%s must be a positive duration such as 5s, got %q", key, val)
+	}
+
+	return dur, nil
+}
+
+func LoadEnvFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNum)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,386 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	svix "github.com/svix/svix-webhooks/go"
+)
+
+func HandleAlbyWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wh, err := svix.NewWebhook(cfg.AlbyWebhookSecret)
+		if err != nil {
+			log.Printf("failed to create webhook verifier: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := wh.Verify(payload, r.Header); err != nil {
+			log.Print("Unable to verify webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, AlbySource{}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandleNWCWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.NWCWebhookToken)
+		if !ok {
+			switch status {
+			case http.StatusInternalServerError:
+				log.Print("NWC_WEBHOOK_TOKEN environment variable not set")
+			case http.StatusUnauthorized:
+				if authHeader == "" {
+					log.Print("Missing Authorization header")
+				} else {
+					log.Print("Invalid bearer token")
+				}
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, NWCSource{}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandleCLNWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.CLNWebhookToken)
+		if !ok {
+			switch status {
+			case http.StatusInternalServerError:
+				log.Print("CLN_WEBHOOK_TOKEN environment variable not set")
+			case http.StatusUnauthorized:
+				if authHeader == "" {
+					log.Print("Missing Authorization header")
+				} else {
+					log.Print("Invalid bearer token")
+				}
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, CLNSource{}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandleBTCPayWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sigHeader := r.Header.Get("BTCPay-Sig")
+		status, ok := ValidateBTCPaySignature(sigHeader, cfg.BTCPayWebhookSecret, payload)
+		if !ok {
+			switch {
+			case status == http.StatusInternalServerError:
+				log.Print("BTCPAY_WEBHOOK_SECRET environment variable not set")
+			case sigHeader == "":
+				log.Print("Missing BTCPay-Sig header")
+			default:
+				log.Print("Invalid BTCPay-Sig header")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, BTCPaySource{Config: cfg}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandlePhoenixdWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sigHeader := r.Header.Get("X-Phoenix-Signature")
+		status, ok := ValidatePhoenixdSignature(sigHeader, cfg.PhoenixdWebhookSecret, payload)
+		if !ok {
+			switch {
+			case status == http.StatusInternalServerError:
+				log.Print("PHOENIXD_WEBHOOK_SECRET environment variable not set")
+			case sigHeader == "":
+				log.Print("Missing X-Phoenix-Signature header")
+			default:
+				log.Print("Invalid X-Phoenix-Signature header")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, PhoenixdSource{Config: cfg}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+// HandleLNbitsWebhook also accepts the token as a query parameter, since
+// LNbits webhooks are configured as a bare URL without custom headers.
+func HandleLNbitsWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" && r.URL.Query().Get("token") != "" {
+			authHeader = "Bearer " + r.URL.Query().Get("token")
+		}
+
+		status, ok := ValidateBearerToken(authHeader, cfg.LNbitsWebhookToken)
+		if !ok {
+			switch status {
+			case http.StatusInternalServerError:
+				log.Print("LNBITS_WEBHOOK_TOKEN environment variable not set")
+			case http.StatusUnauthorized:
+				if authHeader == "" {
+					log.Print("Missing Authorization header or token parameter")
+				} else {
+					log.Print("Invalid bearer token")
+				}
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("incoming webhook %s", payload)
+
+		NewPipeline(cfg, LNbitsSource{}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandleHelipadWebhook(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateHelipadToken(authHeader, cfg.HelipadToken)
+		if !ok {
+			if authHeader == "" {
+				log.Print("Missing Authorization header")
+			} else {
+				log.Print("Authorization token does not match")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("Unable to read webhook payload")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		NewPipeline(cfg, HelipadSource{}).HandlePayload(w, r.Header, payload)
+	}
+}
+
+func HandleOutbox(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.CronSecret)
+		if !ok {
+			if status == http.StatusInternalServerError {
+				log.Print("CRON_SECRET environment variable not set")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		var entries []OutboxEntry
+		var err error
+
+		switch r.Method {
+		case http.MethodGet:
+			entries, err = ListStuckOutboxEntries(cfg, 15*time.Minute)
+		case http.MethodPost:
+			entries, err = ProcessOutbox(cfg, 50)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			log.Printf("failed to read nostr outbox: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			log.Printf("failed to encode outbox entries: %v", err)
+		}
+	}
+}
+
+// recoveryWindow reads ?since= and ?until= (RFC3339) or ?window= (a
+// duration back from now, 24h by default) for the recovery endpoints.
+func recoveryWindow(r *http.Request) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	until := time.Now()
+
+	if val := query.Get("until"); val != "" {
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid until")
+		}
+		until = parsed
+	}
+
+	if val := query.Get("since"); val != "" {
+		since, err := time.Parse(time.RFC3339, val)
+		if err != nil || !since.Before(until) {
+			return time.Time{}, time.Time{}, errors.New("invalid since")
+		}
+		return since, until, nil
+	}
+
+	window := 24 * time.Hour
+	if val := query.Get("window"); val != "" {
+		parsed, err := time.ParseDuration(val)
+		if err != nil || parsed <= 0 {
+			return time.Time{}, time.Time{}, errors.New("invalid window")
+		}
+		window = parsed
+	}
+
+	return until.Add(-window), until, nil
+}
+
+// handleRecovery serves a cron-authenticated recovery job. GET reports what
+// is missing without storing it; POST stores and publishes it.
+func handleRecovery(cfg *Config, name string, run RecoveryFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.CronSecret)
+		if !ok {
+			if status == http.StatusInternalServerError {
+				log.Print("CRON_SECRET environment variable not set")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		var dryRun bool
+		switch r.Method {
+		case http.MethodGet:
+			dryRun = true
+		case http.MethodPost:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		from, until, err := recoveryWindow(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := run(r.Context(), cfg, from, until, dryRun)
+		if err != nil {
+			log.Printf("%s failed: %v", name, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("failed to encode %s report: %v", name, err)
+		}
+	}
+}
+
+// HandleReconcile compares the wallet's NIP-47 transactions with stored
+// invoices.
+func HandleReconcile(cfg *Config) http.HandlerFunc {
+	return handleRecovery(cfg, "NWC reconciliation", ReconcileNWC)
+}
+
+// HandleAlbyBackfill recovers invoices from the Alby API.
+func HandleAlbyBackfill(cfg *Config) http.HandlerFunc {
+	return handleRecovery(cfg, "alby backfill", BackfillAlby)
+}
+
+// HandleHelipadBackfill recovers boosts, streams and sent boosts from
+// Helipad's API.
+func HandleHelipadBackfill(cfg *Config) http.HandlerFunc {
+	return handleRecovery(cfg, "helipad backfill", BackfillHelipad)
+}
+
+func HandleDatabaseStats(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.CronSecret)
+		if !ok {
+			if status == http.StatusInternalServerError {
+				log.Print("CRON_SECRET environment variable not set")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		stats, err := DatabaseStats(cfg)
+		if err != nil {
+			log.Printf("failed to read database pool stats: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Printf("failed to encode database pool stats: %v", err)
+		}
+	}
+}
@@ -1,12 +1,57 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Helipad action codes.
+const (
+	helipadActionStream = 1
+	helipadActionBoost  = 2
+	helipadActionAuto   = 4
+)
+
+var helipadActions = map[int8]string{
+	helipadActionStream: "stream",
+	helipadActionBoost:  "boost",
+	helipadActionAuto:   "auto",
+}
+
+type HelipadSource struct{}
+
+func (HelipadSource) Name() string {
+	return "helipad"
+}
+
+func (HelipadSource) Parse(payload []byte) (IncomingInvoice, error) {
+	webhook, err := ParseHelipadWebhook(payload)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+
+	if !IsHelipadPayment(webhook) {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	boostagram, err := ParseHelipadTLV(webhook.Tlv)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+
+	return HelipadWebhookToInvoice(webhook, boostagram), nil
+}
+
 func ParseHelipadWebhook(payload []byte) (HelipadWebhook, error) {
 	var webhook HelipadWebhook
 	if err := json.Unmarshal(payload, &webhook); err != nil {
@@ -24,12 +69,40 @@ func IsHelipadBoost(webhook HelipadWebhook) bool {
 	return webhook.Direction == "" || webhook.Direction == "incoming"
 }
 
+// IsHelipadPayment accepts streams, boosts and automated boosts in either
+// direction. Sent boosts and replies are stored as outgoing.
+func IsHelipadPayment(webhook HelipadWebhook) bool {
+	if _, ok := helipadActions[webhook.Action]; !ok {
+		return false
+	}
+
+	return webhook.ValueMsat > 0
+}
+
+func helipadDirection(webhook HelipadWebhook) string {
+	if webhook.Direction == DirectionOutgoing || webhook.PaymentInfo != nil {
+		return DirectionOutgoing
+	}
+	return DirectionIncoming
+}
+
 func HelipadWebhookToInvoice(webhook HelipadWebhook, tlv Boostagram) IncomingInvoice {
 	tm := time.Unix(webhook.Time, 0)
 	amount := float64(webhook.ValueMsat) / 1000.0
-	identifier := fmt.Sprintf("helipad-%d", webhook.Index)
+	direction := helipadDirection(webhook)
 	boostagram := mergeHelipadBoostagram(webhook, tlv)
 
+	// Sent boosts are numbered separately from received ones in Helipad.
+	identifier := fmt.Sprintf("helipad-%d", webhook.Index)
+	paymentHash := identifier
+	if direction == DirectionOutgoing {
+		identifier = fmt.Sprintf("helipad-sent-%d", webhook.Index)
+		paymentHash = identifier
+		if webhook.PaymentInfo != nil && webhook.PaymentInfo.PaymentHash != "" {
+			paymentHash = webhook.PaymentInfo.PaymentHash
+		}
+	}
+
 	description := webhook.Memo
 	if description == "" {
 		description = webhook.Message
@@ -42,10 +115,11 @@ func HelipadWebhookToInvoice(webhook HelipadWebhook, tlv Boostagram) IncomingInv
 		CreatedAt:    tm.Format(time.RFC3339),
 		CreationDate: float64(webhook.Time),
 		Description:  description,
+		Direction:    direction,
 		Identifier:   identifier,
-		PaymentHash:  identifier,
+		PaymentHash:  paymentHash,
 		PayerName:    webhook.Sender,
-		Type:         "incoming",
+		Type:         direction,
 		Value:        amount,
 	}
 }
@@ -69,8 +143,8 @@ func mergeHelipadBoostagram(webhook HelipadWebhook, tlv Boostagram) Boostagram {
 	if tlv.ValueMsatTotal == 0 && webhook.ValueMsatTotal > 0 {
 		tlv.ValueMsatTotal = int(webhook.ValueMsatTotal)
 	}
-	if tlv.Action == "" && webhook.Action == 2 {
-		tlv.Action = "boost"
+	if tlv.Action == "" {
+		tlv.Action = helipadActions[webhook.Action]
 	}
 
 	return tlv
@@ -103,4 +177,234 @@ func ParseHelipadTLV(tlv string) (Boostagram, error) {
 	}
 
 	return boostagram, nil
-}
\ No newline at end of file
+}
+
+const helipadPageSize = 100
+
+// HelipadClient reads boost history from Helipad's web API, which returns
+// the same records it sends to webhooks minus the direction.
+type HelipadClient struct {
+	BaseURL  string
+	Password string
+	Client   *http.Client
+
+	loggedIn bool
+}
+
+func NewHelipadClient(cfg *Config) *HelipadClient {
+	jar, _ := cookiejar.New(nil)
+
+	return &HelipadClient{
+		BaseURL:  cfg.HelipadURL,
+		Password: cfg.HelipadPassword,
+		Client:   &http.Client{Timeout: 30 * time.Second, Jar: jar},
+	}
+}
+
+// login exchanges the password for Helipad's session cookie. Helipad
+// installs without a password need no login.
+func (c *HelipadClient) login(ctx context.Context) error {
+	if c.loggedIn || c.Password == "" {
+		return nil
+	}
+
+	form := url.Values{}
+	form.Set("password", c.Password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("helipad login failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("helipad login returned %s", resp.Status)
+	}
+
+	c.loggedIn = true
+	return nil
+}
+
+func (c *HelipadClient) get(ctx context.Context, path string, query url.Values, result any) error {
+	if err := c.login(ctx); err != nil {
+		return err
+	}
+
+	endpoint := c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("helipad request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("helipad returned %s for %s: %s", resp.Status, path, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode helipad %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// helipadFeeds maps each history endpoint to the index it pages from and the
+// direction of its records. Streams share the boost index.
+var helipadFeeds = []struct {
+	path      string
+	indexPath string
+	direction string
+}{
+	{"/api/v1/boosts", "/api/v1/index", DirectionIncoming},
+	{"/api/v1/streams", "/api/v1/index", DirectionIncoming},
+	{"/api/v1/sent", "/api/v1/sent_index", DirectionOutgoing},
+}
+
+// History returns received boosts, streams and sent boosts created between
+// from and until, paging back from the newest record.
+func (c *HelipadClient) History(ctx context.Context, from, until time.Time) ([]HelipadWebhook, error) {
+	records := []HelipadWebhook{}
+
+	for _, feed := range helipadFeeds {
+		var index int64
+		if err := c.get(ctx, feed.indexPath, nil, &index); err != nil {
+			return records, err
+		}
+
+		for index > 0 {
+			query := url.Values{}
+			query.Set("index", strconv.FormatInt(index, 10))
+			query.Set("count", strconv.Itoa(helipadPageSize))
+			query.Set("old", "true")
+
+			var page []HelipadWebhook
+			if err := c.get(ctx, feed.path, query, &page); err != nil {
+				return records, err
+			}
+
+			if len(page) == 0 {
+				break
+			}
+
+			oldest := page[0]
+			for _, record := range page {
+				if record.Index < oldest.Index {
+					oldest = record
+				}
+
+				if record.Time < from.Unix() || (!until.IsZero() && record.Time > until.Unix()) {
+					continue
+				}
+
+				record.Direction = feed.direction
+				records = append(records, record)
+			}
+
+			if oldest.Time < from.Unix() {
+				break
+			}
+			index = oldest.Index - 1
+		}
+	}
+
+	return records, nil
+}
+
+// BackfillHelipad stores boosts, streams and sent boosts from Helipad's API
+// that never arrived by webhook. With dryRun the missing records are
+// reported but not stored.
+func BackfillHelipad(ctx context.Context, cfg *Config, from, until time.Time, dryRun bool) (ReconcileReport, error) {
+	report := ReconcileReport{From: from, Until: until, Recovered: []string{}, Failed: []string{}}
+
+	if cfg.HelipadURL == "" {
+		return report, errors.New("HELIPAD_URL is required to read helipad history")
+	}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	records, err := NewHelipadClient(cfg).History(ctx, from, until)
+	if err != nil {
+		return report, fmt.Errorf("failed to read helipad history: %w", err)
+	}
+	report.Transactions = len(records)
+
+	payloads := [][]byte{}
+	hashes := []string{}
+	for _, record := range records {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return report, err
+		}
+
+		invoice, err := HelipadSource{}.Parse(payload)
+		if err != nil {
+			if !errors.Is(err, ErrIgnoredPayload) {
+				log.Printf("skipping helipad record %d: %v", record.Index, err)
+			}
+			continue
+		}
+
+		payloads = append(payloads, payload)
+		hashes = append(hashes, invoice.PaymentHash)
+	}
+	report.Settled = len(payloads)
+
+	existing, err := store.ExistingPaymentHashes(ctx, hashes)
+	if err != nil {
+		return report, fmt.Errorf("failed to look up stored invoices: %w", err)
+	}
+
+	for i, payload := range payloads {
+		hash := hashes[i]
+		if existing[hash] {
+			report.Existing++
+			continue
+		}
+
+		if dryRun {
+			report.Recovered = append(report.Recovered, hash)
+			continue
+		}
+
+		result, err := NewPipeline(cfg, HelipadSource{}).Ingest(nil, payload)
+		if err != nil {
+			log.Printf("failed to backfill helipad payment %s: %v", hash, err)
+			report.Failed = append(report.Failed, hash)
+			continue
+		}
+
+		if !result.IsNew {
+			report.Existing++
+			continue
+		}
+
+		report.Recovered = append(report.Recovered, hash)
+	}
+
+	return report, nil
+}
+
+// RunHelipadPoller backfills the last few poll intervals from Helipad's API
+// every HelipadPollInterval, for installs that cannot reach our webhook.
+func RunHelipadPoller(ctx context.Context, cfg *Config) {
+	RunRecoveryJob(ctx, cfg, "helipad poll", BackfillHelipad, cfg.HelipadPollInterval, 3*cfg.HelipadPollInterval)
+}
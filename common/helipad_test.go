@@ -1,6 +1,14 @@
 package common
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -264,3 +272,258 @@ func TestParseHelipadTLVInvalid(t *testing.T) {
 		t.Fatal("expected error for invalid TLV JSON")
 	}
 }
+
+func TestHelipadSourceParse(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"direction": "incoming",
+		"index": 11,
+		"time": 1700000000,
+		"value_msat": 21000,
+		"action": 2,
+		"sender": "Erin",
+		"tlv": "{\"podcast\":\"Source Podcast\"}"
+	}`)
+
+	invoice, err := HelipadSource{}.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.Identifier != "helipad-11" {
+		t.Errorf("Identifier = %q, want helipad-11", invoice.Identifier)
+	}
+	if invoice.Boostagram == nil || invoice.Boostagram.Podcast != "Source Podcast" {
+		t.Errorf("Boostagram = %+v, want Source Podcast", invoice.Boostagram)
+	}
+}
+
+func TestHelipadSourceParseStreams(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{"direction": "incoming", "index": 12, "time": 1700000000, "value_msat": 3000, "action": 1, "podcast": "Stream Podcast"}`)
+
+	invoice, err := HelipadSource{}.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.Direction != DirectionIncoming || invoice.Boostagram.Action != "stream" || invoice.Amount != 3 {
+		t.Errorf("invoice = %+v, want an incoming 3 sat stream", invoice)
+	}
+}
+
+func TestHelipadSourceParseOutgoing(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"direction": "outgoing",
+		"index": 5,
+		"time": 1700000000,
+		"value_msat": 50000,
+		"action": 2,
+		"sender": "The Show",
+		"podcast": "Other Podcast",
+		"payment_info": {"payment_hash": "sent-hash", "pubkey": "03abc", "fee_msat": 10, "reply_to_idx": 11}
+	}`)
+
+	invoice, err := HelipadSource{}.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.Direction != DirectionOutgoing || invoice.Type != DirectionOutgoing {
+		t.Errorf("Direction = %q, Type = %q, want outgoing", invoice.Direction, invoice.Type)
+	}
+	if invoice.Identifier != "helipad-sent-5" {
+		t.Errorf("Identifier = %q, want helipad-sent-5", invoice.Identifier)
+	}
+	if invoice.PaymentHash != "sent-hash" {
+		t.Errorf("PaymentHash = %q, want the lightning payment hash", invoice.PaymentHash)
+	}
+}
+
+func TestHelipadSourceParseIgnored(t *testing.T) {
+	t.Parallel()
+
+	payloads := []string{
+		`{"direction": "incoming", "index": 12, "value_msat": 1000, "action": 0}`,
+		`{"direction": "incoming", "index": 12, "value_msat": 1000, "action": 3}`,
+		`{"direction": "incoming", "index": 12, "action": 1}`,
+	}
+
+	for _, payload := range payloads {
+		if _, err := (HelipadSource{}).Parse([]byte(payload)); !errors.Is(err, ErrIgnoredPayload) {
+			t.Errorf("Parse(%s) error = %v, want ErrIgnoredPayload", payload, err)
+		}
+	}
+}
+
+// newFakeHelipad serves Helipad's history API behind its password login.
+// Records are returned newest first from the requested index downwards.
+func newFakeHelipad(t *testing.T, boosts, streams, sent []HelipadWebhook) string {
+	t.Helper()
+
+	page := func(records []HelipadWebhook) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("HELIPAD_JWT"); err != nil || cookie.Value != "session" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			index, _ := strconv.ParseInt(r.URL.Query().Get("index"), 10, 64)
+			count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+
+			result := []HelipadWebhook{}
+			for i := len(records) - 1; i >= 0 && len(result) < count; i-- {
+				if records[i].Index <= index {
+					result = append(result, records[i])
+				}
+			}
+			json.NewEncoder(w).Encode(result)
+		}
+	}
+
+	lastIndex := func(records ...[]HelipadWebhook) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var last int64
+			for _, list := range records {
+				for _, record := range list {
+					last = max(last, record.Index)
+				}
+			}
+			json.NewEncoder(w).Encode(last)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("password") != "helipad-password" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "HELIPAD_JWT", Value: "session", Path: "/"})
+	})
+	mux.HandleFunc("/api/v1/index", lastIndex(boosts, streams))
+	mux.HandleFunc("/api/v1/sent_index", lastIndex(sent))
+	mux.HandleFunc("/api/v1/boosts", page(boosts))
+	mux.HandleFunc("/api/v1/streams", page(streams))
+	mux.HandleFunc("/api/v1/sent", page(sent))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestBackfillHelipad(t *testing.T) {
+	boosts := []HelipadWebhook{
+		{Index: 1, Time: 1600000000, ValueMsat: 1000, Action: 2, Sender: "too old"},
+	}
+	for i := int64(2); i <= helipadPageSize+2; i++ {
+		boosts = append(boosts, HelipadWebhook{Index: i, Time: 1700000000 + i, ValueMsat: 21000, Action: 2, Sender: "fan"})
+	}
+	streams := []HelipadWebhook{
+		{Index: helipadPageSize + 3, Time: 1700000500, ValueMsat: 3000, Action: 1},
+	}
+	sent := []HelipadWebhook{
+		{Index: 1, Time: 1700000600, ValueMsat: 50000, Action: 2, PaymentInfo: &HelipadPaymentInfo{PaymentHash: "sent-hash"}},
+	}
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.HelipadURL = newFakeHelipad(t, boosts, streams, sent)
+	cfg.HelipadPassword = "helipad-password"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	existing, _ := json.Marshal(boosts[1])
+	if _, err := NewPipeline(cfg, HelipadSource{}).Process(existing); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	report, err := BackfillHelipad(context.Background(), cfg, time.Unix(1700000000, 0), time.Time{}, false)
+	if err != nil {
+		t.Fatalf("BackfillHelipad() error = %v", err)
+	}
+
+	if report.Transactions != helipadPageSize+3 || report.Existing != 1 || len(report.Recovered) != helipadPageSize+2 {
+		t.Errorf("report = %+v, want every record in the window and the stored boost skipped", report)
+	}
+	if !slices.Contains(report.Recovered, "sent-hash") || !slices.Contains(report.Recovered, "helipad-"+strconv.Itoa(helipadPageSize+3)) {
+		t.Errorf("Recovered = %v, want the stream and the sent boost", report.Recovered)
+	}
+
+	incoming, err := LoadInvoices(cfg, InvoiceFilter{Direction: DirectionIncoming})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	outgoing, err := LoadInvoices(cfg, InvoiceFilter{Direction: DirectionOutgoing})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+
+	if len(incoming) != helipadPageSize+2 {
+		t.Errorf("stored %d incoming payments, want %d", len(incoming), helipadPageSize+2)
+	}
+	if len(outgoing) != 1 || outgoing[0].PaymentHash != "sent-hash" || outgoing[0].Amount != 50 {
+		t.Errorf("outgoing = %+v, want the sent boost", outgoing)
+	}
+}
+
+func TestHelipadStreamsAreNotPublished(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	published := stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	payloads := []string{
+		`{"direction": "incoming", "index": 1, "time": 1700000000, "value_msat": 3000, "action": 1, "podcast": "Stream Podcast"}`,
+		`{"direction": "incoming", "index": 2, "time": 1700000001, "value_msat": 5000, "action": 4, "podcast": "Stream Podcast"}`,
+	}
+	for _, payload := range payloads {
+		if _, err := NewPipeline(cfg, HelipadSource{}).Process([]byte(payload)); err != nil {
+			t.Fatalf("Process(%s) error = %v", payload, err)
+		}
+	}
+
+	if len(*published) != 0 {
+		t.Errorf("published to %v, want streams and automatic payments kept off nostr", *published)
+	}
+	for _, hash := range []string{"helipad-1", "helipad-2"} {
+		if _, err := store.GetOutboxEntry(context.Background(), hash); err == nil {
+			t.Errorf("GetOutboxEntry(%s) error = nil, want no entry", hash)
+		}
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{Direction: DirectionIncoming})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 2 {
+		t.Errorf("stored %d payments, want the stream and the automatic payment", len(invoices))
+	}
+
+	boost := `{"direction": "incoming", "index": 3, "time": 1700000002, "value_msat": 21000, "action": 2, "podcast": "Stream Podcast"}`
+	if _, err := NewPipeline(cfg, HelipadSource{}).Process([]byte(boost)); err != nil {
+		t.Fatalf("Process(boost) error = %v", err)
+	}
+	if len(*published) == 0 {
+		t.Error("boost was not published")
+	}
+}
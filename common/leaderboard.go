@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+type LeaderboardTotal struct {
+	Sats  int64 `json:"sats"`
+	Count int   `json:"count"`
+}
+
+// LeaderboardEntry totals one sender or app. The top-level sats and count
+// cover boosts and zaps together.
+type LeaderboardEntry struct {
+	Name  string           `json:"name"`
+	Sats  int64            `json:"sats"`
+	Count int              `json:"count"`
+	Boost LeaderboardTotal `json:"boost"`
+	Zap   LeaderboardTotal `json:"zap"`
+}
+
+type Leaderboard struct {
+	Senders []LeaderboardEntry `json:"senders"`
+	Apps    []LeaderboardEntry `json:"apps"`
+}
+
+// leaderboardRow is one (name, action) group read from the store.
+type leaderboardRow struct {
+	Name   string
+	Action string
+	Sats   float64
+	Count  int
+}
+
+// rankLeaderboard merges the boost and zap rows for each name, falling back
+// to the same placeholder the boards show for a blank name, and returns the
+// top limit entries by sats.
+func rankLeaderboard(rows []leaderboardRow, blank string, limit int) []LeaderboardEntry {
+	byName := map[string]*LeaderboardEntry{}
+	for _, row := range rows {
+		name := row.Name
+		if name == "" {
+			name = blank
+		}
+
+		entry, ok := byName[name]
+		if !ok {
+			entry = &LeaderboardEntry{Name: name}
+			byName[name] = entry
+		}
+
+		sats := int64(row.Sats)
+		entry.Sats += sats
+		entry.Count += row.Count
+
+		total := &entry.Boost
+		if row.Action == "zap" {
+			total = &entry.Zap
+		}
+		total.Sats += sats
+		total.Count += row.Count
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Sats != entries[j].Sats {
+			return entries[i].Sats > entries[j].Sats
+		}
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+func GetLeaderboard(ctx context.Context, cfg *Config, query map[string]string, sendersLimit, appsLimit int) (Leaderboard, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return Leaderboard{}, err
+	}
+
+	return store.GetLeaderboard(ctx, query, sendersLimit, appsLimit)
+}
+
+// leaderboardLimit reads a limit parameter, falling back to def and capping
+// it at maxLeaderboardLimit.
+func leaderboardLimit(r *http.Request, name string, def int) (int, error) {
+	val := r.FormValue(name)
+	if val == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(val)
+	if err != nil || limit < 1 {
+		return 0, errors.New(name + " must be a positive number")
+	}
+
+	return min(limit, maxLeaderboardLimit), nil
+}
+
+// HandleLeaderboard ranks senders and apps by sats for the boosts matching
+// the /api/boosts filters. limit sets both list lengths; senders_limit and
+// apps_limit override it per list.
+func HandleLeaderboard(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		limit, err := leaderboardLimit(r, "limit", defaultLeaderboardLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sendersLimit, err := leaderboardLimit(r, "senders_limit", limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		appsLimit, err := leaderboardLimit(r, "apps_limit", limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		leaderboard, err := GetLeaderboard(r.Context(), cfg, boostQuery(r), sendersLimit, appsLimit)
+		if errors.Is(err, ErrInvalidBoostQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if err := json.NewEncoder(w).Encode(leaderboard); err != nil {
+			log.Printf("failed to encode leaderboard: %v", err)
+		}
+	}
+}
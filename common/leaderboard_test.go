@@ -0,0 +1,92 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLStoreGetLeaderboard(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	for _, invoice := range []IncomingInvoice{
+		testStoreInvoice("hash-1", 1700000001, Boostagram{Action: "boost", Podcast: "Show", SenderName: "Alice", AppName: "Fountain", ValueMsatTotal: 500000}),
+		testStoreInvoice("hash-2", 1700000002, Boostagram{Action: "zap", Podcast: "Show", SenderName: "Alice", AppName: "Nostr", ValueMsatTotal: 200000}),
+		testStoreInvoice("hash-3", 1700000003, Boostagram{Action: "boost", Podcast: "Show", SenderName: "Bob", AppName: "Fountain"}),
+		testStoreInvoice("hash-4", 1700000004, Boostagram{Action: "boost", Podcast: "Show", AppName: "Castamatic", ValueMsatTotal: 50000}),
+		testStoreInvoice("hash-5", 1700000005, Boostagram{Action: "stream", Podcast: "Show", SenderName: "Carol", AppName: "Fountain", ValueMsatTotal: 900000}),
+		testStoreInvoice("hash-6", 1700000006, Boostagram{Action: "boost", Podcast: "Other", SenderName: "Dave", AppName: "Fountain", ValueMsatTotal: 900000}),
+	} {
+		if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	leaderboard, err := store.GetLeaderboard(ctx, map[string]string{"q[podcast]": "Show"}, 10, 2)
+	if err != nil {
+		t.Fatalf("GetLeaderboard() error = %v", err)
+	}
+
+	wantSenders := []LeaderboardEntry{
+		{Name: "Alice", Sats: 700, Count: 2, Boost: LeaderboardTotal{Sats: 500, Count: 1}, Zap: LeaderboardTotal{Sats: 200, Count: 1}},
+		{Name: "Bob", Sats: 100, Count: 1, Boost: LeaderboardTotal{Sats: 100, Count: 1}},
+		{Name: "Anonymous", Sats: 50, Count: 1, Boost: LeaderboardTotal{Sats: 50, Count: 1}},
+	}
+	if len(leaderboard.Senders) != len(wantSenders) {
+		t.Fatalf("Senders = %+v, want %+v", leaderboard.Senders, wantSenders)
+	}
+	for i, want := range wantSenders {
+		if leaderboard.Senders[i] != want {
+			t.Errorf("Senders[%d] = %+v, want %+v", i, leaderboard.Senders[i], want)
+		}
+	}
+
+	if len(leaderboard.Apps) != 2 || leaderboard.Apps[0].Name != "Fountain" || leaderboard.Apps[0].Sats != 600 || leaderboard.Apps[1].Name != "Nostr" {
+		t.Errorf("Apps = %+v, want Fountain then Nostr", leaderboard.Apps)
+	}
+}
+
+func TestHandleLeaderboard(t *testing.T) {
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	for i, sender := range []string{"Alice", "Bob", "Carol"} {
+		invoice := testStoreInvoice("hash-"+sender, float64(1700000001+i), Boostagram{Action: "boost", Podcast: "Show", EventGuid: "event-1", SenderName: sender, AppName: "Fountain"})
+		if _, err := store.SaveInvoiceIfNew(context.Background(), invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	HandleLeaderboard(cfg)(rec, httptest.NewRequest(http.MethodGet, "/api/leaderboard?eventGuid=event-1&limit=2&apps_limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var leaderboard Leaderboard
+	if err := json.Unmarshal(rec.Body.Bytes(), &leaderboard); err != nil {
+		t.Fatalf("failed to decode leaderboard: %v", err)
+	}
+	if len(leaderboard.Senders) != 2 || len(leaderboard.Apps) != 1 || leaderboard.Apps[0].Count != 3 {
+		t.Errorf("leaderboard = %+v, want two senders and one app", leaderboard)
+	}
+
+	for _, target := range []string{"/api/leaderboard?limit=0", "/api/leaderboard?senders_limit=x", "/api/leaderboard?direction=sideways"} {
+		rec := httptest.NewRecorder()
+		HandleLeaderboard(cfg)(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LNbitsPayment covers both the core payment webhook and the LNURLp
+// extension's webhook, which puts the comment at the top level.
+type LNbitsPayment struct {
+	PaymentHash string      `json:"payment_hash"`
+	CheckingID  string      `json:"checking_id"`
+	Amount      lndInt      `json:"amount"` // msat, negative for outgoing
+	Memo        string      `json:"memo"`
+	Comment     string      `json:"comment"`
+	Time        lnbitsTime  `json:"time"`
+	Pending     bool        `json:"pending"`
+	Status      string      `json:"status"`
+	Extra       LNbitsExtra `json:"extra"`
+}
+
+// LNbitsExtra is the extension data LNbits attaches to a payment.
+type LNbitsExtra struct {
+	Tag            string             `json:"tag"`
+	Comment        string             `json:"comment"`
+	Name           string             `json:"name"`    // TipJar
+	Message        string             `json:"message"` // TipJar
+	PayerData      *InvoicePayerData  `json:"payer_data"`
+	PayerDataLUD18 *InvoicePayerData  `json:"payerData"`
+	TLVRecords     []InvoiceTLVRecord `json:"tlv_records"`
+	CustomRecords  map[string]string  `json:"custom_records"`
+}
+
+// lnbitsTime accepts the unix timestamps of older LNbits releases and the
+// ISO 8601 strings of newer ones.
+type lnbitsTime int64
+
+func (t *lnbitsTime) UnmarshalJSON(data []byte) error {
+	val := strings.Trim(string(data), `"`)
+	if val == "" || val == "null" {
+		*t = 0
+		return nil
+	}
+
+	if num, err := strconv.ParseFloat(val, 64); err == nil {
+		*t = lnbitsTime(num)
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999", "2006-01-02 15:04:05.999999"} {
+		if tm, err := time.Parse(layout, val); err == nil {
+			*t = lnbitsTime(tm.Unix())
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid time %s", data)
+}
+
+type LNbitsSource struct{}
+
+func (LNbitsSource) Name() string {
+	return "lnbits"
+}
+
+func (s LNbitsSource) Parse(payload []byte) (IncomingInvoice, error) {
+	return s.ParseReceived(payload, time.Now())
+}
+
+// ParseReceived dates payments that carry no time by when the payload was
+// received.
+func (LNbitsSource) ParseReceived(payload []byte, received time.Time) (IncomingInvoice, error) {
+	return parseLNbitsPayment(payload, received)
+}
+
+func ParseLNbitsPayment(payload []byte) (IncomingInvoice, error) {
+	return parseLNbitsPayment(payload, time.Now())
+}
+
+func parseLNbitsPayment(payload []byte, received time.Time) (IncomingInvoice, error) {
+	var payment LNbitsPayment
+
+	if err := json.Unmarshal(payload, &payment); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal LNbits payload: %w", err)
+	}
+
+	if payment.Pending || (payment.Status != "" && payment.Status != "success") {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	if payment.Amount <= 0 {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	paymentHash := payment.PaymentHash
+	if paymentHash == "" {
+		paymentHash = payment.CheckingID
+	}
+	if paymentHash == "" {
+		return IncomingInvoice{}, errors.New("LNbits payment has no payment_hash")
+	}
+
+	created := time.Unix(int64(payment.Time), 0)
+	if payment.Time == 0 {
+		created = received
+	}
+
+	sats := float64(int64(payment.Amount) / 1000)
+	invoice := IncomingInvoice{
+		Amount:       sats,
+		Value:        sats,
+		Description:  payment.Memo,
+		PaymentHash:  paymentHash,
+		Identifier:   paymentHash,
+		Type:         "incoming",
+		CreationDate: float64(created.Unix()),
+		CreatedAt:    created.UTC().Format(time.RFC3339),
+	}
+
+	metadata, err := lnbitsMetadata(payment)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+	invoice.Metadata = metadata
+
+	if err := applyInvoiceMetadata(&invoice); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to apply invoice metadata: %w", err)
+	}
+
+	return invoice, nil
+}
+
+// lnbitsMetadata gathers the LNURL comment, payer data and keysend TLV
+// records from wherever the extension that took the payment put them.
+func lnbitsMetadata(payment LNbitsPayment) (*InvoiceMetadata, error) {
+	extra := payment.Extra
+	metadata := &InvoiceMetadata{
+		Comment:    payment.Comment,
+		TLVRecords: extra.TLVRecords,
+	}
+
+	if metadata.Comment == "" {
+		metadata.Comment = extra.Comment
+	}
+	if metadata.Comment == "" {
+		metadata.Comment = extra.Message
+	}
+
+	switch {
+	case extra.PayerData != nil:
+		metadata.PayerData = extra.PayerData
+	case extra.PayerDataLUD18 != nil:
+		metadata.PayerData = extra.PayerDataLUD18
+	case extra.Name != "":
+		metadata.PayerData = &InvoicePayerData{Name: extra.Name}
+	}
+
+	keys := make([]string, 0, len(extra.CustomRecords))
+	for key := range extra.CustomRecords {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		recordType, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid custom record type %q", key)
+		}
+		metadata.TLVRecords = append(metadata.TLVRecords, InvoiceTLVRecord{Type: recordType, Value: extra.CustomRecords[key]})
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLNbitsPayment(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"checking_id": "abc123",
+		"payment_hash": "abc123",
+		"pending": false,
+		"amount": 100000,
+		"memo": "Boost the show",
+		"time": 1700000000,
+		"extra": {
+			"tag": "lnurlp",
+			"comment": "rss::payment::boost https://example.com/pay",
+			"payer_data": {"name": "Alice", "email": "alice@example.com"}
+		}
+	}`)
+
+	invoice, err := ParseLNbitsPayment(payload)
+	if err != nil {
+		t.Fatalf("ParseLNbitsPayment() error = %v", err)
+	}
+
+	if invoice.Amount != 100 {
+		t.Errorf("Amount = %v, want 100 (msats converted to sats)", invoice.Amount)
+	}
+
+	if invoice.Comment != "rss::payment::boost https://example.com/pay" {
+		t.Errorf("Comment = %q, want rss payment comment", invoice.Comment)
+	}
+
+	if invoice.PayerName != "Alice" {
+		t.Errorf("PayerName = %q, want Alice", invoice.PayerName)
+	}
+
+	if invoice.PaymentHash != "abc123" {
+		t.Errorf("PaymentHash = %q, want abc123", invoice.PaymentHash)
+	}
+
+	if invoice.Identifier != "abc123" {
+		t.Errorf("Identifier = %q, want abc123", invoice.Identifier)
+	}
+
+	if invoice.Description != "Boost the show" {
+		t.Errorf("Description = %q, want the memo", invoice.Description)
+	}
+
+	if invoice.CreationDate != 1700000000 {
+		t.Errorf("CreationDate = %v, want 1700000000", invoice.CreationDate)
+	}
+}
+
+func TestParseLNbitsPaymentLNURLpWebhook(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"payment_hash": "def456",
+		"payment_request": "lnbc50n1...",
+		"amount": 5000,
+		"comment": "Great episode",
+		"webhook_data": "",
+		"lnurlp": "link1",
+		"body": {}
+	}`)
+
+	invoice, err := ParseLNbitsPayment(payload)
+	if err != nil {
+		t.Fatalf("ParseLNbitsPayment() error = %v", err)
+	}
+
+	if invoice.Comment != "Great episode" {
+		t.Errorf("Comment = %q, want Great episode", invoice.Comment)
+	}
+
+	if invoice.Amount != 5 {
+		t.Errorf("Amount = %v, want 5", invoice.Amount)
+	}
+
+	if invoice.CreatedAt == "" {
+		t.Fatal("CreatedAt should default to the time the webhook arrived")
+	}
+}
+
+func TestParseLNbitsPaymentUsesISOTime(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"payment_hash": "abc123",
+		"amount": 5000,
+		"status": "success",
+		"time": "2023-11-14T22:13:20"
+	}`)
+
+	invoice, err := ParseLNbitsPayment(payload)
+	if err != nil {
+		t.Fatalf("ParseLNbitsPayment() error = %v", err)
+	}
+
+	want := "2023-11-14T22:13:20Z"
+	if invoice.CreatedAt != want {
+		t.Errorf("CreatedAt = %q, want %q", invoice.CreatedAt, want)
+	}
+}
+
+func TestLNbitsSourceParseReceived(t *testing.T) {
+	t.Parallel()
+
+	received := time.Unix(1700000000, 0)
+	invoice, err := LNbitsSource{}.ParseReceived([]byte(`{"payment_hash": "abc123", "amount": 5000, "status": "success"}`), received)
+	if err != nil {
+		t.Fatalf("ParseReceived() error = %v", err)
+	}
+
+	if invoice.CreationDate != 1700000000 {
+		t.Errorf("CreationDate = %v, want the received time for a payment without one", invoice.CreationDate)
+	}
+}
+
+func TestParseLNbitsPaymentTipJar(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte(`{
+		"payment_hash": "abc123",
+		"amount": 21000,
+		"time": 1700000000,
+		"extra": {"tag": "tipjar", "name": "Bob", "message": "Keep it up"}
+	}`)
+
+	invoice, err := ParseLNbitsPayment(payload)
+	if err != nil {
+		t.Fatalf("ParseLNbitsPayment() error = %v", err)
+	}
+
+	if invoice.PayerName != "Bob" {
+		t.Errorf("PayerName = %q, want Bob", invoice.PayerName)
+	}
+
+	if invoice.Comment != "Keep it up" {
+		t.Errorf("Comment = %q, want the tip message", invoice.Comment)
+	}
+}
+
+func TestParseLNbitsPaymentKeysendTLV(t *testing.T) {
+	t.Parallel()
+
+	boostagram, err := json.Marshal(Boostagram{Action: "boost", Podcast: "Podcasting 2.0", SenderName: "carol"})
+	if err != nil {
+		t.Fatalf("failed to marshal boostagram: %v", err)
+	}
+
+	payload := []byte(`{
+		"payment_hash": "abc123",
+		"amount": 21000,
+		"time": 1700000000,
+		"extra": {"custom_records": {"7629169": "` + hex.EncodeToString(boostagram) + `"}}
+	}`)
+
+	invoice, err := ParseLNbitsPayment(payload)
+	if err != nil {
+		t.Fatalf("ParseLNbitsPayment() error = %v", err)
+	}
+
+	if invoice.Boostagram == nil {
+		t.Fatal("Boostagram should be set from the podcast TLV record")
+	}
+
+	if invoice.Boostagram.SenderName != "carol" {
+		t.Errorf("SenderName = %q, want carol", invoice.Boostagram.SenderName)
+	}
+}
+
+func TestParseLNbitsPaymentIgnored(t *testing.T) {
+	t.Parallel()
+
+	payloads := []string{
+		`{"payment_hash": "abc123", "amount": 5000, "pending": true}`,
+		`{"payment_hash": "abc123", "amount": 5000, "status": "failed"}`,
+		`{"payment_hash": "abc123", "amount": -5000}`,
+	}
+
+	for _, payload := range payloads {
+		if _, err := ParseLNbitsPayment([]byte(payload)); !errors.Is(err, ErrIgnoredPayload) {
+			t.Errorf("ParseLNbitsPayment(%s) error = %v, want ErrIgnoredPayload", payload, err)
+		}
+	}
+}
+
+func TestParseLNbitsPaymentInvalidJSON(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseLNbitsPayment([]byte(`{invalid`))
+	if err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+func TestHandleLNbitsWebhook(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.LNbitsWebhookToken = "lnbits-token"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	payload := `{"payment_hash":"abc123","amount":50000,"time":1700000000,"extra":{"comment":"hello"}}`
+
+	rec := httptest.NewRecorder()
+	HandleLNbitsWebhook(cfg)(rec, httptest.NewRequest(http.MethodPost, "/api/lnbits?token=wrong", strings.NewReader(payload)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status with wrong token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = httptest.NewRecorder()
+	HandleLNbitsWebhook(cfg)(rec, httptest.NewRequest(http.MethodPost, "/api/lnbits?token=lnbits-token", strings.NewReader(payload)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != "abc123" || invoices[0].Amount != 50 || invoices[0].Comment != "hello" {
+		t.Fatalf("invoices = %+v, want the LNbits payment", invoices)
+	}
+}
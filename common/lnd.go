@@ -0,0 +1,293 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lndInvoiceSettled = "SETTLED"
+
+// lndInt accepts the quoted 64-bit integers emitted by LND's REST gateway as
+// well as plain JSON numbers.
+type lndInt int64
+
+func (n *lndInt) UnmarshalJSON(data []byte) error {
+	val := strings.Trim(string(data), `"`)
+	if val == "" || val == "null" {
+		*n = 0
+		return nil
+	}
+
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+
+	*n = lndInt(num)
+	return nil
+}
+
+type LNDInvoice struct {
+	Memo         string           `json:"memo"`
+	RHash        []byte           `json:"r_hash"`
+	ValueMsat    lndInt           `json:"value_msat"`
+	AmtPaidMsat  lndInt           `json:"amt_paid_msat"`
+	CreationDate lndInt           `json:"creation_date"`
+	SettleDate   lndInt           `json:"settle_date"`
+	SettleIndex  lndInt           `json:"settle_index"`
+	State        string           `json:"state"`
+	IsKeysend    bool             `json:"is_keysend"`
+	Htlcs        []LNDInvoiceHTLC `json:"htlcs"`
+}
+
+type LNDInvoiceHTLC struct {
+	State         string            `json:"state"`
+	AmtMsat       lndInt            `json:"amt_msat"`
+	CustomRecords map[string][]byte `json:"custom_records"`
+}
+
+type lndStreamMessage struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type LNDSource struct{}
+
+func (LNDSource) Name() string {
+	return "lnd"
+}
+
+func (LNDSource) Parse(payload []byte) (IncomingInvoice, error) {
+	var invoice LNDInvoice
+	if err := json.Unmarshal(payload, &invoice); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal LND invoice: %w", err)
+	}
+
+	if invoice.State != lndInvoiceSettled {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	return LNDInvoiceToInvoice(invoice)
+}
+
+func LNDInvoiceToInvoice(lnd LNDInvoice) (IncomingInvoice, error) {
+	if len(lnd.RHash) == 0 {
+		return IncomingInvoice{}, errors.New("LND invoice has no r_hash")
+	}
+
+	created := int64(lnd.SettleDate)
+	if created == 0 {
+		created = int64(lnd.CreationDate)
+	}
+
+	sats := float64(int64(lnd.AmtPaidMsat) / 1000)
+	paymentHash := hex.EncodeToString(lnd.RHash)
+
+	invoice := IncomingInvoice{
+		Amount:       sats,
+		Value:        sats,
+		Description:  lnd.Memo,
+		PaymentHash:  paymentHash,
+		Identifier:   paymentHash,
+		Type:         "incoming",
+		CreationDate: float64(created),
+		CreatedAt:    time.Unix(created, 0).UTC().Format(time.RFC3339),
+	}
+
+	records := []InvoiceTLVRecord{}
+	for _, htlc := range lnd.Htlcs {
+		if htlc.State != "" && htlc.State != lndInvoiceSettled {
+			continue
+		}
+
+		for key, value := range htlc.CustomRecords {
+			recordType, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return IncomingInvoice{}, fmt.Errorf("invalid custom record type %q", key)
+			}
+			records = append(records, InvoiceTLVRecord{Type: recordType, Value: hex.EncodeToString(value)})
+		}
+	}
+
+	if len(records) > 0 {
+		invoice.Metadata = &InvoiceMetadata{TLVRecords: records}
+	}
+
+	if err := applyInvoiceMetadata(&invoice); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to apply invoice metadata: %w", err)
+	}
+
+	return invoice, nil
+}
+
+func lndHTTPClient(cfg *Config) (*http.Client, error) {
+	if cfg.LNDTLSCertPath == "" {
+		return &http.Client{}, nil
+	}
+
+	pem, err := os.ReadFile(cfg.LNDTLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LND TLS certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("LND TLS certificate is not valid PEM")
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+// SubscribeLNDInvoices streams settled invoices from LND's REST API until the
+// stream ends or ctx is cancelled. A non-zero settleIndex asks LND to replay
+// invoices settled after that index first.
+func SubscribeLNDInvoices(ctx context.Context, cfg *Config, settleIndex int64, handle func(invoice LNDInvoice, raw []byte)) error {
+	client, err := lndHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if settleIndex > 0 {
+		query.Set("settle_index", strconv.FormatInt(settleIndex, 10))
+	}
+
+	endpoint := cfg.LNDRestURL + "/v1/invoices/subscribe"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Grpc-Metadata-macaroon", cfg.LNDMacaroon)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("LND returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg lndStreamMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Error != nil {
+			return fmt.Errorf("LND stream error %d: %s", msg.Error.Code, msg.Error.Message)
+		}
+
+		if len(msg.Result) == 0 {
+			continue
+		}
+
+		var invoice LNDInvoice
+		if err := json.Unmarshal(msg.Result, &invoice); err != nil {
+			log.Printf("failed to decode LND invoice: %v", err)
+			continue
+		}
+
+		if invoice.State != lndInvoiceSettled {
+			continue
+		}
+
+		handle(invoice, msg.Result)
+	}
+}
+
+// lndResumeIndex returns the settle index of the last archived LND invoice,
+// so a restarted subscriber has LND replay the invoices settled while it was
+// down.
+func lndResumeIndex(cfg *Config) (int64, error) {
+	payloads, err := ListPayloads(cfg, PayloadFilter{Sources: []string{LNDSource{}.Name()}, Newest: true, Limit: 1})
+	if err != nil || len(payloads) == 0 {
+		return 0, err
+	}
+
+	var invoice LNDInvoice
+	if err := json.Unmarshal(payloads[0].Payload, &invoice); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal archived LND invoice %d: %w", payloads[0].ID, err)
+	}
+
+	return int64(invoice.SettleIndex), nil
+}
+
+// RunLNDSubscriber feeds settled LND invoices into the pipeline, reconnecting
+// with backoff and resuming from the last settle index it has seen, starting
+// with the last one archived.
+func RunLNDSubscriber(ctx context.Context, cfg *Config) {
+	settleIndex, err := lndResumeIndex(cfg)
+	if err != nil {
+		log.Printf("failed to find the last LND settle index, only new invoices will be received: %v", err)
+	}
+	backoff := time.Second
+
+	for {
+		received := false
+		err := SubscribeLNDInvoices(ctx, cfg, settleIndex, func(invoice LNDInvoice, raw []byte) {
+			received = true
+
+			pipeline := NewPipeline(cfg, LNDSource{})
+			if _, err := pipeline.Ingest(nil, raw); err != nil && !errors.Is(err, ErrIgnoredPayload) {
+				log.Printf("failed to process LND invoice %x: %v", invoice.RHash, err)
+			}
+
+			if int64(invoice.SettleIndex) > settleIndex {
+				settleIndex = int64(invoice.SettleIndex)
+			}
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if received {
+			backoff = time.Second
+		}
+
+		if err != nil {
+			log.Printf("LND invoice subscription failed, retrying in %s: %v", backoff, err)
+		} else {
+			log.Printf("LND invoice subscription closed, reconnecting in %s", backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
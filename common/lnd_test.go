@@ -0,0 +1,229 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const lndTestMacaroon = "0201036c6e64"
+
+func lndTestInvoice(t *testing.T, hash string, state string, settleIndex int) map[string]any {
+	t.Helper()
+
+	boostagram, err := json.Marshal(Boostagram{Action: "boost", Podcast: "Podcasting 2.0", SenderName: "alice", ValueMsatTotal: 21000})
+	if err != nil {
+		t.Fatalf("failed to marshal boostagram: %v", err)
+	}
+
+	rHash, _ := hex.DecodeString(hash)
+
+	return map[string]any{
+		"memo":          "",
+		"r_hash":        base64.StdEncoding.EncodeToString(rHash),
+		"value_msat":    "0",
+		"amt_paid_msat": "21000",
+		"creation_date": "1700000000",
+		"settle_date":   "1700000005",
+		"settle_index":  fmt.Sprint(settleIndex),
+		"state":         state,
+		"is_keysend":    true,
+		"htlcs": []map[string]any{{
+			"state":    "SETTLED",
+			"amt_msat": "21000",
+			"custom_records": map[string]string{
+				"7629169":    base64.StdEncoding.EncodeToString(boostagram),
+				"5482373484": base64.StdEncoding.EncodeToString([]byte("preimage")),
+			},
+		}},
+	}
+}
+
+// fakeLND serves LND's invoice subscription stream with the given invoices
+// and records the settle_index each subscription resumes from.
+func fakeLND(t *testing.T, invoices ...map[string]any) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	resumed := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/invoices/subscribe" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Header.Get("Grpc-Metadata-macaroon") != lndTestMacaroon {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":2,"message":"verification failed"}`)
+			return
+		}
+
+		mu.Lock()
+		resumed = append(resumed, r.URL.Query().Get("settle_index"))
+		mu.Unlock()
+
+		encoder := json.NewEncoder(w)
+		for _, invoice := range invoices {
+			encoder.Encode(map[string]any{"result": invoice})
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, resumed...)
+	}
+}
+
+func TestLNDSourceParse(t *testing.T) {
+	t.Parallel()
+
+	hash := "aa" + fmt.Sprintf("%062d", 1)
+	payload, _ := json.Marshal(lndTestInvoice(t, hash, "SETTLED", 1))
+
+	invoice, err := LNDSource{}.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.PaymentHash != hash || invoice.Identifier != hash {
+		t.Errorf("PaymentHash = %q, want %q", invoice.PaymentHash, hash)
+	}
+	if invoice.Amount != 21 || invoice.Value != 21 {
+		t.Errorf("Amount = %v, want 21 sats", invoice.Amount)
+	}
+	if invoice.CreationDate != 1700000005 {
+		t.Errorf("CreationDate = %v, want the settle date", invoice.CreationDate)
+	}
+	if invoice.Boostagram == nil || invoice.Boostagram.SenderName != "alice" || invoice.Boostagram.Podcast != "Podcasting 2.0" {
+		t.Fatalf("Boostagram = %+v, want the 7629169 record", invoice.Boostagram)
+	}
+	if len(invoice.Metadata.TLVRecords) != 2 {
+		t.Errorf("TLVRecords = %+v, want both custom records", invoice.Metadata.TLVRecords)
+	}
+
+	open, _ := json.Marshal(lndTestInvoice(t, hash, "OPEN", 0))
+	if _, err := (LNDSource{}).Parse(open); err != ErrIgnoredPayload {
+		t.Errorf("Parse(open) error = %v, want ErrIgnoredPayload", err)
+	}
+}
+
+func TestSubscribeLNDInvoices(t *testing.T) {
+	t.Parallel()
+
+	settledHash := fmt.Sprintf("%064d", 2)
+	server, resumed := fakeLND(t,
+		lndTestInvoice(t, fmt.Sprintf("%064d", 1), "OPEN", 0),
+		lndTestInvoice(t, settledHash, "SETTLED", 7),
+	)
+
+	cfg := &Config{LNDRestURL: server.URL, LNDMacaroon: lndTestMacaroon}
+
+	var got []LNDInvoice
+	err := SubscribeLNDInvoices(context.Background(), cfg, 5, func(invoice LNDInvoice, raw []byte) {
+		got = append(got, invoice)
+	})
+	if err != nil {
+		t.Fatalf("SubscribeLNDInvoices() error = %v", err)
+	}
+
+	if len(got) != 1 || hex.EncodeToString(got[0].RHash) != settledHash || got[0].SettleIndex != 7 {
+		t.Fatalf("invoices = %+v, want only the settled invoice", got)
+	}
+	if got := resumed(); len(got) != 1 || got[0] != "5" {
+		t.Errorf("settle_index = %v, want 5", got)
+	}
+
+	cfg.LNDMacaroon = "00"
+	if err := SubscribeLNDInvoices(context.Background(), cfg, 0, func(LNDInvoice, []byte) {}); err == nil {
+		t.Error("SubscribeLNDInvoices() with bad macaroon error = nil, want error")
+	}
+}
+
+func TestRunLNDSubscriber(t *testing.T) {
+	hash := fmt.Sprintf("%064d", 3)
+	server, resumed := fakeLND(t, lndTestInvoice(t, hash, "SETTLED", 9))
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.LNDRestURL = server.URL
+	cfg.LNDMacaroon = lndTestMacaroon
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	// An invoice archived before a restart, followed by another source's
+	// payload, sets where the first subscription resumes.
+	archived, err := json.Marshal(lndTestInvoice(t, fmt.Sprintf("%064d", 4), "SETTLED", 4))
+	if err != nil {
+		t.Fatalf("failed to marshal invoice: %v", err)
+	}
+	for _, payload := range []ArchivedPayload{
+		{Source: "lnd", Payload: archived, Status: PayloadProcessed, ReceivedAt: time.Now().UTC()},
+		{Source: "cln", Payload: []byte(`{}`), Status: PayloadFailed, ReceivedAt: time.Now().UTC()},
+	} {
+		if _, err := (DatabaseStore{Config: cfg}).ArchivePayload(payload); err != nil {
+			t.Fatalf("ArchivePayload() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunLNDSubscriber(ctx, cfg)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		payloads, err := ListPayloads(cfg, PayloadFilter{Sources: []string{"lnd"}, Statuses: []PayloadStatus{PayloadProcessed}})
+		if err != nil {
+			t.Fatalf("ListPayloads() error = %v", err)
+		}
+		if len(payloads) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the LND invoice to be processed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != hash || invoices[0].Boostagram == nil {
+		t.Fatalf("invoices = %+v, want %s with boostagram", invoices, hash)
+	}
+
+	// The fake closes the stream after each batch, so the subscriber
+	// reconnects and should resume after the invoice it already saw.
+	deadline = time.Now().Add(5 * time.Second)
+	for len(resumed()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := resumed(); len(got) < 2 || got[0] != "4" || got[1] != "9" {
+		t.Errorf("resumed from %v, want the archived settle_index 4 and then 9", got)
+	}
+}
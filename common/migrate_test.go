@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	postgres, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatalf("loadMigrations(postgres) error = %v", err)
+	}
+
+	sqlite, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatalf("loadMigrations(sqlite) error = %v", err)
+	}
+
+	if len(postgres) == 0 || len(postgres) != len(sqlite) {
+		t.Fatalf("got %d postgres and %d sqlite migrations, want matching sets", len(postgres), len(sqlite))
+	}
+
+	for i := range postgres {
+		if postgres[i].Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, postgres[i].Version, i+1)
+		}
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("postgres %d_%s does not match sqlite %d_%s", postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	store, err := OpenStore(cfg)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+
+	applied, err := store.MigrateUp(ctx, 1)
+	if err != nil {
+		t.Fatalf("MigrateUp(1) error = %v", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Fatalf("MigrateUp(1) applied %+v, want only version 1", applied)
+	}
+
+	applied, err = store.MigrateUp(ctx, 0)
+	if err != nil {
+		t.Fatalf("MigrateUp(0) error = %v", err)
+	}
+	if len(applied) != len(migrations)-1 {
+		t.Fatalf("MigrateUp(0) applied %d migrations, want %d", len(applied), len(migrations)-1)
+	}
+
+	applied, err = store.MigrateUp(ctx, 0)
+	if err != nil {
+		t.Fatalf("second MigrateUp() error = %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second MigrateUp() applied %+v, want nothing", applied)
+	}
+
+	if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice("hash-1", 1700000000, Boostagram{})); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() after migrating error = %v", err)
+	}
+
+	statuses, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d_%s is pending, want applied", status.Version, status.Name)
+		}
+	}
+
+	reverted, err := store.MigrateDown(ctx, 0)
+	if err != nil {
+		t.Fatalf("MigrateDown(0) error = %v", err)
+	}
+	if len(reverted) != len(migrations) || reverted[0].Version != migrations[len(migrations)-1].Version {
+		t.Fatalf("MigrateDown(0) reverted %+v, want all migrations newest first", reverted)
+	}
+
+	if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice("hash-1", 1700000000, Boostagram{})); err == nil {
+		t.Error("SaveInvoiceIfNew() after reverting error = nil, want missing table")
+	}
+}
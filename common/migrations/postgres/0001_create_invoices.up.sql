@@ -0,0 +1,28 @@
+-- IF NOT EXISTS lets deployments that predate migrations adopt them in place.
+CREATE TABLE IF NOT EXISTS invoices (
+    amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+    boostagram JSONB,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT '',
+    creation_date DOUBLE PRECISION NOT NULL DEFAULT 0,
+    description TEXT NOT NULL DEFAULT '',
+    identifier TEXT NOT NULL DEFAULT '',
+    payer_name TEXT NOT NULL DEFAULT '',
+    payment_hash TEXT NOT NULL,
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    podcast TEXT NOT NULL DEFAULT '',
+    episode TEXT NOT NULL DEFAULT '',
+    app_name TEXT NOT NULL DEFAULT '',
+    sender_name TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    value_msat_total BIGINT NOT NULL DEFAULT 0,
+    feed_id DOUBLE PRECISION,
+    item_id DOUBLE PRECISION,
+    guid TEXT NOT NULL DEFAULT '',
+    episode_guid TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL DEFAULT '',
+    event_guid TEXT NOT NULL DEFAULT ''
+);
+
+-- saveInvoice relies on ON CONFLICT (payment_hash).
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_payment_hash_key ON invoices (payment_hash);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS invoices_direction_idx;
+ALTER TABLE invoices DROP COLUMN direction;
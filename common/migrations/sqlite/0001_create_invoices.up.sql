@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS invoices (
+    amount REAL NOT NULL DEFAULT 0,
+    boostagram TEXT,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT '',
+    creation_date REAL NOT NULL DEFAULT 0,
+    description TEXT NOT NULL DEFAULT '',
+    identifier TEXT NOT NULL DEFAULT '',
+    payer_name TEXT NOT NULL DEFAULT '',
+    payment_hash TEXT NOT NULL,
+    value REAL NOT NULL DEFAULT 0,
+    podcast TEXT NOT NULL DEFAULT '',
+    episode TEXT NOT NULL DEFAULT '',
+    app_name TEXT NOT NULL DEFAULT '',
+    sender_name TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    value_msat_total INTEGER NOT NULL DEFAULT 0,
+    feed_id REAL,
+    item_id REAL,
+    guid TEXT NOT NULL DEFAULT '',
+    episode_guid TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL DEFAULT '',
+    event_guid TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_payment_hash_key ON invoices (payment_hash);
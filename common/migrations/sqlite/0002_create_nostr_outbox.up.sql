@@ -0,0 +1,11 @@
+-- Timestamps are written by the application in UTC, see sqliteDSN.
+CREATE TABLE IF NOT EXISTS nostr_outbox (
+    payment_hash TEXT PRIMARY KEY,
+    content TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    acked_relays TEXT NOT NULL DEFAULT '[]',
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at DATETIME NOT NULL,
+    published_at DATETIME,
+    created_at DATETIME NOT NULL
+);
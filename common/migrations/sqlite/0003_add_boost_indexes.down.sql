@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS invoices_podcast_idx;
+DROP INDEX IF EXISTS invoices_episode_guid_idx;
+DROP INDEX IF EXISTS invoices_event_guid_idx;
+DROP INDEX IF EXISTS invoices_creation_date_idx;
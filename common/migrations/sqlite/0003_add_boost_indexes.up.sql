@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS invoices_creation_date_idx ON invoices (creation_date);
+CREATE INDEX IF NOT EXISTS invoices_event_guid_idx ON invoices (event_guid);
+CREATE INDEX IF NOT EXISTS invoices_episode_guid_idx ON invoices (episode_guid);
+CREATE INDEX IF NOT EXISTS invoices_podcast_idx ON invoices (podcast);
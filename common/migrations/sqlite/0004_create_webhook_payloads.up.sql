@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS webhook_payloads (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    headers TEXT NOT NULL DEFAULT '{}',
+    payload BLOB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'received',
+    stage TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    payment_hash TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    received_at DATETIME NOT NULL,
+    processed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS webhook_payloads_status_idx ON webhook_payloads (status, received_at);
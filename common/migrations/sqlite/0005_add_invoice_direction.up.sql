@@ -0,0 +1,4 @@
+-- Rows stored before outgoing payments were tracked are all incoming.
+ALTER TABLE invoices ADD COLUMN direction TEXT NOT NULL DEFAULT 'incoming';
+
+CREATE INDEX IF NOT EXISTS invoices_direction_idx ON invoices (direction, creation_date);
@@ -0,0 +1,2 @@
+-- Hidden boosts are kept but left off the boards.
+ALTER TABLE invoices ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
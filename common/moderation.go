@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var errBoostNotFound = errors.New("boost not found")
+
+// ModerationChange hides a boost from the boards or restores it. Boosts are
+// named by the identifier /api/boosts returns.
+type ModerationChange struct {
+	Identifier string `json:"identifier"`
+	Hidden     bool   `json:"hidden"`
+}
+
+var sharedModerationBroker = NewBroker[ModerationChange]()
+
+// SetBoostHidden applies a moderation change and announces it to the live
+// feeds served by this process.
+func SetBoostHidden(ctx context.Context, cfg *Config, change ModerationChange) error {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	found, err := store.SetBoostHidden(ctx, change.Identifier, change.Hidden)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errBoostNotFound
+	}
+
+	sharedTotals.Invalidate()
+	sharedModerationBroker.Publish(change)
+	return nil
+}
+
+// HandleModeration hides (hidden=true) or restores (hidden=false) the boost
+// named by ?identifier=. It is authorised with CRON_SECRET like the other
+// admin endpoints.
+func HandleModeration(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		status, ok := ValidateBearerToken(authHeader, cfg.CronSecret)
+		if !ok {
+			if status == http.StatusInternalServerError {
+				log.Print("CRON_SECRET environment variable not set")
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		change := ModerationChange{Identifier: r.FormValue("identifier")}
+		if change.Identifier == "" {
+			http.Error(w, "identifier is required", http.StatusBadRequest)
+			return
+		}
+
+		hidden, err := strconv.ParseBool(r.FormValue("hidden"))
+		if err != nil {
+			http.Error(w, "hidden must be true or false", http.StatusBadRequest)
+			return
+		}
+		change.Hidden = hidden
+
+		err = SetBoostHidden(r.Context(), cfg, change)
+		if errors.Is(err, errBoostNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("failed to moderate boost %s: %v", change.Identifier, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
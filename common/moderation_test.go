@@ -0,0 +1,87 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleModeration(t *testing.T) {
+	cfg := testLiveConfig(t)
+	cfg.CronSecret = "cron-secret"
+	ctx := context.Background()
+
+	testIngestBoost(t, cfg, "hash-1", 1700000001, Boostagram{Action: "boost", Podcast: "Moderated Show", SenderName: "Spammer"})
+	testIngestBoost(t, cfg, "hash-2", 1700000002, Boostagram{Action: "boost", Podcast: "Moderated Show", SenderName: "Alice"})
+
+	changes, unsubscribe := sharedModerationBroker.Subscribe()
+	defer unsubscribe()
+
+	moderate := func(target, token string) int {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		HandleModeration(cfg)(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		want   int
+	}{
+		{name: "unauthorised", target: "/api/moderation?identifier=id-hash-1&hidden=true", want: http.StatusUnauthorized},
+		{name: "wrong token", target: "/api/moderation?identifier=id-hash-1&hidden=true", token: "other", want: http.StatusUnauthorized},
+		{name: "missing identifier", target: "/api/moderation?hidden=true", token: "cron-secret", want: http.StatusBadRequest},
+		{name: "bad hidden", target: "/api/moderation?identifier=id-hash-1&hidden=maybe", token: "cron-secret", want: http.StatusBadRequest},
+		{name: "unknown boost", target: "/api/moderation?identifier=id-missing&hidden=true", token: "cron-secret", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := moderate(tt.target, tt.token); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := moderate("/api/moderation?identifier=id-hash-1&hidden=true", "cron-secret"); got != http.StatusNoContent {
+		t.Fatalf("hide status = %d, want %d", got, http.StatusNoContent)
+	}
+	if change := <-changes; change.Identifier != "id-hash-1" || !change.Hidden {
+		t.Errorf("change = %+v, want id-hash-1 hidden", change)
+	}
+
+	query := map[string]string{"q[podcast]": "Moderated Show"}
+	boosts, err := GetBoosts(ctx, cfg, query)
+	if err != nil {
+		t.Fatalf("GetBoosts() error = %v", err)
+	}
+	if len(boosts) != 1 || boosts[0].Identifier != "id-hash-2" {
+		t.Errorf("boosts = %+v, want the hidden boost left out", boosts)
+	}
+
+	totals, err := GetBoostTotals(ctx, cfg, query)
+	if err != nil {
+		t.Fatalf("GetBoostTotals() error = %v", err)
+	}
+	if totals.Boosts != 1 || totals.Senders != 1 {
+		t.Errorf("totals = %+v, want only the visible boost", totals)
+	}
+
+	if got := moderate("/api/moderation?identifier=id-hash-1&hidden=false", "cron-secret"); got != http.StatusNoContent {
+		t.Fatalf("restore status = %d, want %d", got, http.StatusNoContent)
+	}
+	if change := <-changes; change.Identifier != "id-hash-1" || change.Hidden {
+		t.Errorf("change = %+v, want id-hash-1 restored", change)
+	}
+
+	boosts, err = GetBoosts(ctx, cfg, query)
+	if err != nil {
+		t.Fatalf("GetBoosts() error = %v", err)
+	}
+	if len(boosts) != 2 {
+		t.Errorf("boosts = %+v, want both after restoring", boosts)
+	}
+}
@@ -4,14 +4,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 var NostrRelays = []string{
@@ -22,25 +21,65 @@ var NostrRelays = []string{
 	"wss://nostr.oxtr.dev",
 }
 
-func PublishInvoiceToNostr(invoice IncomingInvoice) error {
+type NostrPublisher struct {
+	Config *Config
+}
+
+func (p NostrPublisher) Publish(invoice IncomingInvoice) error {
+	if !invoice.IsPublished() {
+		return nil
+	}
+
+	_, err := DeliverOutboxEntry(p.Config, invoice.PaymentHash)
+	if errors.Is(err, errOutboxMissing) {
+		return PublishInvoiceToNostr(p.Config, invoice)
+	}
+
+	return err
+}
+
+func PublishInvoiceToNostr(cfg *Config, invoice IncomingInvoice) error {
 	nostrRecord := invoice.GetNostrRecord()
 	serializedMetadata, err := json.Marshal(nostrRecord)
 	if err != nil {
 		return fmt.Errorf("failed to serialize nostr record: %w", err)
 	}
 
-	return publishToNostr(serializedMetadata, invoice.GetBoostagram())
+	return publishToNostr(cfg, serializedMetadata, invoice.GetBoostagram())
 }
 
-func publishToNostr(content []byte, boostagram Boostagram) error {
-	_, pk, err := nip19.Decode(os.Getenv("NOSTR_NPUB"))
+func publishToNostr(cfg *Config, content []byte, boostagram Boostagram) error {
+	ev, err := signNostrEvent(cfg, content, boostagram)
 	if err != nil {
-		return fmt.Errorf("failed to decode NOSTR_NPUB: %w", err)
+		return err
 	}
 
-	_, sk, err := nip19.Decode(os.Getenv("NOSTR_NSEC"))
+	results := publishEvent(ev, cfg.NostrRelays)
+	if acked := countAcks(results); acked < cfg.minRelayAcks() {
+		return fmt.Errorf("only %d of %d relays acknowledged event %s", acked, len(results), ev.ID)
+	}
+
+	return nil
+}
+
+type RelayResult struct {
+	URL string
+	Err error
+}
+
+var publishToRelay = func(ctx context.Context, url string, ev nostr.Event) error {
+	relay, err := nostr.RelayConnect(ctx, url)
 	if err != nil {
-		return fmt.Errorf("failed to decode NOSTR_NSEC: %w", err)
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer relay.Close()
+
+	return relay.Publish(ctx, ev)
+}
+
+func signNostrEvent(cfg *Config, content []byte, boostagram Boostagram) (nostr.Event, error) {
+	if cfg.NostrPublicKey == "" || cfg.NostrSecretKey == "" {
+		return nostr.Event{}, fmt.Errorf("NOSTR_NPUB and NOSTR_NSEC must be configured to publish")
 	}
 
 	hsh := sha256.New()
@@ -49,53 +88,81 @@ func publishToNostr(content []byte, boostagram Boostagram) error {
 
 	tags := boostagramTags(hash, boostagram)
 
-	pkStr, ok := pk.(string)
-	if !ok {
-		return fmt.Errorf("NOSTR_NPUB did not decode to string")
-	}
-
-	skStr, ok := sk.(string)
-	if !ok {
-		return fmt.Errorf("NOSTR_NSEC did not decode to string")
-	}
-
 	ev := nostr.Event{
-		PubKey:    pkStr,
+		PubKey:    cfg.NostrPublicKey,
 		CreatedAt: nostr.Now(),
 		Kind:      nostr.KindApplicationSpecificData,
 		Tags:      tags,
 		Content:   string(content),
 	}
 
-	ev.Sign(skStr)
+	if err := ev.Sign(cfg.NostrSecretKey); err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to sign event: %w", err)
+	}
+
+	return ev, nil
+}
+
+// closeRelayPool disconnects every relay in pool. The pool's relays do not
+// end with its context, so each one is closed before the pool itself.
+func closeRelayPool(pool *nostr.SimplePool, reason string) {
+	pool.Relays.Range(func(_ string, relay *nostr.Relay) bool {
+		if relay != nil {
+			relay.Close()
+		}
+		return true
+	})
+	pool.Close(reason)
+}
+
+func publishEvent(ev nostr.Event, relays []string) []RelayResult {
+	results := make([]RelayResult, len(relays))
 
 	var wg sync.WaitGroup
-	for _, relayURL := range NostrRelays {
+	for i, relayURL := range relays {
 		wg.Add(1)
-		go func(url string) {
+		go func(i int, url string) {
 			defer wg.Done()
 
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			relay, err := nostr.RelayConnect(ctx, url)
-			if err != nil {
-				log.Printf("failed to connect to relay %s: %v", url, err)
-				return
-			}
-			defer relay.Close()
-
-			if err := relay.Publish(ctx, ev); err != nil {
+			results[i] = RelayResult{URL: url}
+			if err := publishToRelay(ctx, url, ev); err != nil {
 				log.Printf("failed to publish to relay %s: %v", url, err)
+				results[i].Err = err
 				return
 			}
 
 			log.Printf("published to %s", url)
-		}(relayURL)
+		}(i, relayURL)
 	}
 	wg.Wait()
 
-	return nil
+	return results
+}
+
+func countAcks(results []RelayResult) int {
+	acked := 0
+	for _, result := range results {
+		if result.Err == nil {
+			acked++
+		}
+	}
+	return acked
+}
+
+func (c *Config) minRelayAcks() int {
+	acks := c.NostrMinRelayAcks
+	if acks < 1 {
+		acks = 1
+	}
+
+	if acks > len(c.NostrRelays) {
+		acks = len(c.NostrRelays)
+	}
+
+	return acks
 }
 
 func boostagramTags(hash string, boostagram Boostagram) nostr.Tags {
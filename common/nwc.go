@@ -0,0 +1,413 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// NIP-47 notification kinds, encrypted with NIP-04 and NIP-44 respectively.
+const (
+	KindNWCNotificationNIP04 = 23196
+	KindNWCNotification      = 23197
+)
+
+const nwcURIScheme = "nostr+walletconnect"
+
+// NWCConnection is a parsed nostr+walletconnect:// URI.
+type NWCConnection struct {
+	WalletPubKey string
+	Relays       []string
+	Secret       string
+	ClientPubKey string
+	LUD16        string
+}
+
+func ParseNWCURI(uri string) (NWCConnection, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return NWCConnection{}, fmt.Errorf("invalid NWC URI: %w", err)
+	}
+
+	if parsed.Scheme != nwcURIScheme {
+		return NWCConnection{}, fmt.Errorf("NWC URI must start with %s://", nwcURIScheme)
+	}
+
+	// The wallet pubkey is the host, but some wallets emit
+	// nostr+walletconnect:<pubkey> without the slashes.
+	walletPubKey := parsed.Host
+	if walletPubKey == "" {
+		walletPubKey = strings.TrimPrefix(parsed.Opaque, "//")
+	}
+	if !nostr.IsValid32ByteHex(walletPubKey) {
+		return NWCConnection{}, errors.New("NWC URI has no valid wallet pubkey")
+	}
+
+	query := parsed.Query()
+	conn := NWCConnection{
+		WalletPubKey: walletPubKey,
+		Relays:       query["relay"],
+		Secret:       query.Get("secret"),
+		LUD16:        query.Get("lud16"),
+	}
+
+	if len(conn.Relays) == 0 {
+		return NWCConnection{}, errors.New("NWC URI has no relay")
+	}
+
+	if !nostr.IsValid32ByteHex(conn.Secret) {
+		return NWCConnection{}, errors.New("NWC URI has no valid secret")
+	}
+
+	if conn.ClientPubKey, err = nostr.GetPublicKey(conn.Secret); err != nil {
+		return NWCConnection{}, fmt.Errorf("invalid NWC secret: %w", err)
+	}
+
+	return conn, nil
+}
+
+func (c NWCConnection) encrypt(content string, useNIP44 bool) (string, error) {
+	if !useNIP44 {
+		key, err := nip04.ComputeSharedSecret(c.WalletPubKey, c.Secret)
+		if err != nil {
+			return "", err
+		}
+		return nip04.Encrypt(content, key)
+	}
+
+	key, err := nip44.GenerateConversationKey(c.WalletPubKey, c.Secret)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(content, key)
+}
+
+// decrypt opens content sent to us by the wallet. NIP-04 payloads carry an
+// "?iv=" suffix, which NIP-44's base64 never contains.
+func (c NWCConnection) decrypt(content string) (string, error) {
+	if strings.Contains(content, "?iv=") {
+		key, err := nip04.ComputeSharedSecret(c.WalletPubKey, c.Secret)
+		if err != nil {
+			return "", err
+		}
+		return nip04.Decrypt(content, key)
+	}
+
+	key, err := nip44.GenerateConversationKey(c.WalletPubKey, c.Secret)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(content, key)
+}
+
+type nwcNotification struct {
+	NotificationType string      `json:"notification_type"`
+	Notification     *NWCPayment `json:"notification"`
+}
+
+// DecodeNWCNotification verifies and decrypts a kind 23196/23197 event into
+// the PaymentNotification shape that ParsePaymentNotification expects.
+func DecodeNWCNotification(conn NWCConnection, ev nostr.Event) (PaymentNotification, error) {
+	if ev.Kind != KindNWCNotification && ev.Kind != KindNWCNotificationNIP04 {
+		return PaymentNotification{}, ErrIgnoredPayload
+	}
+
+	if ev.PubKey != conn.WalletPubKey {
+		return PaymentNotification{}, fmt.Errorf("notification %s is not from the wallet", ev.ID)
+	}
+
+	if ok, err := ev.CheckSignature(); !ok {
+		return PaymentNotification{}, fmt.Errorf("notification %s has an invalid signature: %v", ev.ID, err)
+	}
+
+	plaintext, err := conn.decrypt(ev.Content)
+	if err != nil {
+		return PaymentNotification{}, fmt.Errorf("failed to decrypt notification %s: %w", ev.ID, err)
+	}
+
+	var notification nwcNotification
+	if err := json.Unmarshal([]byte(plaintext), &notification); err != nil {
+		return PaymentNotification{}, fmt.Errorf("failed to unmarshal notification %s: %w", ev.ID, err)
+	}
+
+	if notification.NotificationType != "payment_received" {
+		return PaymentNotification{}, ErrIgnoredPayload
+	}
+
+	if notification.Notification == nil {
+		return PaymentNotification{}, fmt.Errorf("notification %s has no payment", ev.ID)
+	}
+
+	// A payment_received notification is only sent once the payment has
+	// settled, and older wallets omit the state.
+	payment := notification.Notification
+	if payment.State == "" {
+		payment.State = "settled"
+	}
+
+	return PaymentNotification{Type: notification.NotificationType, Payment: payment}, nil
+}
+
+// subscribeNWCNotifications subscribes on a pool of its own. The pool's
+// relays are closed once ctx is done, so callers cancel it when the channel
+// closes.
+var subscribeNWCNotifications = func(ctx context.Context, relays []string, filter nostr.Filter) <-chan nostr.RelayEvent {
+	pool := nostr.NewSimplePool(ctx)
+	go func() {
+		<-ctx.Done()
+		closeRelayPool(pool, "nwc subscription ended")
+	}()
+	return pool.SubscribeMany(ctx, slices.Clone(relays), filter)
+}
+
+// RunNWCListener subscribes to the wallet's payment notifications and feeds
+// them through the same pipeline as the NWC webhook, reconnecting with
+// backoff if every relay drops the subscription.
+func RunNWCListener(ctx context.Context, cfg *Config) {
+	conn, err := ParseNWCURI(cfg.NWCURI)
+	if err != nil {
+		log.Printf("NWC listener not started: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	since := nostr.Now()
+
+	for {
+		filter := nostr.Filter{
+			Kinds:   []int{KindNWCNotification, KindNWCNotificationNIP04},
+			Authors: []string{conn.WalletPubKey},
+			Tags:    nostr.TagMap{"p": []string{conn.ClientPubKey}},
+			Since:   &since,
+		}
+
+		received := false
+		subCtx, cancel := context.WithCancel(ctx)
+		for relayEvent := range subscribeNWCNotifications(subCtx, conn.Relays, filter) {
+			received = true
+			ev := *relayEvent.Event
+
+			notification, err := DecodeNWCNotification(conn, ev)
+			if err != nil {
+				if !errors.Is(err, ErrIgnoredPayload) {
+					log.Printf("skipping NWC notification: %v", err)
+				}
+				continue
+			}
+
+			payload, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("failed to encode NWC notification %s: %v", ev.ID, err)
+				continue
+			}
+
+			pipeline := NewPipeline(cfg, NWCSource{})
+			if _, err := pipeline.Ingest(nil, payload); err != nil && !errors.Is(err, ErrIgnoredPayload) {
+				log.Printf("failed to process NWC payment %s: %v", notification.Payment.PaymentHash, err)
+			}
+
+			if ev.CreatedAt > since {
+				since = ev.CreatedAt
+			}
+		}
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if received {
+			backoff = time.Second
+		}
+
+		log.Printf("NWC subscription closed, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+const nwcListPageSize = 50
+
+// NWCClient makes NIP-47 requests to a wallet service.
+type NWCClient struct {
+	Conn  NWCConnection
+	NIP44 bool
+
+	pool *nostr.SimplePool
+}
+
+type nwcRequest struct {
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type nwcResponse struct {
+	ResultType string `json:"result_type"`
+	Error      *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+// NewNWCClient reads the wallet's info event to decide between NIP-44 and
+// NIP-04, falling back to NIP-04 for wallets that do not advertise it.
+func NewNWCClient(ctx context.Context, conn NWCConnection) *NWCClient {
+	client := &NWCClient{Conn: conn, pool: nostr.NewSimplePool(ctx)}
+
+	infoCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	info := client.pool.QuerySingle(infoCtx, slices.Clone(conn.Relays), nostr.Filter{
+		Kinds:   []int{nostr.KindNWCWalletInfo},
+		Authors: []string{conn.WalletPubKey},
+		Limit:   1,
+	})
+	if info != nil {
+		if tag := info.Tags.Find("encryption"); tag != nil {
+			client.NIP44 = slices.Contains(strings.Fields(tag[1]), "nip44_v2")
+		}
+	}
+
+	return client
+}
+
+// Close disconnects from the wallet's relays.
+func (c *NWCClient) Close() {
+	closeRelayPool(c.pool, "nwc client closed")
+}
+
+// Call sends a request and decodes the wallet's result into result.
+func (c *NWCClient) Call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(nwcRequest{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	content, err := c.Conn.encrypt(string(body), c.NIP44)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s request: %w", method, err)
+	}
+
+	req := nostr.Event{
+		Kind:      nostr.KindNWCWalletRequest,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", c.Conn.WalletPubKey}},
+		Content:   content,
+	}
+	if c.NIP44 {
+		req.Tags = append(req.Tags, nostr.Tag{"encryption", "nip44_v2"})
+	}
+	if err := req.Sign(c.Conn.Secret); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Wait for every relay to acknowledge the subscription before
+	// publishing, so a fast wallet cannot answer before we are listening.
+	eose := make(chan struct{})
+	responses := c.pool.SubscribeManyNotifyEOSE(ctx, slices.Clone(c.Conn.Relays), nostr.Filter{
+		Kinds:   []int{nostr.KindNWCWalletResponse},
+		Authors: []string{c.Conn.WalletPubKey},
+		Tags:    nostr.TagMap{"e": []string{req.ID}},
+	}, eose)
+
+	select {
+	case <-eose:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	published := false
+	for res := range c.pool.PublishMany(ctx, slices.Clone(c.Conn.Relays), req) {
+		if res.Error == nil {
+			published = true
+		}
+	}
+	if !published {
+		return fmt.Errorf("no relay accepted the %s request", method)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no response to %s: %w", method, ctx.Err())
+		case relayEvent, ok := <-responses:
+			if !ok {
+				return fmt.Errorf("no response to %s: subscription closed", method)
+			}
+
+			ev := relayEvent.Event
+			if ok, _ := ev.CheckSignature(); !ok {
+				continue
+			}
+
+			plaintext, err := c.Conn.decrypt(ev.Content)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s response: %w", method, err)
+			}
+
+			var response nwcResponse
+			if err := json.Unmarshal([]byte(plaintext), &response); err != nil {
+				return fmt.Errorf("failed to unmarshal %s response: %w", method, err)
+			}
+
+			if response.Error != nil {
+				return fmt.Errorf("%s failed: %s: %s", method, response.Error.Code, response.Error.Message)
+			}
+
+			return json.Unmarshal(response.Result, result)
+		}
+	}
+}
+
+// ListTransactions pages through list_transactions for incoming payments
+// between from and until.
+func (c *NWCClient) ListTransactions(ctx context.Context, from, until time.Time) ([]NWCPayment, error) {
+	transactions := []NWCPayment{}
+
+	for offset := 0; ; offset += nwcListPageSize {
+		params := map[string]any{
+			"from":   from.Unix(),
+			"limit":  nwcListPageSize,
+			"offset": offset,
+			"type":   "incoming",
+		}
+		if !until.IsZero() {
+			params["until"] = until.Unix()
+		}
+
+		var page struct {
+			Transactions []NWCPayment `json:"transactions"`
+		}
+		if err := c.Call(ctx, "list_transactions", params, &page); err != nil {
+			return transactions, err
+		}
+
+		transactions = append(transactions, page.Transactions...)
+
+		if len(page.Transactions) < nwcListPageSize {
+			return transactions, nil
+		}
+	}
+}
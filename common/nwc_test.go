@@ -0,0 +1,375 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+type nwcTestWallet struct {
+	secret string
+	conn   NWCConnection
+}
+
+func newNWCTestWallet(t *testing.T, relays ...string) nwcTestWallet {
+	t.Helper()
+
+	secret := nostr.GeneratePrivateKey()
+	uri := nwcURIScheme + "://" + zapTestPubKey(t, secret) + "?secret=" + nostr.GeneratePrivateKey()
+	for _, relay := range relays {
+		uri += "&relay=" + relay
+	}
+
+	conn, err := ParseNWCURI(uri)
+	if err != nil {
+		t.Fatalf("ParseNWCURI() error = %v", err)
+	}
+
+	return nwcTestWallet{secret: secret, conn: conn}
+}
+
+// notify builds the event a wallet sends for content, encrypted to the
+// client with NIP-44 for kind 23197 or NIP-04 for kind 23196.
+func (w nwcTestWallet) notify(t *testing.T, kind int, content string) nostr.Event {
+	t.Helper()
+
+	var encrypted string
+	var err error
+	if kind == KindNWCNotificationNIP04 {
+		key, keyErr := nip04.ComputeSharedSecret(w.conn.ClientPubKey, w.secret)
+		if keyErr != nil {
+			t.Fatalf("ComputeSharedSecret() error = %v", keyErr)
+		}
+		encrypted, err = nip04.Encrypt(content, key)
+	} else {
+		key, keyErr := nip44.GenerateConversationKey(w.conn.ClientPubKey, w.secret)
+		if keyErr != nil {
+			t.Fatalf("GenerateConversationKey() error = %v", keyErr)
+		}
+		encrypted, err = nip44.Encrypt(content, key)
+	}
+	if err != nil {
+		t.Fatalf("failed to encrypt notification: %v", err)
+	}
+
+	ev := nostr.Event{
+		Kind:      kind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", w.conn.ClientPubKey}},
+		Content:   encrypted,
+	}
+	if err := ev.Sign(w.secret); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return ev
+}
+
+const nwcTestNotification = `{
+	"notification_type": "payment_received",
+	"notification": {
+		"type": "incoming",
+		"payment_hash": "abc123",
+		"amount": 100000,
+		"created_at": 1700000000,
+		"settled_at": 1700000001,
+		"metadata": {
+			"comment": "rss::payment::boost https://example.com/pay",
+			"payer_data": {"name": "Alice"}
+		}
+	}
+}`
+
+func TestParseNWCURI(t *testing.T) {
+	t.Parallel()
+
+	walletPK := zapTestPubKey(t, nostr.GeneratePrivateKey())
+	secret := nostr.GeneratePrivateKey()
+
+	conn, err := ParseNWCURI(nwcURIScheme + "://" + walletPK + "?relay=wss%3A%2F%2Frelay.example&relay=wss://relay2.example&secret=" + secret + "&lud16=shows%40example.com")
+	if err != nil {
+		t.Fatalf("ParseNWCURI() error = %v", err)
+	}
+
+	if conn.WalletPubKey != walletPK {
+		t.Errorf("WalletPubKey = %q, want %q", conn.WalletPubKey, walletPK)
+	}
+	if len(conn.Relays) != 2 || conn.Relays[0] != "wss://relay.example" {
+		t.Errorf("Relays = %v, want both relays", conn.Relays)
+	}
+	if conn.ClientPubKey != zapTestPubKey(t, secret) {
+		t.Errorf("ClientPubKey = %q, want the secret's pubkey", conn.ClientPubKey)
+	}
+	if conn.LUD16 != "shows@example.com" {
+		t.Errorf("LUD16 = %q, want shows@example.com", conn.LUD16)
+	}
+
+	for _, bad := range []string{
+		"https://" + walletPK + "?relay=wss://relay.example&secret=" + secret,
+		nwcURIScheme + "://nothex?relay=wss://relay.example&secret=" + secret,
+		nwcURIScheme + "://" + walletPK + "?secret=" + secret,
+		nwcURIScheme + "://" + walletPK + "?relay=wss://relay.example",
+	} {
+		if _, err := ParseNWCURI(bad); err == nil {
+			t.Errorf("ParseNWCURI(%q) error = nil, want error", bad)
+		}
+	}
+}
+
+func TestDecodeNWCNotification(t *testing.T) {
+	t.Parallel()
+
+	wallet := newNWCTestWallet(t, "wss://relay.example")
+
+	for _, kind := range []int{KindNWCNotification, KindNWCNotificationNIP04} {
+		notification, err := DecodeNWCNotification(wallet.conn, wallet.notify(t, kind, nwcTestNotification))
+		if err != nil {
+			t.Fatalf("kind %d: DecodeNWCNotification() error = %v", kind, err)
+		}
+
+		if notification.Payment.State != "settled" {
+			t.Errorf("kind %d: State = %q, want settled", kind, notification.Payment.State)
+		}
+
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			t.Fatalf("failed to marshal notification: %v", err)
+		}
+
+		invoice, err := ParsePaymentNotification(payload)
+		if err != nil {
+			t.Fatalf("kind %d: ParsePaymentNotification() error = %v", kind, err)
+		}
+		if invoice.Amount != 100 || invoice.PayerName != "Alice" || invoice.PaymentHash != "abc123" {
+			t.Errorf("kind %d: invoice = %+v, want the notified payment", kind, invoice)
+		}
+	}
+}
+
+func TestDecodeNWCNotificationErrors(t *testing.T) {
+	t.Parallel()
+
+	wallet := newNWCTestWallet(t, "wss://relay.example")
+	other := newNWCTestWallet(t, "wss://relay.example")
+
+	sent := wallet.notify(t, KindNWCNotification, `{"notification_type":"payment_sent","notification":{"type":"outgoing"}}`)
+	if _, err := DecodeNWCNotification(wallet.conn, sent); !errors.Is(err, ErrIgnoredPayload) {
+		t.Errorf("payment_sent error = %v, want ErrIgnoredPayload", err)
+	}
+
+	if _, err := DecodeNWCNotification(wallet.conn, other.notify(t, KindNWCNotification, nwcTestNotification)); err == nil {
+		t.Error("notification from another wallet error = nil, want error")
+	}
+
+	tampered := wallet.notify(t, KindNWCNotification, nwcTestNotification)
+	tampered.Content += "x"
+	if _, err := DecodeNWCNotification(wallet.conn, tampered); err == nil {
+		t.Error("tampered notification error = nil, want error")
+	}
+}
+
+func TestRunNWCListener(t *testing.T) {
+	relay := newFakeRelay(t)
+	wallet := newNWCTestWallet(t, relay.URL)
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.NWCURI = nwcURIScheme + "://" + wallet.conn.WalletPubKey + "?relay=" + relay.URL + "&secret=" + wallet.conn.Secret
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunNWCListener(ctx, cfg)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case filters := <-relay.reqs:
+		if len(filters) != 1 || filters[0].Authors[0] != wallet.conn.WalletPubKey || filters[0].Tags["p"][0] != wallet.conn.ClientPubKey {
+			t.Fatalf("filters = %v, want notifications from the wallet to the client", filters)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener never subscribed")
+	}
+
+	// Wallets that support both encryptions send the notification twice.
+	relay.publish(t, wallet.notify(t, KindNWCNotification, nwcTestNotification))
+	relay.publish(t, wallet.notify(t, KindNWCNotificationNIP04, nwcTestNotification))
+
+	var invoices []IncomingInvoice
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if invoices, err = LoadInvoices(cfg, InvoiceFilter{}); err != nil {
+			t.Fatalf("LoadInvoices() error = %v", err)
+		}
+		if len(invoices) > 0 {
+			break
+		}
+	}
+
+	if len(invoices) != 1 || invoices[0].PaymentHash != "abc123" || invoices[0].Amount != 100 {
+		t.Fatalf("invoices = %+v, want the notified payment once", invoices)
+	}
+
+	payloads, err := ListPayloads(cfg, PayloadFilter{Sources: []string{"nwc"}})
+	if err != nil {
+		t.Fatalf("ListPayloads() error = %v", err)
+	}
+	if len(payloads) == 0 {
+		t.Error("notification was not archived under the nwc source")
+	}
+}
+
+func TestSubscribeNWCNotificationsDisconnects(t *testing.T) {
+	relay := newFakeRelay(t)
+
+	// RunNWCListener cancels each subscription's context once it ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	events := subscribeNWCNotifications(ctx, []string{relay.URL}, nostr.Filter{Kinds: []int{KindNWCNotification}})
+
+	select {
+	case <-relay.reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("never subscribed")
+	}
+	cancel()
+	for range events {
+	}
+
+	select {
+	case <-relay.disconnects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay connection still open after the subscription ended")
+	}
+}
+
+// serveTransactions answers list_transactions requests with count incoming
+// payments, honouring limit and offset.
+func (w nwcTestWallet) serveTransactions(t *testing.T, relay *fakeRelay, count int) {
+	key, err := nip04.ComputeSharedSecret(w.conn.ClientPubKey, w.secret)
+	if err != nil {
+		t.Fatalf("ComputeSharedSecret() error = %v", err)
+	}
+
+	relay.onEvent = func(req nostr.Event) {
+		if req.Kind != nostr.KindNWCWalletRequest {
+			return
+		}
+
+		plaintext, err := nip04.Decrypt(req.Content, key)
+		if err != nil {
+			t.Errorf("failed to decrypt request: %v", err)
+			return
+		}
+
+		var call struct {
+			Method string `json:"method"`
+			Params struct {
+				Limit  int    `json:"limit"`
+				Offset int    `json:"offset"`
+				Type   string `json:"type"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal([]byte(plaintext), &call); err != nil || call.Method != "list_transactions" || call.Params.Type != "incoming" {
+			t.Errorf("request = %s, want an incoming list_transactions call", plaintext)
+			return
+		}
+
+		transactions := []NWCPayment{}
+		for i := call.Params.Offset; i < count && len(transactions) < call.Params.Limit; i++ {
+			transactions = append(transactions, NWCPayment{Type: "incoming", State: "settled", PaymentHash: fmt.Sprintf("hash%03d", i), Amount: 1000})
+		}
+
+		body, _ := json.Marshal(map[string]any{
+			"result_type": "list_transactions",
+			"result":      map[string]any{"transactions": transactions},
+		})
+		content, err := nip04.Encrypt(string(body), key)
+		if err != nil {
+			t.Errorf("failed to encrypt response: %v", err)
+			return
+		}
+
+		res := nostr.Event{
+			Kind:      nostr.KindNWCWalletResponse,
+			CreatedAt: nostr.Now(),
+			Tags:      nostr.Tags{{"p", w.conn.ClientPubKey}, {"e", req.ID}},
+			Content:   content,
+		}
+		if err := res.Sign(w.secret); err != nil {
+			t.Errorf("Sign() error = %v", err)
+			return
+		}
+		relay.publish(t, res)
+	}
+}
+
+func TestNWCClientListTransactions(t *testing.T) {
+	relay := newFakeRelay(t)
+	wallet := newNWCTestWallet(t, relay.URL)
+	wallet.serveTransactions(t, relay, nwcListPageSize+10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := NewNWCClient(ctx, wallet.conn)
+	defer client.Close()
+	if client.NIP44 {
+		t.Error("NIP44 = true for a wallet without an info event, want NIP-04")
+	}
+
+	transactions, err := client.ListTransactions(ctx, time.Unix(1700000000, 0), time.Time{})
+	if err != nil {
+		t.Fatalf("ListTransactions() error = %v", err)
+	}
+
+	if len(transactions) != nwcListPageSize+10 {
+		t.Fatalf("len(transactions) = %d, want %d across two pages", len(transactions), nwcListPageSize+10)
+	}
+	if transactions[nwcListPageSize].PaymentHash != fmt.Sprintf("hash%03d", nwcListPageSize) {
+		t.Errorf("second page starts with %q, want it to follow the first", transactions[nwcListPageSize].PaymentHash)
+	}
+}
+
+func TestListNWCTransactionsDisconnects(t *testing.T) {
+	relay := newFakeRelay(t)
+	wallet := newNWCTestWallet(t, relay.URL)
+	wallet.serveTransactions(t, relay, 3)
+
+	// A long-lived context, as RunRecoveryJob passes in.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transactions, err := listNWCTransactions(ctx, wallet.conn, time.Unix(1700000000, 0), time.Time{})
+	if err != nil {
+		t.Fatalf("listNWCTransactions() error = %v", err)
+	}
+	if len(transactions) != 3 {
+		t.Fatalf("len(transactions) = %d, want 3", len(transactions))
+	}
+
+	select {
+	case <-relay.disconnects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay connection still open after the run finished")
+	}
+}
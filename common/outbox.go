@@ -0,0 +1,248 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const outboxColumns = `payment_hash, content, attempts, acked_relays, last_error, next_attempt_at, published_at, created_at`
+
+var errOutboxMissing = errors.New("payment is not in the outbox")
+
+// outboxClaimTimeout is how long a delivery holds an entry. An entry whose
+// delivery never saved its attempt becomes due again afterwards.
+const outboxClaimTimeout = 2 * time.Minute
+
+type OutboxEntry struct {
+	PaymentHash   string     `json:"payment_hash"`
+	Content       string     `json:"content"`
+	Attempts      int        `json:"attempts"`
+	AckedRelays   []string   `json:"acked_relays"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	PublishedAt   *time.Time `json:"published_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func outboxContent(invoice IncomingInvoice) (string, error) {
+	content, err := json.Marshal(invoice.GetNostrRecord())
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize nostr record: %w", err)
+	}
+
+	return string(content), nil
+}
+
+func DeliverOutboxEntry(cfg *Config, paymentHash string) (OutboxEntry, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+
+	ctx := context.Background()
+
+	entry, err := store.GetOutboxEntry(ctx, paymentHash)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+
+	if entry.PublishedAt != nil {
+		return entry, nil
+	}
+
+	now := time.Now().UTC()
+	claimed, err := store.ClaimOutboxEntry(ctx, entry, now, now.Add(outboxClaimTimeout))
+	if err != nil {
+		return entry, err
+	}
+	if !claimed {
+		// Another delivery, such as the outbox worker's, already has it.
+		return entry, nil
+	}
+
+	entry = deliverOutboxEntry(cfg, entry, now)
+	if err := store.SaveOutboxAttempt(ctx, entry); err != nil {
+		return entry, err
+	}
+
+	if entry.PublishedAt == nil {
+		return entry, fmt.Errorf("queued for retry after attempt %d: %s", entry.Attempts, entry.LastError)
+	}
+
+	return entry, nil
+}
+
+func ProcessOutbox(cfg *Config, limit int) ([]OutboxEntry, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	entries, err := store.DueOutboxEntries(ctx, time.Now().UTC(), cfg.NostrOutboxMaxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := []OutboxEntry{}
+	for _, entry := range entries {
+		now := time.Now().UTC()
+		claimed, err := store.ClaimOutboxEntry(ctx, entry, now, now.Add(outboxClaimTimeout))
+		if err != nil {
+			return delivered, err
+		}
+		if !claimed {
+			continue
+		}
+
+		entry = deliverOutboxEntry(cfg, entry, now)
+		delivered = append(delivered, entry)
+		if err := store.SaveOutboxAttempt(ctx, entry); err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+func RunOutboxWorker(ctx context.Context, cfg *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := ProcessOutbox(cfg, 50)
+		if err != nil {
+			log.Printf("failed to process nostr outbox: %v", err)
+		}
+
+		for _, entry := range entries {
+			if entry.PublishedAt == nil {
+				log.Printf("outbox %s attempt %d failed, retrying at %s", entry.PaymentHash, entry.Attempts, entry.NextAttemptAt.Format(time.RFC3339))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ListStuckOutboxEntries(cfg *Config, olderThan time.Duration) ([]OutboxEntry, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.StuckOutboxEntries(context.Background(), time.Now().UTC().Add(-olderThan), cfg.NostrOutboxMaxAttempts)
+}
+
+func deliverOutboxEntry(cfg *Config, entry OutboxEntry, now time.Time) OutboxEntry {
+	entry.Attempts++
+
+	var record NostrRecord
+	if err := json.Unmarshal([]byte(entry.Content), &record); err != nil {
+		entry.LastError = fmt.Sprintf("failed to decode nostr record: %v", err)
+		entry.NextAttemptAt = now.Add(outboxBackoff(entry.Attempts))
+		return entry
+	}
+
+	boostagram := Boostagram{}
+	if record.Boostagram != nil {
+		boostagram = *record.Boostagram
+	}
+
+	ev, err := signNostrEvent(cfg, []byte(entry.Content), boostagram)
+	if err != nil {
+		entry.LastError = err.Error()
+		entry.NextAttemptAt = now.Add(outboxBackoff(entry.Attempts))
+		return entry
+	}
+
+	acked := make(map[string]bool, len(entry.AckedRelays))
+	for _, url := range entry.AckedRelays {
+		acked[url] = true
+	}
+
+	pending := []string{}
+	for _, url := range cfg.NostrRelays {
+		if !acked[url] {
+			pending = append(pending, url)
+		}
+	}
+
+	var failures []string
+	for _, result := range publishEvent(ev, pending) {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", result.URL, result.Err))
+			continue
+		}
+		entry.AckedRelays = append(entry.AckedRelays, result.URL)
+	}
+
+	if len(entry.AckedRelays) >= cfg.minRelayAcks() {
+		entry.PublishedAt = &now
+		entry.LastError = ""
+		return entry
+	}
+
+	entry.LastError = strings.Join(failures, "; ")
+	entry.NextAttemptAt = now.Add(outboxBackoff(entry.Attempts))
+	return entry
+}
+
+func scanOutboxEntries(rows *sql.Rows) ([]OutboxEntry, error) {
+	defer rows.Close()
+
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var entry OutboxEntry
+		var acked string
+		var publishedAt sql.NullTime
+
+		if err := rows.Scan(&entry.PaymentHash, &entry.Content, &entry.Attempts, &acked, &entry.LastError, &entry.NextAttemptAt, &publishedAt, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(acked), &entry.AckedRelays); err != nil {
+			return nil, fmt.Errorf("failed to decode acked relays for %s: %w", entry.PaymentHash, err)
+		}
+
+		if publishedAt.Valid {
+			entry.PublishedAt = &publishedAt.Time
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+
+	return backoff
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testNostrConfig(t *testing.T) *Config {
+	t.Helper()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+
+	return &Config{
+		NostrPublicKey:         pk,
+		NostrSecretKey:         sk,
+		NostrRelays:            NostrRelays,
+		NostrMinRelayAcks:      1,
+		NostrOutboxMaxAttempts: 12,
+	}
+}
+
+func stubRelays(t *testing.T, failing map[string]bool) *[]string {
+	t.Helper()
+
+	var mu sync.Mutex
+	published := []string{}
+
+	original := publishToRelay
+	publishToRelay = func(ctx context.Context, url string, ev nostr.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ok, _ := ev.CheckSignature(); !ok {
+			t.Errorf("event for %s has an invalid signature", url)
+		}
+
+		if failing[url] {
+			return errors.New("relay unavailable")
+		}
+		published = append(published, url)
+		return nil
+	}
+	t.Cleanup(func() { publishToRelay = original })
+
+	return &published
+}
+
+func testOutboxEntry(t *testing.T) OutboxEntry {
+	t.Helper()
+
+	content, err := json.Marshal(IncomingInvoice{
+		PaymentHash: "hash-1",
+		Boostagram:  &Boostagram{EventGuid: "event-1"},
+	}.GetNostrRecord())
+	if err != nil {
+		t.Fatalf("failed to marshal nostr record: %v", err)
+	}
+
+	return OutboxEntry{PaymentHash: "hash-1", Content: string(content)}
+}
+
+func TestDeliverOutboxEntry(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.NostrMinRelayAcks = 2
+	published := stubRelays(t, map[string]bool{NostrRelays[0]: true})
+
+	now := time.Unix(1700000000, 0).UTC()
+	entry := deliverOutboxEntry(cfg, testOutboxEntry(t), now)
+
+	if entry.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", entry.Attempts)
+	}
+	if entry.PublishedAt == nil || !entry.PublishedAt.Equal(now) {
+		t.Errorf("PublishedAt = %v, want %v", entry.PublishedAt, now)
+	}
+	if len(entry.AckedRelays) != len(NostrRelays)-1 {
+		t.Errorf("AckedRelays = %v, want %d relays", entry.AckedRelays, len(NostrRelays)-1)
+	}
+	if len(*published) != len(NostrRelays)-1 {
+		t.Errorf("published to %d relays, want %d", len(*published), len(NostrRelays)-1)
+	}
+}
+
+func TestDeliverOutboxEntryBelowThreshold(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.NostrMinRelayAcks = 2
+
+	failing := map[string]bool{}
+	for _, url := range NostrRelays[1:] {
+		failing[url] = true
+	}
+	stubRelays(t, failing)
+
+	now := time.Unix(1700000000, 0).UTC()
+	entry := deliverOutboxEntry(cfg, testOutboxEntry(t), now)
+
+	if entry.PublishedAt != nil {
+		t.Fatalf("PublishedAt = %v, want nil", entry.PublishedAt)
+	}
+	if len(entry.AckedRelays) != 1 || entry.AckedRelays[0] != NostrRelays[0] {
+		t.Errorf("AckedRelays = %v, want [%s]", entry.AckedRelays, NostrRelays[0])
+	}
+	if entry.LastError == "" {
+		t.Error("LastError should describe the failing relays")
+	}
+	if want := now.Add(outboxBackoff(1)); !entry.NextAttemptAt.Equal(want) {
+		t.Errorf("NextAttemptAt = %v, want %v", entry.NextAttemptAt, want)
+	}
+}
+
+func TestDeliverOutboxEntrySkipsAckedRelays(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.NostrMinRelayAcks = 5
+	published := stubRelays(t, nil)
+
+	entry := testOutboxEntry(t)
+	entry.Attempts = 3
+	entry.AckedRelays = []string{NostrRelays[0], NostrRelays[1]}
+
+	entry = deliverOutboxEntry(cfg, entry, time.Now().UTC())
+
+	if entry.PublishedAt == nil {
+		t.Fatal("PublishedAt should be set once every relay acknowledged")
+	}
+	if entry.Attempts != 4 {
+		t.Errorf("Attempts = %d, want 4", entry.Attempts)
+	}
+	for _, url := range *published {
+		if url == NostrRelays[0] || url == NostrRelays[1] {
+			t.Errorf("republished to already acked relay %s", url)
+		}
+	}
+}
+
+func TestDeliverOutboxEntryClaimed(t *testing.T) {
+	cfg := testLiveConfig(t)
+	published := stubRelays(t, nil)
+	ctx := context.Background()
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice("hash-1", 1700000000, Boostagram{Action: "boost"})); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+
+	entry, err := store.GetOutboxEntry(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v", err)
+	}
+
+	// The worker claims the entry first, as if mid-delivery.
+	now := time.Now().UTC()
+	if claimed, err := store.ClaimOutboxEntry(ctx, entry, now, now.Add(outboxClaimTimeout)); err != nil || !claimed {
+		t.Fatalf("ClaimOutboxEntry() = %v, %v, want claimed", claimed, err)
+	}
+	if claimed, err := store.ClaimOutboxEntry(ctx, entry, now, now.Add(outboxClaimTimeout)); err != nil || claimed {
+		t.Errorf("second ClaimOutboxEntry() = %v, %v, want the stale entry refused", claimed, err)
+	}
+
+	if _, err := DeliverOutboxEntry(cfg, "hash-1"); err != nil {
+		t.Errorf("DeliverOutboxEntry() error = %v, want the claimed entry skipped", err)
+	}
+	entries, err := ProcessOutbox(cfg, 50)
+	if err != nil {
+		t.Fatalf("ProcessOutbox() error = %v", err)
+	}
+	if len(entries) != 0 || len(*published) != 0 {
+		t.Errorf("delivered %+v to %v, want nothing sent while the entry is claimed", entries, *published)
+	}
+
+	entry, err = store.GetOutboxEntry(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v", err)
+	}
+	if entry.Attempts != 1 || entry.PublishedAt != nil {
+		t.Errorf("entry = %+v, want the one claimed attempt", entry)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 30 * time.Second},
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 4, want: 4 * time.Minute},
+		{attempts: 20, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMinRelayAcks(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{NostrRelays: NostrRelays, NostrMinRelayAcks: 100}
+
+	if got := cfg.minRelayAcks(); got != len(NostrRelays) {
+		t.Errorf("minRelayAcks() = %d, want %d", got, len(NostrRelays))
+	}
+}
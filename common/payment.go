@@ -11,6 +11,34 @@ import (
 
 const PodcastTLVType int64 = 7629169
 
+// Payment directions. Only incoming payments are published to nostr and
+// counted on the boards; outgoing ones are kept so hosts can see what the
+// show has sent.
+const (
+	DirectionIncoming = "incoming"
+	DirectionOutgoing = "outgoing"
+)
+
+type AlbySource struct{}
+
+func (AlbySource) Name() string {
+	return "alby"
+}
+
+func (AlbySource) Parse(payload []byte) (IncomingInvoice, error) {
+	return ParseInvoiceFromJson(payload)
+}
+
+type NWCSource struct{}
+
+func (NWCSource) Name() string {
+	return "nwc"
+}
+
+func (NWCSource) Parse(payload []byte) (IncomingInvoice, error) {
+	return ParsePaymentNotification(payload)
+}
+
 func ParsePaymentNotification(payload []byte) (IncomingInvoice, error) {
 	var notification PaymentNotification
 
@@ -122,6 +150,28 @@ func (i IncomingInvoice) GetBoostagram() Boostagram {
 	return Boostagram{}
 }
 
+func (i IncomingInvoice) GetDirection() string {
+	if i.Direction == "" {
+		return DirectionIncoming
+	}
+	return i.Direction
+}
+
+// IsPublished reports whether the payment is published to nostr. Streamed
+// and automatic payments are stored but kept off the relays, and payments
+// without an action, such as lightning address tips, count as boosts.
+func (i IncomingInvoice) IsPublished() bool {
+	if i.GetDirection() != DirectionIncoming {
+		return false
+	}
+
+	switch i.GetBoostagram().Action {
+	case "", "boost", "zap":
+		return true
+	}
+	return false
+}
+
 func (i IncomingInvoice) GetSerializedMetadata() ([]byte, error) {
 	var metadata interface{}
 
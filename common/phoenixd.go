@@ -0,0 +1,152 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const phoenixdPaymentReceived = "payment_received"
+
+type PhoenixdWebhook struct {
+	Type        string `json:"type"`
+	Timestamp   int64  `json:"timestamp"` // unix millis
+	AmountSat   int64  `json:"amountSat"`
+	PaymentHash string `json:"paymentHash"`
+	ExternalID  string `json:"externalId"`
+	PayerNote   string `json:"payerNote"`
+	PayerKey    string `json:"payerKey"`
+}
+
+// PhoenixdIncomingPayment is the response of GET /payments/incoming/{hash}.
+type PhoenixdIncomingPayment struct {
+	PaymentHash string `json:"paymentHash"`
+	Preimage    string `json:"preimage"`
+	ExternalID  string `json:"externalId"`
+	Description string `json:"description"`
+	IsPaid      bool   `json:"isPaid"`
+	ReceivedSat int64  `json:"receivedSat"`
+	PayerNote   string `json:"payerNote"`
+	PayerKey    string `json:"payerKey"`
+	CreatedAt   int64  `json:"createdAt"`   // unix millis
+	CompletedAt int64  `json:"completedAt"` // unix millis
+}
+
+type PhoenixdSource struct {
+	Config *Config
+}
+
+func (PhoenixdSource) Name() string {
+	return "phoenixd"
+}
+
+func (s PhoenixdSource) Parse(payload []byte) (IncomingInvoice, error) {
+	var webhook PhoenixdWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal phoenixd webhook: %w", err)
+	}
+
+	if webhook.Type != phoenixdPaymentReceived {
+		return IncomingInvoice{}, ErrIgnoredPayload
+	}
+
+	if webhook.PaymentHash == "" {
+		return IncomingInvoice{}, errors.New("phoenixd webhook has no paymentHash")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payment, err := FetchPhoenixdIncomingPayment(ctx, s.Config, webhook.PaymentHash)
+	if err != nil {
+		return IncomingInvoice{}, fetchError(err)
+	}
+
+	if !payment.IsPaid {
+		return IncomingInvoice{}, fmt.Errorf("phoenixd reports payment %s as unpaid", webhook.PaymentHash)
+	}
+
+	return PhoenixdPaymentToInvoice(webhook, payment)
+}
+
+// PhoenixdPaymentToInvoice prefers the looked-up payment and falls back to
+// the webhook for fields phoenixd left empty.
+func PhoenixdPaymentToInvoice(webhook PhoenixdWebhook, payment PhoenixdIncomingPayment) (IncomingInvoice, error) {
+	sats := payment.ReceivedSat
+	if sats == 0 {
+		sats = webhook.AmountSat
+	}
+
+	completed := payment.CompletedAt
+	if completed == 0 {
+		completed = webhook.Timestamp
+	}
+	created := time.UnixMilli(completed)
+
+	invoice := IncomingInvoice{
+		Amount:       float64(sats),
+		Value:        float64(sats),
+		Description:  payment.Description,
+		PaymentHash:  webhook.PaymentHash,
+		Identifier:   webhook.PaymentHash,
+		Type:         "incoming",
+		CreationDate: float64(created.Unix()),
+		CreatedAt:    created.UTC().Format(time.RFC3339),
+	}
+
+	// phoenixd stores the LNURL comment and the BOLT12 payer note in the
+	// same field.
+	note := payment.PayerNote
+	if note == "" {
+		note = webhook.PayerNote
+	}
+	if note != "" {
+		invoice.Metadata = &InvoiceMetadata{Comment: note}
+	}
+
+	if err := applyInvoiceMetadata(&invoice); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to apply invoice metadata: %w", err)
+	}
+
+	return invoice, nil
+}
+
+// FetchPhoenixdIncomingPayment looks a payment up on phoenixd's HTTP API,
+// which uses basic auth with an empty username.
+func FetchPhoenixdIncomingPayment(ctx context.Context, cfg *Config, paymentHash string) (PhoenixdIncomingPayment, error) {
+	if cfg.PhoenixdURL == "" || cfg.PhoenixdPassword == "" {
+		return PhoenixdIncomingPayment{}, errors.New("PHOENIXD_URL and PHOENIXD_PASSWORD are required to look up payments")
+	}
+
+	endpoint := cfg.PhoenixdURL + "/payments/incoming/" + url.PathEscape(paymentHash)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return PhoenixdIncomingPayment{}, err
+	}
+	req.SetBasicAuth("", cfg.PhoenixdPassword)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return PhoenixdIncomingPayment{}, fmt.Errorf("phoenixd request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return PhoenixdIncomingPayment{}, fmt.Errorf("phoenixd returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var payment PhoenixdIncomingPayment
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+		return PhoenixdIncomingPayment{}, fmt.Errorf("failed to decode phoenixd payment: %w", err)
+	}
+
+	return payment, nil
+}
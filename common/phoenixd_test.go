@@ -0,0 +1,167 @@
+package common
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const phoenixdTestHash = "2b1c7d9fca7d0a4bb1a36cce6cd0b0c9f2f1c4c8c4bd5e3c1e50cd1c0e3f4a5b"
+
+const phoenixdTestWebhook = `{"type":"payment_received","timestamp":1700000000000,"amountSat":21,"paymentHash":"` + phoenixdTestHash + `","externalId":"ext-1"}`
+
+// fakePhoenixd serves /payments/incoming for phoenixdTestHash.
+func fakePhoenixd(t *testing.T, payment string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); !ok || password != "phoenixd-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/payments/incoming/"+phoenixdTestHash {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(payment))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func phoenixdTestSig(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPhoenixdSourceParse(t *testing.T) {
+	t.Parallel()
+
+	server := fakePhoenixd(t, `{"paymentHash":"`+phoenixdTestHash+`","description":"Boost","isPaid":true,"receivedSat":2100,"payerNote":"rss::payment::boost https://example.com/pay","createdAt":1699999990000,"completedAt":1700000005000}`)
+	cfg := &Config{PhoenixdURL: server.URL, PhoenixdPassword: "phoenixd-password"}
+
+	invoice, err := PhoenixdSource{Config: cfg}.Parse([]byte(phoenixdTestWebhook))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.Amount != 2100 {
+		t.Errorf("Amount = %v, want the looked-up receivedSat", invoice.Amount)
+	}
+	if invoice.PaymentHash != phoenixdTestHash || invoice.Identifier != phoenixdTestHash {
+		t.Errorf("PaymentHash = %q, want %q", invoice.PaymentHash, phoenixdTestHash)
+	}
+	if invoice.Comment != "rss::payment::boost https://example.com/pay" {
+		t.Errorf("Comment = %q, want the payer note", invoice.Comment)
+	}
+	if invoice.Description != "Boost" {
+		t.Errorf("Description = %q, want Boost", invoice.Description)
+	}
+	if invoice.CreationDate != 1700000005 {
+		t.Errorf("CreationDate = %v, want completedAt in seconds", invoice.CreationDate)
+	}
+}
+
+func TestPhoenixdSourceParseErrors(t *testing.T) {
+	t.Parallel()
+
+	server := fakePhoenixd(t, `{"paymentHash":"`+phoenixdTestHash+`","isPaid":false}`)
+	source := PhoenixdSource{Config: &Config{PhoenixdURL: server.URL, PhoenixdPassword: "phoenixd-password"}}
+
+	if _, err := source.Parse([]byte(`{"type":"payment_sent","paymentHash":"` + phoenixdTestHash + `"}`)); !errors.Is(err, ErrIgnoredPayload) {
+		t.Errorf("other event error = %v, want ErrIgnoredPayload", err)
+	}
+
+	if _, err := source.Parse([]byte(phoenixdTestWebhook)); err == nil {
+		t.Error("unpaid payment error = nil, want error")
+	}
+
+	var pipelineErr *PipelineError
+	if _, err := source.Parse([]byte(`{"type":"payment_received","paymentHash":"unknown"}`)); !errors.As(err, &pipelineErr) || pipelineErr.Stage != StageFetch {
+		t.Errorf("unknown payment error = %v, want a fetch error", err)
+	}
+
+	if _, err := (PhoenixdSource{Config: &Config{}}).Parse([]byte(phoenixdTestWebhook)); err == nil {
+		t.Error("unconfigured API error = nil, want error")
+	}
+}
+
+func TestHandlePhoenixdWebhook(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	server := fakePhoenixd(t, `{"paymentHash":"`+phoenixdTestHash+`","isPaid":true,"receivedSat":21,"payerNote":"hello from phoenix","completedAt":1700000000000}`)
+	cfg.PhoenixdURL = server.URL
+	cfg.PhoenixdPassword = "phoenixd-password"
+	cfg.PhoenixdWebhookSecret = "phoenixd-secret"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/phoenixd", strings.NewReader(phoenixdTestWebhook))
+	req.Header.Set("X-Phoenix-Signature", phoenixdTestSig("wrong-secret", phoenixdTestWebhook))
+	rec := httptest.NewRecorder()
+	HandlePhoenixdWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status with bad signature = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/phoenixd", strings.NewReader(phoenixdTestWebhook))
+	req.Header.Set("X-Phoenix-Signature", phoenixdTestSig("phoenixd-secret", phoenixdTestWebhook))
+	rec = httptest.NewRecorder()
+	HandlePhoenixdWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].PaymentHash != phoenixdTestHash || invoices[0].Comment != "hello from phoenix" {
+		t.Fatalf("invoices = %+v, want the phoenixd payment", invoices)
+	}
+}
+
+func TestHandlePhoenixdWebhookLookupFailure(t *testing.T) {
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	cfg.PhoenixdURL = server.URL
+	cfg.PhoenixdPassword = "phoenixd-password"
+	cfg.PhoenixdWebhookSecret = "phoenixd-secret"
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/phoenixd", strings.NewReader(phoenixdTestWebhook))
+	req.Header.Set("X-Phoenix-Signature", phoenixdTestSig("phoenixd-secret", phoenixdTestWebhook))
+	rec := httptest.NewRecorder()
+	HandlePhoenixdWebhook(cfg)(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
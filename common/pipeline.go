@@ -0,0 +1,204 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var ErrIgnoredPayload = errors.New("payload ignored")
+
+type Source interface {
+	Name() string
+	Parse(payload []byte) (IncomingInvoice, error)
+}
+
+// ReceivedSource is a Source whose payloads carry no timestamp of their own,
+// so payments are dated by when the payload was received. Reprocessing passes
+// the archived time, which keeps the original dates.
+type ReceivedSource interface {
+	Source
+	ParseReceived(payload []byte, received time.Time) (IncomingInvoice, error)
+}
+
+func parsePayload(source Source, payload []byte, received time.Time) (IncomingInvoice, error) {
+	if source, ok := source.(ReceivedSource); ok {
+		return source.ParseReceived(payload, received)
+	}
+	return source.Parse(payload)
+}
+
+type Enricher interface {
+	Enrich(invoice *IncomingInvoice) (bool, error)
+}
+
+type Store interface {
+	SaveInvoiceIfNew(invoice IncomingInvoice) (bool, error)
+	UpdateInvoice(invoice IncomingInvoice) error
+}
+
+type Publisher interface {
+	Publish(invoice IncomingInvoice) error
+}
+
+type Archive interface {
+	ArchivePayload(payload ArchivedPayload) (int64, error)
+	RecordPayloadOutcome(id int64, outcome PayloadOutcome) error
+}
+
+type Pipeline struct {
+	Source     Source
+	Enrichers  []Enricher
+	Store      Store
+	Publishers []Publisher
+	Archive    Archive
+}
+
+type PipelineStage string
+
+const (
+	StageParse  PipelineStage = "parse"
+	StageFetch  PipelineStage = "fetch"
+	StageStore  PipelineStage = "store"
+	StageEnrich PipelineStage = "enrich"
+	StageUpdate PipelineStage = "update"
+)
+
+type PipelineError struct {
+	Stage PipelineStage
+	Err   error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// fetchError marks a failed lookup made by a source while parsing, such as a
+// call to the wallet's API. Unlike a malformed payload it may succeed later,
+// so webhooks answer it with a server error and the sender retries.
+func fetchError(err error) error {
+	return &PipelineError{Stage: StageFetch, Err: err}
+}
+
+type PipelineResult struct {
+	Invoice IncomingInvoice
+	IsNew   bool
+}
+
+func NewPipeline(cfg *Config, source Source) *Pipeline {
+	return &Pipeline{
+		Source:     source,
+		Enrichers:  []Enricher{RSSPaymentEnricher{}},
+		Store:      DatabaseStore{Config: cfg},
+		Publishers: []Publisher{NostrPublisher{Config: cfg}, StreamPublisher{}},
+		Archive:    DatabaseStore{Config: cfg},
+	}
+}
+
+func (p *Pipeline) Process(payload []byte) (PipelineResult, error) {
+	return p.ProcessReceived(payload, time.Now())
+}
+
+// ProcessReceived processes a payload received at the given time.
+func (p *Pipeline) ProcessReceived(payload []byte, received time.Time) (PipelineResult, error) {
+	invoice, err := parsePayload(p.Source, payload, received)
+	if err != nil {
+		return PipelineResult{}, parseError(err)
+	}
+
+	isNew, err := p.Store.SaveInvoiceIfNew(invoice)
+	if err != nil {
+		return PipelineResult{Invoice: invoice}, &PipelineError{Stage: StageStore, Err: err}
+	}
+
+	if !isNew {
+		return PipelineResult{Invoice: invoice}, nil
+	}
+
+	changed := false
+	for _, enricher := range p.Enrichers {
+		ok, err := enricher.Enrich(&invoice)
+		if err != nil {
+			return PipelineResult{Invoice: invoice, IsNew: true}, &PipelineError{Stage: StageEnrich, Err: err}
+		}
+		changed = changed || ok
+	}
+
+	if changed {
+		if err := p.Store.UpdateInvoice(invoice); err != nil {
+			return PipelineResult{Invoice: invoice, IsNew: true}, &PipelineError{Stage: StageUpdate, Err: err}
+		}
+	}
+
+	for _, publisher := range p.Publishers {
+		if err := publisher.Publish(invoice); err != nil {
+			log.Printf("failed to publish %s from %s: %v", invoice.PaymentHash, p.Source.Name(), err)
+		}
+	}
+
+	return PipelineResult{Invoice: invoice, IsNew: true}, nil
+}
+
+// Ingest archives the raw payload before processing it, so payloads that
+// fail can be replayed later with the reprocess command. An archive failure
+// is logged but never stops the payment from being processed.
+func (p *Pipeline) Ingest(headers http.Header, payload []byte) (PipelineResult, error) {
+	received := time.Now().UTC()
+
+	var archiveID int64
+	if p.Archive != nil {
+		id, err := p.Archive.ArchivePayload(ArchivedPayload{
+			Source:     p.Source.Name(),
+			Headers:    archivedHeaders(headers),
+			Payload:    payload,
+			Status:     PayloadReceived,
+			ReceivedAt: received,
+		})
+		if err != nil {
+			log.Printf("failed to archive %s payload: %v", p.Source.Name(), err)
+		}
+		archiveID = id
+	}
+
+	result, err := p.ProcessReceived(payload, received)
+
+	if archiveID != 0 {
+		if err := p.Archive.RecordPayloadOutcome(archiveID, payloadOutcome(result, err)); err != nil {
+			log.Printf("failed to record outcome for payload %d: %v", archiveID, err)
+		}
+	}
+
+	return result, err
+}
+
+func (p *Pipeline) HandlePayload(w http.ResponseWriter, headers http.Header, payload []byte) {
+	result, err := p.Ingest(headers, payload)
+	if err != nil {
+		var pipelineErr *PipelineError
+
+		switch {
+		case errors.Is(err, ErrIgnoredPayload):
+			w.WriteHeader(http.StatusNoContent)
+		case errors.As(err, &pipelineErr) && pipelineErr.Stage == StageParse:
+			log.Printf("failed to parse %s payload: %v", p.Source.Name(), pipelineErr.Err)
+			log.Printf("payload: %s", payload)
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			log.Printf("failed to process %s payload: %v", p.Source.Name(), err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !result.IsNew {
+		log.Printf("payment %s already exists in database, skipping broadcast", result.Invoice.PaymentHash)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeSource struct {
+	invoice IncomingInvoice
+	err     error
+}
+
+func (s fakeSource) Name() string {
+	return "fake"
+}
+
+func (s fakeSource) Parse(payload []byte) (IncomingInvoice, error) {
+	return s.invoice, s.err
+}
+
+type fakeEnricher struct {
+	rss *RssPayment
+	err error
+}
+
+func (e fakeEnricher) Enrich(invoice *IncomingInvoice) (bool, error) {
+	if e.err != nil {
+		return false, e.err
+	}
+	if e.rss == nil {
+		return false, nil
+	}
+	invoice.RSSPayment = e.rss
+	return true, nil
+}
+
+type fakeStore struct {
+	existing map[string]bool
+	saved    []IncomingInvoice
+	updated  []IncomingInvoice
+	saveErr  error
+}
+
+func (s *fakeStore) SaveInvoiceIfNew(invoice IncomingInvoice) (bool, error) {
+	if s.saveErr != nil {
+		return false, s.saveErr
+	}
+	if s.existing[invoice.PaymentHash] {
+		return false, nil
+	}
+	s.saved = append(s.saved, invoice)
+	return true, nil
+}
+
+func (s *fakeStore) UpdateInvoice(invoice IncomingInvoice) error {
+	s.updated = append(s.updated, invoice)
+	return nil
+}
+
+type fakeArchive struct {
+	archived []ArchivedPayload
+	outcomes []PayloadOutcome
+}
+
+func (a *fakeArchive) ArchivePayload(payload ArchivedPayload) (int64, error) {
+	a.archived = append(a.archived, payload)
+	return int64(len(a.archived)), nil
+}
+
+func (a *fakeArchive) RecordPayloadOutcome(id int64, outcome PayloadOutcome) error {
+	a.outcomes = append(a.outcomes, outcome)
+	return nil
+}
+
+type fakePublisher struct {
+	published []IncomingInvoice
+	err       error
+}
+
+func (p *fakePublisher) Publish(invoice IncomingInvoice) error {
+	p.published = append(p.published, invoice)
+	return p.err
+}
+
+func TestPipelineProcess(t *testing.T) {
+	t.Parallel()
+
+	store := &fakeStore{}
+	publisher := &fakePublisher{}
+	pipeline := &Pipeline{
+		Source:     fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+		Enrichers:  []Enricher{fakeEnricher{rss: &RssPayment{FeedTitle: "Enriched"}}},
+		Store:      store,
+		Publishers: []Publisher{publisher},
+	}
+
+	result, err := pipeline.Process([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if !result.IsNew {
+		t.Error("IsNew = false, want true")
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("saved %d invoices, want 1", len(store.saved))
+	}
+	if len(store.updated) != 1 || store.updated[0].RSSPayment == nil {
+		t.Fatalf("updated = %+v, want enriched invoice", store.updated)
+	}
+	if len(publisher.published) != 1 {
+		t.Fatalf("published %d invoices, want 1", len(publisher.published))
+	}
+	if publisher.published[0].GetBoostagram().Podcast != "Enriched" {
+		t.Errorf("published Podcast = %q, want Enriched", publisher.published[0].GetBoostagram().Podcast)
+	}
+}
+
+func TestPipelineProcessSkipsExisting(t *testing.T) {
+	t.Parallel()
+
+	store := &fakeStore{existing: map[string]bool{"hash-1": true}}
+	publisher := &fakePublisher{}
+	pipeline := &Pipeline{
+		Source:     fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+		Enrichers:  []Enricher{fakeEnricher{err: errors.New("should not run")}},
+		Store:      store,
+		Publishers: []Publisher{publisher},
+	}
+
+	result, err := pipeline.Process([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if result.IsNew {
+		t.Error("IsNew = true, want false")
+	}
+	if len(publisher.published) != 0 {
+		t.Errorf("published %d invoices, want 0", len(publisher.published))
+	}
+}
+
+func TestPipelineProcessSkipsUpdateWithoutEnrichment(t *testing.T) {
+	t.Parallel()
+
+	store := &fakeStore{}
+	pipeline := &Pipeline{
+		Source:     fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+		Enrichers:  []Enricher{fakeEnricher{}},
+		Store:      store,
+		Publishers: []Publisher{&fakePublisher{}},
+	}
+
+	if _, err := pipeline.Process([]byte(`{}`)); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if len(store.updated) != 0 {
+		t.Errorf("updated %d invoices, want 0", len(store.updated))
+	}
+}
+
+func TestPipelineProcessIgnoresPublishErrors(t *testing.T) {
+	t.Parallel()
+
+	first := &fakePublisher{err: errors.New("relay down")}
+	second := &fakePublisher{}
+	pipeline := &Pipeline{
+		Source:     fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+		Store:      &fakeStore{},
+		Publishers: []Publisher{first, second},
+	}
+
+	if _, err := pipeline.Process([]byte(`{}`)); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	if len(second.published) != 1 {
+		t.Errorf("second publisher got %d invoices, want 1", len(second.published))
+	}
+}
+
+func TestPipelineProcessStageErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+		want     PipelineStage
+	}{
+		{
+			name: "parse",
+			pipeline: &Pipeline{
+				Source: fakeSource{err: errors.New("bad payload")},
+				Store:  &fakeStore{},
+			},
+			want: StageParse,
+		},
+		{
+			name: "store",
+			pipeline: &Pipeline{
+				Source: fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+				Store:  &fakeStore{saveErr: errors.New("db down")},
+			},
+			want: StageStore,
+		},
+		{
+			name: "enrich",
+			pipeline: &Pipeline{
+				Source:    fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+				Enrichers: []Enricher{fakeEnricher{err: errors.New("fetch failed")}},
+				Store:     &fakeStore{},
+			},
+			want: StageEnrich,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tt.pipeline.Process([]byte(`{}`))
+
+			var pipelineErr *PipelineError
+			if !errors.As(err, &pipelineErr) {
+				t.Fatalf("Process() error = %v, want *PipelineError", err)
+			}
+			if pipelineErr.Stage != tt.want {
+				t.Errorf("Stage = %q, want %q", pipelineErr.Stage, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineHandlePayload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		source  Source
+		store   *fakeStore
+		want    int
+		outcome PayloadStatus
+	}{
+		{
+			name:    "new payment",
+			source:  fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+			store:   &fakeStore{},
+			want:    http.StatusNoContent,
+			outcome: PayloadProcessed,
+		},
+		{
+			name:    "duplicate payment",
+			source:  fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+			store:   &fakeStore{existing: map[string]bool{"hash-1": true}},
+			want:    http.StatusNoContent,
+			outcome: PayloadDuplicate,
+		},
+		{
+			name:    "ignored payload",
+			source:  fakeSource{err: ErrIgnoredPayload},
+			store:   &fakeStore{},
+			want:    http.StatusNoContent,
+			outcome: PayloadIgnored,
+		},
+		{
+			name:    "parse error",
+			source:  fakeSource{err: errors.New("bad payload")},
+			store:   &fakeStore{},
+			want:    http.StatusBadRequest,
+			outcome: PayloadFailed,
+		},
+		{
+			name:    "store error",
+			source:  fakeSource{invoice: IncomingInvoice{PaymentHash: "hash-1"}},
+			store:   &fakeStore{saveErr: errors.New("db down")},
+			want:    http.StatusInternalServerError,
+			outcome: PayloadFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			archive := &fakeArchive{}
+			pipeline := &Pipeline{Source: tt.source, Store: tt.store, Archive: archive}
+			headers := http.Header{"Authorization": {"Bearer secret"}, "Webhook-Id": {"msg_1"}}
+			rec := httptest.NewRecorder()
+			pipeline.HandlePayload(rec, headers, []byte(`{}`))
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+
+			if len(archive.archived) != 1 || string(archive.archived[0].Payload) != `{}` {
+				t.Fatalf("archived = %+v, want the raw payload", archive.archived)
+			}
+			if archive.archived[0].Headers.Get("Authorization") != "" || archive.archived[0].Headers.Get("Webhook-Id") != "msg_1" {
+				t.Errorf("archived headers = %v, want Authorization redacted", archive.archived[0].Headers)
+			}
+			if len(archive.outcomes) != 1 || archive.outcomes[0].Status != tt.outcome {
+				t.Errorf("outcomes = %+v, want %s", archive.outcomes, tt.outcome)
+			}
+		})
+	}
+}
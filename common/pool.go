@@ -0,0 +1,69 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	sharedStores   = map[string]*SQLStore{}
+	sharedStoresMu sync.Mutex
+)
+
+type PoolStats struct {
+	Dialect           string  `json:"dialect"`
+	MaxOpen           int     `json:"max_open"`
+	Open              int     `json:"open"`
+	InUse             int     `json:"in_use"`
+	Idle              int     `json:"idle"`
+	WaitCount         int64   `json:"wait_count"`
+	WaitSeconds       float64 `json:"wait_seconds"`
+	MaxIdleClosed     int64   `json:"max_idle_closed"`
+	MaxLifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+// SharedStore returns the process-wide pool for cfg.DatabaseURL, opening it
+// on first use. Warm serverless instances and the standalone server reuse
+// the same connections across requests. Close on the result is a no-op.
+func SharedStore(cfg *Config) (InvoiceStore, error) {
+	sharedStoresMu.Lock()
+	defer sharedStoresMu.Unlock()
+
+	if store, ok := sharedStores[cfg.DatabaseURL]; ok {
+		return store, nil
+	}
+
+	store, err := openSQLStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store.owned = false
+
+	sharedStores[cfg.DatabaseURL] = store
+	return store, nil
+}
+
+func (s *SQLStore) Stats() PoolStats {
+	stats := s.db.Stats()
+
+	return PoolStats{
+		Dialect:           s.dialect.name,
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitSeconds:       stats.WaitDuration.Round(time.Millisecond).Seconds(),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
+
+func DatabaseStats(cfg *Config) (PoolStats, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return PoolStats{}, err
+	}
+
+	return store.Stats(), nil
+}
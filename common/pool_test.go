@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSharedStoreReusesPool(t *testing.T) {
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	first, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	second, err := SharedStore(&Config{DatabaseURL: cfg.DatabaseURL})
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+
+	if first != second {
+		t.Fatal("SharedStore() returned a new store for the same DATABASE_URL")
+	}
+
+	if _, err := second.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() after Close error = %v", err)
+	}
+
+	stats := second.Stats()
+	if stats.Dialect != "sqlite" || stats.MaxOpen != 1 {
+		t.Errorf("Stats() = %+v, want sqlite with one connection", stats)
+	}
+}
+
+func TestSQLStoreQueryTimeout(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	store.timeout = time.Nanosecond
+
+	_, err := store.GetBoosts(context.Background(), map[string]string{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetBoosts() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestHandleDatabaseStats(t *testing.T) {
+	cfg := &Config{
+		DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db"),
+		CronSecret:  "cron-secret",
+	}
+
+	rec := httptest.NewRecorder()
+	HandleDatabaseStats(cfg)(rec, httptest.NewRequest(http.MethodGet, "/api/db-stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/db-stats", nil)
+	req.Header.Set("Authorization", "Bearer cron-secret")
+	rec = httptest.NewRecorder()
+	HandleDatabaseStats(cfg)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var stats PoolStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.Dialect != "sqlite" {
+		t.Errorf("Dialect = %q, want sqlite", stats.Dialect)
+	}
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ReconcileReport summarises a reconciliation run. Recovered lists the
+// payment hashes that were missing and have now been stored.
+type ReconcileReport struct {
+	From         time.Time `json:"from"`
+	Until        time.Time `json:"until"`
+	Transactions int       `json:"transactions"`
+	Settled      int       `json:"settled"`
+	Existing     int       `json:"existing"`
+	Recovered    []string  `json:"recovered"`
+	Failed       []string  `json:"failed"`
+}
+
+// listNWCTransactions connects to the wallet for a single run, so the relay
+// connections do not outlive it when ctx belongs to a long-running job.
+var listNWCTransactions = func(ctx context.Context, conn NWCConnection, from, until time.Time) ([]NWCPayment, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	client := NewNWCClient(ctx, conn)
+	defer client.Close()
+
+	return client.ListTransactions(ctx, from, until)
+}
+
+// ReconcileNWC asks the wallet for incoming payments between from and until
+// and stores, enriches and publishes any settled payment whose hash is not
+// in the invoices table yet. With dryRun the missing payments are reported
+// but not stored.
+func ReconcileNWC(ctx context.Context, cfg *Config, from, until time.Time, dryRun bool) (ReconcileReport, error) {
+	report := ReconcileReport{From: from, Until: until, Recovered: []string{}, Failed: []string{}}
+
+	conn, err := ParseNWCURI(cfg.NWCURI)
+	if err != nil {
+		return report, err
+	}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return report, err
+	}
+
+	transactions, err := listNWCTransactions(ctx, conn, from, until)
+	if err != nil {
+		return report, fmt.Errorf("failed to list wallet transactions: %w", err)
+	}
+	report.Transactions = len(transactions)
+
+	settled := []NWCPayment{}
+	hashes := []string{}
+	for _, tx := range transactions {
+		if !nwcSettledIncoming(tx) {
+			continue
+		}
+		tx.State = "settled"
+		settled = append(settled, tx)
+		hashes = append(hashes, tx.PaymentHash)
+	}
+	report.Settled = len(settled)
+
+	existing, err := store.ExistingPaymentHashes(ctx, hashes)
+	if err != nil {
+		return report, fmt.Errorf("failed to look up stored invoices: %w", err)
+	}
+
+	for _, tx := range settled {
+		if existing[tx.PaymentHash] {
+			report.Existing++
+			continue
+		}
+
+		if dryRun {
+			report.Recovered = append(report.Recovered, tx.PaymentHash)
+			continue
+		}
+
+		payload, err := json.Marshal(PaymentNotification{Type: "payment_received", Payment: &tx})
+		if err != nil {
+			return report, err
+		}
+
+		result, err := NewPipeline(cfg, NWCSource{}).Ingest(nil, payload)
+		if err != nil {
+			log.Printf("failed to recover NWC payment %s: %v", tx.PaymentHash, err)
+			report.Failed = append(report.Failed, tx.PaymentHash)
+			continue
+		}
+
+		// The listener or a webhook may have stored it since the lookup.
+		if !result.IsNew {
+			report.Existing++
+			continue
+		}
+
+		report.Recovered = append(report.Recovered, tx.PaymentHash)
+	}
+
+	return report, nil
+}
+
+// nwcSettledIncoming reports whether a list_transactions entry is a received
+// payment that has settled. Wallets that predate the state field only set
+// settled_at once the payment completes.
+func nwcSettledIncoming(tx NWCPayment) bool {
+	if tx.Type != "incoming" || tx.PaymentHash == "" {
+		return false
+	}
+
+	if tx.State != "" {
+		return tx.State == "settled"
+	}
+
+	return tx.SettledAt > 0
+}
+
+// RecoveryFunc recovers payments created between from and until.
+type RecoveryFunc func(ctx context.Context, cfg *Config, from, until time.Time, dryRun bool) (ReconcileReport, error)
+
+// RunRecoveryJob recovers the trailing window every interval until ctx is
+// cancelled.
+func RunRecoveryJob(ctx context.Context, cfg *Config, name string, run RecoveryFunc, interval, window time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		until := time.Now()
+		report, err := run(ctx, cfg, until.Add(-window), until, false)
+		if err != nil {
+			log.Printf("%s failed: %v", name, err)
+		}
+
+		if len(report.Recovered) > 0 {
+			log.Printf("%s recovered %d payments: %v", name, len(report.Recovered), report.Recovered)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func stubNWCTransactions(t *testing.T, transactions []NWCPayment) {
+	t.Helper()
+
+	orig := listNWCTransactions
+	listNWCTransactions = func(context.Context, NWCConnection, time.Time, time.Time) ([]NWCPayment, error) {
+		return transactions, nil
+	}
+	t.Cleanup(func() { listNWCTransactions = orig })
+}
+
+func reconcileTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	wallet := newNWCTestWallet(t, "wss://relay.example")
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.NWCURI = nwcURIScheme + "://" + wallet.conn.WalletPubKey + "?relay=wss://relay.example&secret=" + wallet.conn.Secret
+	cfg.CronSecret = "cron-secret"
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	if _, err := (DatabaseStore{Config: cfg}).SaveInvoiceIfNew(IncomingInvoice{
+		Amount:       10,
+		PaymentHash:  "seen",
+		Identifier:   "seen",
+		Type:         "incoming",
+		CreationDate: 1700000000,
+		CreatedAt:    "2023-11-14T22:13:20Z",
+	}); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+
+	stubNWCTransactions(t, []NWCPayment{
+		{Type: "incoming", State: "settled", PaymentHash: "seen", Amount: 10000, CreatedAt: 1700000000},
+		{Type: "incoming", State: "settled", PaymentHash: "missed", Amount: 21000, CreatedAt: 1700000100, Metadata: &InvoiceMetadata{Comment: "hello"}},
+		{Type: "incoming", PaymentHash: "legacy", Amount: 5000, CreatedAt: 1700000200, SettledAt: 1700000201},
+		{Type: "incoming", State: "pending", PaymentHash: "pending", Amount: 5000, CreatedAt: 1700000300},
+		{Type: "incoming", PaymentHash: "unpaid", Amount: 5000, CreatedAt: 1700000400},
+		{Type: "outgoing", State: "settled", PaymentHash: "sent", Amount: 5000, CreatedAt: 1700000500},
+	})
+
+	return cfg
+}
+
+func TestReconcileNWC(t *testing.T) {
+	cfg := reconcileTestConfig(t)
+
+	report, err := ReconcileNWC(context.Background(), cfg, time.Unix(1700000000, 0), time.Unix(1700001000, 0), false)
+	if err != nil {
+		t.Fatalf("ReconcileNWC() error = %v", err)
+	}
+
+	if report.Transactions != 6 || report.Settled != 3 || report.Existing != 1 {
+		t.Errorf("report = %+v, want 6 transactions, 3 settled, 1 existing", report)
+	}
+	if !slices.Equal(report.Recovered, []string{"missed", "legacy"}) {
+		t.Errorf("Recovered = %v, want [missed legacy]", report.Recovered)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 3 {
+		t.Fatalf("invoices = %+v, want the stored invoice plus two recovered", invoices)
+	}
+
+	byHash := map[string]IncomingInvoice{}
+	for _, invoice := range invoices {
+		byHash[invoice.PaymentHash] = invoice
+	}
+	if byHash["missed"].Amount != 21 || byHash["missed"].Comment != "hello" {
+		t.Errorf("recovered invoice = %+v, want 21 sats with its comment", byHash["missed"])
+	}
+
+	// A second run finds nothing left to recover.
+	report, err = ReconcileNWC(context.Background(), cfg, time.Unix(1700000000, 0), time.Unix(1700001000, 0), false)
+	if err != nil {
+		t.Fatalf("ReconcileNWC() error = %v", err)
+	}
+	if len(report.Recovered) != 0 || report.Existing != 3 {
+		t.Errorf("second report = %+v, want everything existing", report)
+	}
+}
+
+func TestReconcileNWCDryRun(t *testing.T) {
+	cfg := reconcileTestConfig(t)
+
+	report, err := ReconcileNWC(context.Background(), cfg, time.Unix(1700000000, 0), time.Unix(1700001000, 0), true)
+	if err != nil {
+		t.Fatalf("ReconcileNWC() error = %v", err)
+	}
+	if !slices.Equal(report.Recovered, []string{"missed", "legacy"}) {
+		t.Errorf("Recovered = %v, want the missing payments listed", report.Recovered)
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 {
+		t.Errorf("dry run stored %d invoices, want only the existing one", len(invoices))
+	}
+}
+
+func TestHandleReconcile(t *testing.T) {
+	cfg := reconcileTestConfig(t)
+
+	rec := httptest.NewRecorder()
+	HandleReconcile(cfg)(rec, httptest.NewRequest(http.MethodPost, "/api/reconcile", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/reconcile?window=1h", nil)
+	req.Header.Set("Authorization", "Bearer cron-secret")
+	rec = httptest.NewRecorder()
+	HandleReconcile(cfg)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	invoices, err := LoadInvoices(cfg, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 3 {
+		t.Errorf("invoices = %d, want the missing payments stored", len(invoices))
+	}
+}
+
+func TestRecoveryWindow(t *testing.T) {
+	t.Parallel()
+
+	from, until, err := recoveryWindow(httptest.NewRequest(http.MethodGet, "/api/reconcile?since=2024-01-01T00:00:00Z&until=2024-01-02T00:00:00Z", nil))
+	if err != nil {
+		t.Fatalf("recoveryWindow() error = %v", err)
+	}
+	if !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !until.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("window = %s to %s, want 2024-01-01 to 2024-01-02", from, until)
+	}
+
+	from, until, err = recoveryWindow(httptest.NewRequest(http.MethodGet, "/api/reconcile?window=2h", nil))
+	if err != nil {
+		t.Fatalf("recoveryWindow() error = %v", err)
+	}
+	if until.Sub(from) != 2*time.Hour {
+		t.Errorf("window = %s, want 2h", until.Sub(from))
+	}
+
+	for _, query := range []string{"window=-1h", "window=soon", "since=yesterday", "since=2024-01-02T00:00:00Z&until=2024-01-01T00:00:00Z"} {
+		if _, _, err := recoveryWindow(httptest.NewRequest(http.MethodGet, "/api/reconcile?"+query, nil)); err == nil {
+			t.Errorf("recoveryWindow(%s) error = nil, want error", query)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeRelay is a minimal relay stand-in: it answers REQ with EOSE, records
+// the filters it was asked for and pushes published events to matching
+// subscriptions. Events sent by clients are acknowledged and handed to
+// onEvent, which must be set before the first client connects. Each client
+// that goes away is signalled on disconnects.
+type fakeRelay struct {
+	URL         string
+	reqs        chan nostr.Filters
+	disconnects chan struct{}
+	onEvent     func(ev nostr.Event)
+
+	mu   sync.Mutex
+	subs []*fakeRelaySub
+}
+
+type fakeRelaySub struct {
+	conn    *websocket.Conn
+	id      string
+	filters nostr.Filters
+	writeMu *sync.Mutex
+}
+
+func newFakeRelay(t *testing.T) *fakeRelay {
+	t.Helper()
+
+	relay := &fakeRelay{reqs: make(chan nostr.Filters, 16), disconnects: make(chan struct{}, 16)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		defer func() {
+			select {
+			case relay.disconnects <- struct{}{}:
+			default:
+			}
+		}()
+
+		writeMu := &sync.Mutex{}
+		for {
+			_, data, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+
+			var msg []json.RawMessage
+			if err := json.Unmarshal(data, &msg); err != nil || len(msg) < 2 {
+				continue
+			}
+
+			var label, id string
+			json.Unmarshal(msg[0], &label)
+			json.Unmarshal(msg[1], &id)
+
+			if label == "EVENT" {
+				var ev nostr.Event
+				if err := json.Unmarshal(msg[1], &ev); err != nil {
+					continue
+				}
+
+				writeMu.Lock()
+				ok, _ := json.Marshal([]any{"OK", ev.ID, true, ""})
+				conn.Write(r.Context(), websocket.MessageText, ok)
+				writeMu.Unlock()
+
+				if relay.onEvent != nil {
+					relay.onEvent(ev)
+				}
+				continue
+			}
+
+			if label != "REQ" {
+				continue
+			}
+
+			filters := nostr.Filters{}
+			for _, raw := range msg[2:] {
+				var filter nostr.Filter
+				if err := json.Unmarshal(raw, &filter); err == nil {
+					filters = append(filters, filter)
+				}
+			}
+
+			relay.mu.Lock()
+			relay.subs = append(relay.subs, &fakeRelaySub{conn: conn, id: id, filters: filters, writeMu: writeMu})
+			relay.mu.Unlock()
+
+			writeMu.Lock()
+			eose, _ := json.Marshal([]string{"EOSE", id})
+			conn.Write(r.Context(), websocket.MessageText, eose)
+			writeMu.Unlock()
+
+			select {
+			case relay.reqs <- filters:
+			default:
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	relay.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	return relay
+}
+
+// publish sends ev to every subscription whose filters match it.
+func (r *fakeRelay) publish(t *testing.T, ev nostr.Event) {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sub := range r.subs {
+		if !sub.filters.Match(&ev) {
+			continue
+		}
+
+		msg, err := json.Marshal([]any{"EVENT", sub.id, ev})
+		if err != nil {
+			t.Fatalf("failed to marshal event: %v", err)
+		}
+
+		sub.writeMu.Lock()
+		err = sub.conn.Write(context.Background(), websocket.MessageText, msg)
+		sub.writeMu.Unlock()
+		if err != nil {
+			t.Logf("fake relay write failed: %v", err)
+		}
+	}
+}
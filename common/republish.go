@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type InvoiceFilter struct {
+	Since     time.Time
+	Until     time.Time
+	EventGuid string
+	Direction string
+}
+
+type RelayReport struct {
+	URL       string `json:"url"`
+	Published int    `json:"published"`
+	Failed    int    `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func LoadInvoices(cfg *Config, filter InvoiceFilter) ([]IncomingInvoice, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.LoadInvoices(context.Background(), filter)
+}
+
+func applyStoredMetadata(invoice *IncomingInvoice, metadata []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(metadata, &fields); err != nil {
+		return err
+	}
+
+	if fields == nil {
+		return nil
+	}
+
+	if _, ok := fields["feed_title"]; ok {
+		var rssPayment RssPayment
+		if err := json.Unmarshal(metadata, &rssPayment); err != nil {
+			return err
+		}
+		invoice.RSSPayment = &rssPayment
+		return nil
+	}
+
+	var boostagram Boostagram
+	if err := json.Unmarshal(metadata, &boostagram); err != nil {
+		return err
+	}
+	invoice.Boostagram = &boostagram
+	return nil
+}
+
+func RepublishInvoice(cfg *Config, invoice IncomingInvoice, relays []string) ([]RelayResult, error) {
+	content, err := json.Marshal(invoice.GetNostrRecord())
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize nostr record: %w", err)
+	}
+
+	ev, err := signNostrEvent(cfg, content, invoice.GetBoostagram())
+	if err != nil {
+		return nil, err
+	}
+
+	return publishEvent(ev, relays), nil
+}
+
+func TallyRelayResults(reports map[string]*RelayReport, results []RelayResult) {
+	for _, result := range results {
+		report, ok := reports[result.URL]
+		if !ok {
+			report = &RelayReport{URL: result.URL}
+			reports[result.URL] = report
+		}
+
+		if result.Err != nil {
+			report.Failed++
+			report.LastError = result.Err.Error()
+			continue
+		}
+		report.Published++
+	}
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestApplyStoredMetadataRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	feedID := 42.0
+	tests := []struct {
+		name    string
+		invoice IncomingInvoice
+	}{
+		{
+			name: "boostagram",
+			invoice: IncomingInvoice{
+				PaymentHash: "hash-1",
+				Boostagram:  &Boostagram{Action: "boost", Podcast: "Show", FeedID: &feedID, EventGuid: "event-1"},
+			},
+		},
+		{
+			name: "rss payment",
+			invoice: IncomingInvoice{
+				PaymentHash: "hash-2",
+				RSSPayment:  &RssPayment{Action: "BOOST", FeedTitle: "RSS Show", ValueMsatTotal: 1000},
+			},
+		},
+		{
+			name:    "no metadata",
+			invoice: IncomingInvoice{PaymentHash: "hash-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stored, err := tt.invoice.GetSerializedMetadata()
+			if err != nil {
+				t.Fatalf("GetSerializedMetadata() error = %v", err)
+			}
+
+			restored := IncomingInvoice{PaymentHash: tt.invoice.PaymentHash}
+			if err := applyStoredMetadata(&restored, stored); err != nil {
+				t.Fatalf("applyStoredMetadata() error = %v", err)
+			}
+
+			want, _ := json.Marshal(tt.invoice.GetNostrRecord())
+			got, _ := json.Marshal(restored.GetNostrRecord())
+			if string(got) != string(want) {
+				t.Errorf("nostr record = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRepublishInvoiceUsesDeterministicDTag(t *testing.T) {
+	cfg := testNostrConfig(t)
+
+	var mu sync.Mutex
+	events := []nostr.Event{}
+
+	original := publishToRelay
+	publishToRelay = func(ctx context.Context, url string, ev nostr.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+		return nil
+	}
+	t.Cleanup(func() { publishToRelay = original })
+
+	invoice := IncomingInvoice{PaymentHash: "hash-1", Boostagram: &Boostagram{EventGuid: "event-1"}}
+	results, err := RepublishInvoice(cfg, invoice, []string{"wss://one.example", "wss://two.example"})
+	if err != nil {
+		t.Fatalf("RepublishInvoice() error = %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	content, _ := json.Marshal(invoice.GetNostrRecord())
+	want := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	for _, ev := range events {
+		if tag := ev.Tags.GetFirst([]string{"d"}); tag == nil || (*tag)[1] != want {
+			t.Errorf("d tag = %v, want %s", tag, want)
+		}
+	}
+}
+
+func TestTallyRelayResults(t *testing.T) {
+	t.Parallel()
+
+	reports := map[string]*RelayReport{}
+	TallyRelayResults(reports, []RelayResult{{URL: "wss://one.example"}, {URL: "wss://two.example", Err: fmt.Errorf("timeout")}})
+	TallyRelayResults(reports, []RelayResult{{URL: "wss://one.example"}, {URL: "wss://two.example"}})
+
+	if got := reports["wss://one.example"]; got.Published != 2 || got.Failed != 0 {
+		t.Errorf("one = %+v, want 2 published", got)
+	}
+	if got := reports["wss://two.example"]; got.Published != 1 || got.Failed != 1 || got.LastError != "timeout" {
+		t.Errorf("two = %+v, want 1 published and 1 failed", got)
+	}
+}
@@ -0,0 +1,215 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const restorePageSize = 500
+
+type RestoreReport struct {
+	Events   int `json:"events"`
+	Invalid  int `json:"invalid"`
+	Restored int `json:"restored"`
+	Existing int `json:"existing"`
+	Failed   int `json:"failed"`
+}
+
+var queryRelay = func(ctx context.Context, url string, filter nostr.Filter) ([]*nostr.Event, error) {
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer relay.Close()
+
+	return relay.QuerySync(ctx, filter)
+}
+
+func FetchNostrRecords(cfg *Config, relays []string, since, until time.Time) ([]nostr.Event, error) {
+	pk := cfg.NostrPublicKey
+	if pk == "" {
+		return nil, fmt.Errorf("NOSTR_NPUB must be configured to restore")
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[string]nostr.Event{}
+
+	for _, relayURL := range relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			events, err := fetchRelayHistory(url, pk, since, until)
+			if err != nil {
+				log.Printf("failed to query relay %s: %v", url, err)
+			}
+
+			log.Printf("fetched %d events from %s", len(events), url)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ev := range events {
+				seen[ev.ID] = *ev
+			}
+		}(relayURL)
+	}
+	wg.Wait()
+
+	events := make([]nostr.Event, 0, len(seen))
+	for _, ev := range seen {
+		events = append(events, ev)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt > events[j].CreatedAt
+	})
+
+	return events, nil
+}
+
+func fetchRelayHistory(url, pk string, since, until time.Time) ([]*nostr.Event, error) {
+	filter := nostr.Filter{
+		Kinds:   []int{nostr.KindApplicationSpecificData},
+		Authors: []string{pk},
+		Limit:   restorePageSize,
+	}
+
+	if !since.IsZero() {
+		ts := nostr.Timestamp(since.Unix())
+		filter.Since = &ts
+	}
+
+	if !until.IsZero() {
+		ts := nostr.Timestamp(until.Unix())
+		filter.Until = &ts
+	}
+
+	seen := map[string]bool{}
+	events := []*nostr.Event{}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		page, err := queryRelay(ctx, url, filter)
+		cancel()
+		if err != nil {
+			return events, err
+		}
+
+		oldest := nostr.Timestamp(0)
+		added := 0
+		for _, ev := range page {
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			events = append(events, ev)
+			added++
+
+			if oldest == 0 || ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+
+		if added == 0 || len(page) < restorePageSize {
+			return events, nil
+		}
+
+		filter.Until = &oldest
+	}
+}
+
+func DecodeNostrRecordEvent(ev nostr.Event, pk string) (IncomingInvoice, error) {
+	if ev.PubKey != pk {
+		return IncomingInvoice{}, fmt.Errorf("event %s is not authored by %s", ev.ID, pk)
+	}
+
+	if ok, err := ev.CheckSignature(); !ok {
+		return IncomingInvoice{}, fmt.Errorf("event %s has an invalid signature: %v", ev.ID, err)
+	}
+
+	var record NostrRecord
+	if err := json.Unmarshal([]byte(ev.Content), &record); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal nostr record: %w", err)
+	}
+
+	if record.PaymentHash == "" {
+		return IncomingInvoice{}, fmt.Errorf("event %s has no payment hash", ev.ID)
+	}
+
+	return record.Invoice(), nil
+}
+
+func (r NostrRecord) Invoice() IncomingInvoice {
+	invoice := IncomingInvoice{
+		Amount:       r.Amount,
+		Comment:      r.Comment,
+		CreatedAt:    r.CreatedAt,
+		CreationDate: r.CreationDate,
+		Description:  r.Description,
+		Identifier:   r.Identifier,
+		PayerName:    r.PayerName,
+		PaymentHash:  r.PaymentHash,
+		Type:         "incoming",
+		Value:        r.Value,
+	}
+
+	if r.Boostagram != nil && *r.Boostagram != (Boostagram{}) {
+		boostagram := *r.Boostagram
+		invoice.Boostagram = &boostagram
+	}
+
+	return invoice
+}
+
+func RestoreInvoices(cfg *Config, events []nostr.Event, dryRun bool) (RestoreReport, error) {
+	pk := cfg.NostrPublicKey
+	if pk == "" {
+		return RestoreReport{}, fmt.Errorf("NOSTR_NPUB must be configured to restore")
+	}
+
+	var store InvoiceStore
+	if !dryRun {
+		var err error
+		if store, err = SharedStore(cfg); err != nil {
+			return RestoreReport{}, err
+		}
+	}
+
+	report := RestoreReport{Events: len(events)}
+	for _, ev := range events {
+		invoice, err := DecodeNostrRecordEvent(ev, pk)
+		if err != nil {
+			log.Printf("skipping event %s: %v", ev.ID, err)
+			report.Invalid++
+			continue
+		}
+
+		if dryRun {
+			report.Restored++
+			continue
+		}
+
+		isNew, err := store.RestoreInvoice(context.Background(), invoice)
+		if err != nil {
+			log.Printf("failed to restore %s: %v", invoice.PaymentHash, err)
+			report.Failed++
+			continue
+		}
+
+		if isNew {
+			report.Restored++
+		} else {
+			report.Existing++
+		}
+	}
+
+	return report, nil
+}
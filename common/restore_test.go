@@ -0,0 +1,157 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func signedRecordEvent(t *testing.T, sk string, record NostrRecord) nostr.Event {
+	t.Helper()
+
+	pk, _ := nostr.GetPublicKey(sk)
+	content, _ := json.Marshal(record)
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nostr.Timestamp(1700000000),
+		Kind:      nostr.KindApplicationSpecificData,
+		Content:   string(content),
+	}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	return ev
+}
+
+func TestDecodeNostrRecordEvent(t *testing.T) {
+	t.Parallel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	original := IncomingInvoice{
+		Amount:       21,
+		Comment:      "great show",
+		CreatedAt:    "2023-11-14T22:13:20Z",
+		CreationDate: 1700000000,
+		Identifier:   "hash-1",
+		PaymentHash:  "hash-1",
+		PayerName:    "Alice",
+		Value:        21,
+		Boostagram:   &Boostagram{Action: "boost", Podcast: "Show", EventGuid: "event-1"},
+	}
+
+	ev := signedRecordEvent(t, sk, original.GetNostrRecord())
+
+	invoice, err := DecodeNostrRecordEvent(ev, pk)
+	if err != nil {
+		t.Fatalf("DecodeNostrRecordEvent() error = %v", err)
+	}
+
+	want, _ := json.Marshal(original.GetNostrRecord())
+	got, _ := json.Marshal(invoice.GetNostrRecord())
+	if string(got) != string(want) {
+		t.Errorf("nostr record = %s, want %s", got, want)
+	}
+
+	if invoice.Type != "incoming" {
+		t.Errorf("Type = %q, want incoming", invoice.Type)
+	}
+}
+
+func TestDecodeNostrRecordEventWithoutBoostagram(t *testing.T) {
+	t.Parallel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	ev := signedRecordEvent(t, sk, IncomingInvoice{PaymentHash: "hash-1"}.GetNostrRecord())
+
+	invoice, err := DecodeNostrRecordEvent(ev, pk)
+	if err != nil {
+		t.Fatalf("DecodeNostrRecordEvent() error = %v", err)
+	}
+
+	if invoice.Boostagram != nil {
+		t.Errorf("Boostagram = %+v, want nil", invoice.Boostagram)
+	}
+}
+
+func TestDecodeNostrRecordEventRejects(t *testing.T) {
+	t.Parallel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	record := IncomingInvoice{PaymentHash: "hash-1"}.GetNostrRecord()
+
+	tampered := signedRecordEvent(t, sk, record)
+	tampered.Content = `{"payment_hash":"forged"}`
+
+	other := signedRecordEvent(t, nostr.GeneratePrivateKey(), record)
+
+	foreign := signedRecordEvent(t, sk, NostrRecord{})
+	foreign.Content = `{"some":"other app data"}`
+	foreign.Sign(sk)
+
+	tests := []struct {
+		name string
+		ev   nostr.Event
+	}{
+		{name: "tampered content", ev: tampered},
+		{name: "other author", ev: other},
+		{name: "not a nostr record", ev: foreign},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := DecodeNostrRecordEvent(tt.ev, pk); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestFetchRelayHistoryPages(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	all := []*nostr.Event{}
+	for i := 0; i < restorePageSize+10; i++ {
+		all = append(all, &nostr.Event{ID: string(rune('a'+i%26)) + time.Duration(i).String(), CreatedAt: nostr.Timestamp(2000 - i)})
+	}
+
+	original := queryRelay
+	queryRelay = func(ctx context.Context, url string, filter nostr.Filter) ([]*nostr.Event, error) {
+		if len(filter.Authors) != 1 || filter.Authors[0] != pk {
+			t.Errorf("Authors = %v, want [%s]", filter.Authors, pk)
+		}
+
+		page := []*nostr.Event{}
+		for _, ev := range all {
+			if filter.Until != nil && ev.CreatedAt > *filter.Until {
+				continue
+			}
+			if len(page) == filter.Limit {
+				break
+			}
+			page = append(page, ev)
+		}
+		return page, nil
+	}
+	t.Cleanup(func() { queryRelay = original })
+
+	events, err := fetchRelayHistory("wss://relay.example", pk, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("fetchRelayHistory() error = %v", err)
+	}
+
+	if len(events) != len(all) {
+		t.Errorf("got %d events, want %d", len(events), len(all))
+	}
+}
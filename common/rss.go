@@ -27,6 +27,20 @@ func ExtractRSSPaymentURL(comment string) string {
 	return ""
 }
 
+type RSSPaymentEnricher struct{}
+
+func (RSSPaymentEnricher) Enrich(invoice *IncomingInvoice) (bool, error) {
+	if invoice.RSSPayment != nil {
+		return false, nil
+	}
+
+	if err := FetchRSSPaymentIfNeeded(invoice); err != nil {
+		return false, err
+	}
+
+	return invoice.RSSPayment != nil, nil
+}
+
 func FetchRSSPaymentIfNeeded(invoice *IncomingInvoice) error {
 	if invoice.RSSPayment != nil {
 		return nil
@@ -0,0 +1,910 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nullableJSON keeps invoices without metadata as NULL rather than an empty
+// string, which is not valid JSON.
+func nullableJSON(data []byte) any {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
+
+func (s *SQLStore) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	return tx.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) SaveInvoiceIfNew(ctx context.Context, invoice IncomingInvoice) (bool, error) {
+	return s.saveInvoice(ctx, invoice, true)
+}
+
+func (s *SQLStore) RestoreInvoice(ctx context.Context, invoice IncomingInvoice) (bool, error) {
+	return s.saveInvoice(ctx, invoice, false)
+}
+
+func (s *SQLStore) saveInvoice(ctx context.Context, invoice IncomingInvoice, enqueue bool) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	serializedMetadata, err := invoice.GetSerializedMetadata()
+	if err != nil {
+		return false, fmt.Errorf("failed to serialize boostagram for invoice %s: %w", invoice.PaymentHash, err)
+	}
+
+	boostagram := invoice.GetBoostagram()
+	insertSQL :=
+		`INSERT INTO invoices
+        (amount, boostagram, comment, created_at, creation_date, description, identifier, payer_name, payment_hash, value, podcast, episode, app_name, sender_name, message, value_msat_total, feed_id, item_id, guid, episode_guid, action, event_guid, direction)
+    VALUES
+        ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+    ON CONFLICT (payment_hash) DO NOTHING`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := s.exec(ctx, tx,
+		insertSQL,
+		invoice.Amount,
+		nullableJSON(serializedMetadata),
+		invoice.Comment,
+		invoice.CreatedAt,
+		invoice.CreationDate,
+		invoice.Description,
+		invoice.Identifier,
+		invoice.PayerName,
+		invoice.PaymentHash,
+		invoice.Value,
+		boostagram.Podcast,
+		boostagram.Episode,
+		boostagram.AppName,
+		boostagram.SenderName,
+		boostagram.Message,
+		boostagram.ValueMsatTotal,
+		boostagram.FeedID,
+		boostagram.ItemID,
+		boostagram.Guid,
+		boostagram.EpisodeGuid,
+		boostagram.Action,
+		boostagram.EventGuid,
+		invoice.GetDirection(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	// Payments that are never published, such as outgoing payments and
+	// streams, have no outbox entry.
+	if enqueue && invoice.IsPublished() {
+		content, err := outboxContent(invoice)
+		if err != nil {
+			return false, err
+		}
+
+		now := time.Now().UTC()
+		if _, err := s.exec(ctx, tx,
+			`INSERT INTO nostr_outbox (payment_hash, content, next_attempt_at, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT (payment_hash) DO NOTHING`,
+			invoice.PaymentHash,
+			content,
+			now,
+			now,
+		); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+func (s *SQLStore) UpdateInvoiceRSSPayment(ctx context.Context, invoice IncomingInvoice) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if invoice.RSSPayment == nil {
+		return nil
+	}
+
+	serializedMetadata, err := invoice.GetSerializedMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to serialize boostagram for invoice %s: %w", invoice.PaymentHash, err)
+	}
+
+	content, err := outboxContent(invoice)
+	if err != nil {
+		return err
+	}
+
+	boostagram := invoice.GetBoostagram()
+	updateSQL :=
+		`UPDATE invoices SET
+        boostagram = $1,
+        podcast = $2,
+        episode = $3,
+        app_name = $4,
+        sender_name = $5,
+        message = $6,
+        value_msat_total = $7,
+        feed_id = $8,
+        item_id = $9,
+        guid = $10,
+        episode_guid = $11,
+        action = $12,
+        event_guid = $13
+    WHERE payment_hash = $14`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.exec(ctx, tx,
+		updateSQL,
+		nullableJSON(serializedMetadata),
+		boostagram.Podcast,
+		boostagram.Episode,
+		boostagram.AppName,
+		boostagram.SenderName,
+		boostagram.Message,
+		boostagram.ValueMsatTotal,
+		boostagram.FeedID,
+		boostagram.ItemID,
+		boostagram.Guid,
+		boostagram.EpisodeGuid,
+		boostagram.Action,
+		boostagram.EventGuid,
+		invoice.PaymentHash,
+	); err != nil {
+		return err
+	}
+
+	// The RSS payment may reveal that a queued payment is a stream, in which
+	// case its entry is dropped instead of updated.
+	if invoice.IsPublished() {
+		_, err = s.exec(ctx, tx,
+			`UPDATE nostr_outbox SET content = $1 WHERE payment_hash = $2 AND published_at IS NULL`,
+			content,
+			invoice.PaymentHash,
+		)
+	} else {
+		_, err = s.exec(ctx, tx,
+			`DELETE FROM nostr_outbox WHERE payment_hash = $1 AND published_at IS NULL`,
+			invoice.PaymentHash,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetBoostHidden hides a boost from the boards or restores it, reporting
+// whether a boost with the identifier exists.
+func (s *SQLStore) SetBoostHidden(ctx context.Context, identifier string, hidden bool) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(
+		`UPDATE invoices SET hidden = $1 WHERE identifier = $2`),
+		hidden,
+		identifier,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// boostFilters turns the /api/boosts filter parameters into WHERE clauses,
+// shared by every query that reads boosts.
+func (s *SQLStore) boostFilters(query map[string]string) ([]string, []any, error) {
+	// Hidden boosts stay stored but never reach the boards.
+	where := []string{`NOT hidden`}
+	var params []any
+
+	// Boards only want what the show received unless they ask otherwise.
+	switch direction := query["q[direction]"]; direction {
+	case "", DirectionIncoming, DirectionOutgoing:
+		if direction == "" {
+			direction = DirectionIncoming
+		}
+		params = append(params, direction)
+		where = append(where, fmt.Sprintf(`direction = $%d`, len(params)))
+	case "all":
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown direction %q", ErrInvalidBoostQuery, direction)
+	}
+
+	if val, ok := query["q[created_at_lt]"]; ok {
+		params = append(params, val)
+		where = append(where, fmt.Sprintf(`creation_date <= $%d`, len(params)))
+	}
+
+	if val, ok := query["q[created_at_gt]"]; ok {
+		params = append(params, val)
+		where = append(where, fmt.Sprintf(`creation_date >= $%d`, len(params)))
+	}
+
+	if val, ok := query["q[since]"]; ok {
+		params = append(params, val)
+		where = append(where, fmt.Sprintf(`creation_date >= (SELECT MAX(creation_date) FROM invoices WHERE identifier = $%d)`, len(params)))
+
+		params = append(params, val)
+		where = append(where, fmt.Sprintf(`identifier <> $%d`, len(params)))
+	}
+
+	podcast, hasPodcast := query["q[podcast]"]
+	eventGuid, hasEventGuid := query["q[eventGuid]"]
+	episodeGuid, hasEpisodeGuid := query["q[episodeGuid]"]
+	placeholders := []string{}
+
+	if hasPodcast {
+		for _, val := range strings.Split(podcast, ",") {
+			if val == "" {
+				placeholders = append(placeholders, `podcast = ''`)
+				continue
+			}
+			params = append(params, "%"+val+"%")
+			placeholders = append(placeholders, fmt.Sprintf(`podcast %s $%d`, s.dialect.ilike, len(params)))
+		}
+	}
+
+	if hasEventGuid {
+		for _, val := range strings.Split(eventGuid, ",") {
+			if val == "" {
+				continue
+			}
+			params = append(params, val)
+			placeholders = append(placeholders, fmt.Sprintf(`event_guid = $%d`, len(params)))
+		}
+	}
+
+	if hasEpisodeGuid {
+		for _, val := range strings.Split(episodeGuid, ",") {
+			if val == "" {
+				continue
+			}
+			params = append(params, val)
+			placeholders = append(placeholders, fmt.Sprintf(`episode_guid = $%d`, len(params)))
+		}
+	}
+
+	if len(placeholders) > 0 {
+		where = append(where, fmt.Sprintf(`(%s)`, strings.Join(placeholders, " OR ")))
+	}
+
+	return where, params, nil
+}
+
+func (s *SQLStore) GetBoosts(ctx context.Context, query map[string]string) ([]IncomingBoost, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, params, err := s.boostFilters(query)
+	if err != nil {
+		return nil, err
+	}
+
+	items := defaultBoostPageSize
+	offset := 0
+
+	if val, ok := query["items"]; ok {
+		num, err := strconv.Atoi(val)
+		if err != nil || num < 1 {
+			return nil, fmt.Errorf("%w: items must be a positive number", ErrInvalidBoostQuery)
+		}
+
+		items = min(num, maxBoostPageSize)
+	}
+
+	order := "DESC"
+
+	// A cursor replaces page: it pins the position to a boost rather than
+	// an offset, so boosts arriving mid-show cannot shift the pages.
+	if val, ok := query["cursor"]; ok {
+		cursor, err := DecodeBoostCursor(val)
+		if err != nil {
+			return nil, err
+		}
+
+		cmp := "<"
+		if cursor.Before {
+			cmp, order = ">", "ASC"
+		}
+
+		params = append(params, cursor.CreationDate, cursor.Identifier)
+		where = append(where, fmt.Sprintf(`(creation_date %[1]s $%[2]d OR (creation_date = $%[2]d AND identifier %[1]s $%[3]d))`, cmp, len(params)-1, len(params)))
+	} else if val, ok := query["page"]; ok {
+		pg, err := strconv.Atoi(val)
+		if err != nil || pg < 1 {
+			return nil, fmt.Errorf("%w: page must be a positive number", ErrInvalidBoostQuery)
+		}
+
+		offset = (pg - 1) * items
+	}
+
+	sql := fmt.Sprintf(`SELECT amount, boostagram, created_at, creation_date, identifier, value, direction FROM invoices WHERE %s ORDER BY creation_date %[2]s, identifier %[2]s LIMIT %d OFFSET %d`, strings.Join(where, " AND "), order, items, offset)
+
+	rows, err := s.query(ctx, sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boosts := []IncomingBoost{}
+
+	// Loop through rows, using Scan to assign column data to struct fields.
+	for rows.Next() {
+		var item IncomingBoost
+		var boostagram string
+
+		if err := rows.Scan(&item.Amount, &boostagram, &item.CreatedAt, &item.CreationDate, &item.Identifier, &item.Value, &item.Direction); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(boostagram), &item.Boostagram); err != nil {
+			return nil, err
+		}
+
+		boosts = append(boosts, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pages before a cursor are read oldest first so LIMIT keeps the boosts
+	// nearest the cursor; flip them back to newest first.
+	if order == "ASC" {
+		slices.Reverse(boosts)
+	}
+
+	return boosts, nil
+}
+
+// boostSats counts a boost at its full value_msat_total, as the boards do,
+// falling back to the amount received when the total is unknown.
+const boostSats = `CASE WHEN value_msat_total > 0 THEN value_msat_total / 1000 ELSE amount END`
+
+// boostActions limits totals to the payments the boards count.
+const boostActions = `action IN ('boost', 'zap')`
+
+func (s *SQLStore) GetLeaderboard(ctx context.Context, query map[string]string, sendersLimit, appsLimit int) (Leaderboard, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, params, err := s.boostFilters(query)
+	if err != nil {
+		return Leaderboard{}, err
+	}
+	where = append(where, boostActions)
+
+	leaderboard := Leaderboard{}
+	for _, group := range []struct {
+		column  string
+		blank   string
+		limit   int
+		entries *[]LeaderboardEntry
+	}{
+		{"sender_name", "Anonymous", sendersLimit, &leaderboard.Senders},
+		{"app_name", "Unknown", appsLimit, &leaderboard.Apps},
+	} {
+		sql := fmt.Sprintf(`SELECT %[1]s, action, SUM(%[2]s), COUNT(*) FROM invoices WHERE %[3]s GROUP BY %[1]s, action`, group.column, boostSats, strings.Join(where, " AND "))
+
+		rows, err := s.query(ctx, sql, params...)
+		if err != nil {
+			return Leaderboard{}, err
+		}
+
+		var totals []leaderboardRow
+		for rows.Next() {
+			var row leaderboardRow
+			if err := rows.Scan(&row.Name, &row.Action, &row.Sats, &row.Count); err != nil {
+				rows.Close()
+				return Leaderboard{}, err
+			}
+			totals = append(totals, row)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return Leaderboard{}, err
+		}
+
+		*group.entries = rankLeaderboard(totals, group.blank, group.limit)
+	}
+
+	return leaderboard, nil
+}
+
+func (s *SQLStore) GetBoostTotals(ctx context.Context, query map[string]string) (BoostTotals, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, params, err := s.boostFilters(query)
+	if err != nil {
+		return BoostTotals{}, err
+	}
+	where = append(where, boostActions)
+
+	return s.boostTotals(ctx, strings.Join(where, " AND "), params)
+}
+
+func (s *SQLStore) boostTotals(ctx context.Context, conditions string, params []any) (BoostTotals, error) {
+	var totals BoostTotals
+	var sats float64
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(fmt.Sprintf(
+		`SELECT COALESCE(SUM(%s), 0), COUNT(*), COUNT(DISTINCT NULLIF(sender_name, '')) FROM invoices WHERE %s`,
+		boostSats, conditions,
+	)), params...).Scan(&sats, &totals.Boosts, &totals.Senders)
+	if err != nil {
+		return BoostTotals{}, err
+	}
+	totals.Sats = int64(sats)
+
+	return totals, nil
+}
+
+func (s *SQLStore) GetStats(ctx context.Context, query map[string]string, interval time.Duration) (Stats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, params, err := s.boostFilters(query)
+	if err != nil {
+		return Stats{}, err
+	}
+	where = append(where, boostActions)
+	conditions := strings.Join(where, " AND ")
+
+	var stats Stats
+	stats.BoostTotals, err = s.boostTotals(ctx, conditions, params)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	step := int64(interval / time.Second)
+	bucket := fmt.Sprintf(s.dialect.floor, fmt.Sprintf("creation_date / %d", step)) + fmt.Sprintf(" * %d", step)
+
+	rows, err := s.query(ctx, fmt.Sprintf(
+		`SELECT %s, SUM(%s), COUNT(*) FROM invoices WHERE %s GROUP BY 1 ORDER BY 1`,
+		bucket, boostSats, conditions,
+	), params...)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	buckets := map[int64]StatsBucket{}
+	var first, last int64
+	for rows.Next() {
+		var start, sats float64
+		var count int
+		if err := rows.Scan(&start, &sats, &count); err != nil {
+			return Stats{}, err
+		}
+
+		key := int64(start)
+		if len(buckets) == 0 {
+			first = key
+		}
+		last = key
+		buckets[key] = StatsBucket{Time: time.Unix(key, 0).UTC(), Sats: int64(sats), Boosts: count}
+	}
+
+	if err := rows.Err(); err != nil {
+		return Stats{}, err
+	}
+
+	stats.Series, err = fillStatsSeries(buckets, first, last, interval)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return stats, nil
+}
+
+func (s *SQLStore) LoadInvoices(ctx context.Context, filter InvoiceFilter) ([]IncomingInvoice, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var where []string
+	var params []any
+
+	if !filter.Since.IsZero() {
+		params = append(params, filter.Since.Unix())
+		where = append(where, fmt.Sprintf(`creation_date >= $%d`, len(params)))
+	}
+
+	if !filter.Until.IsZero() {
+		params = append(params, filter.Until.Unix())
+		where = append(where, fmt.Sprintf(`creation_date <= $%d`, len(params)))
+	}
+
+	if filter.EventGuid != "" {
+		params = append(params, filter.EventGuid)
+		where = append(where, fmt.Sprintf(`event_guid = $%d`, len(params)))
+	}
+
+	if filter.Direction != "" {
+		params = append(params, filter.Direction)
+		where = append(where, fmt.Sprintf(`direction = $%d`, len(params)))
+	}
+
+	if len(where) == 0 {
+		where = append(where, "1=1")
+	}
+
+	query := fmt.Sprintf(`SELECT amount, boostagram, comment, created_at, creation_date, description, identifier, payer_name, payment_hash, value, direction FROM invoices WHERE %s ORDER BY creation_date`, strings.Join(where, " AND "))
+
+	rows, err := s.query(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []IncomingInvoice{}
+	for rows.Next() {
+		var invoice IncomingInvoice
+		var metadata string
+
+		if err := rows.Scan(&invoice.Amount, &metadata, &invoice.Comment, &invoice.CreatedAt, &invoice.CreationDate, &invoice.Description, &invoice.Identifier, &invoice.PayerName, &invoice.PaymentHash, &invoice.Value, &invoice.Direction); err != nil {
+			return nil, err
+		}
+
+		if err := applyStoredMetadata(&invoice, []byte(metadata)); err != nil {
+			return nil, fmt.Errorf("failed to decode boostagram for %s: %w", invoice.PaymentHash, err)
+		}
+
+		invoices = append(invoices, invoice)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
+
+// ExistingPaymentHashes reports which of hashes are already stored.
+func (s *SQLStore) ExistingPaymentHashes(ctx context.Context, hashes []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(hashes) == 0 {
+		return existing, nil
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	placeholders := make([]string, len(hashes))
+	args := make([]any, len(hashes))
+	for i, hash := range hashes {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = hash
+	}
+
+	rows, err := s.query(ctx, `SELECT payment_hash FROM invoices WHERE payment_hash IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		existing[hash] = true
+	}
+
+	return existing, rows.Err()
+}
+
+func (s *SQLStore) GetOutboxEntry(ctx context.Context, paymentHash string) (OutboxEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.query(ctx, `SELECT `+outboxColumns+` FROM nostr_outbox WHERE payment_hash = $1`, paymentHash)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+
+	if len(entries) == 0 {
+		return OutboxEntry{}, fmt.Errorf("%w: %s", errOutboxMissing, paymentHash)
+	}
+
+	return entries[0], nil
+}
+
+func (s *SQLStore) DueOutboxEntries(ctx context.Context, now time.Time, maxAttempts, limit int) ([]OutboxEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.query(ctx,
+		`SELECT `+outboxColumns+` FROM nostr_outbox
+        WHERE published_at IS NULL AND next_attempt_at <= $1 AND attempts < $2
+        ORDER BY next_attempt_at
+        LIMIT $3`,
+		now,
+		maxAttempts,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanOutboxEntries(rows)
+}
+
+func (s *SQLStore) StuckOutboxEntries(ctx context.Context, createdBefore time.Time, maxAttempts int) ([]OutboxEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.query(ctx,
+		`SELECT `+outboxColumns+` FROM nostr_outbox
+        WHERE published_at IS NULL AND (attempts >= $1 OR created_at <= $2)
+        ORDER BY created_at`,
+		maxAttempts,
+		createdBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanOutboxEntries(rows)
+}
+
+// ClaimOutboxEntry counts a delivery attempt on a due entry and holds it
+// back from other deliveries until the attempt is saved or until passes. It
+// fails if the entry is not due or another delivery counted an attempt since
+// the entry was read.
+func (s *SQLStore) ClaimOutboxEntry(ctx context.Context, entry OutboxEntry, now, until time.Time) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(
+		`UPDATE nostr_outbox SET attempts = attempts + 1, next_attempt_at = $1
+    WHERE payment_hash = $2 AND attempts = $3 AND next_attempt_at <= $4 AND published_at IS NULL`),
+		until,
+		entry.PaymentHash,
+		entry.Attempts,
+		now,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *SQLStore) SaveOutboxAttempt(ctx context.Context, entry OutboxEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if entry.AckedRelays == nil {
+		entry.AckedRelays = []string{}
+	}
+
+	acked, err := json.Marshal(entry.AckedRelays)
+	if err != nil {
+		return err
+	}
+
+	var publishedAt sql.NullTime
+	if entry.PublishedAt != nil {
+		publishedAt = sql.NullTime{Time: *entry.PublishedAt, Valid: true}
+	}
+
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(
+		`UPDATE nostr_outbox SET
+        attempts = $1,
+        acked_relays = $2,
+        last_error = $3,
+        next_attempt_at = $4,
+        published_at = $5
+    WHERE payment_hash = $6`),
+		entry.Attempts,
+		string(acked),
+		entry.LastError,
+		entry.NextAttemptAt,
+		publishedAt,
+		entry.PaymentHash,
+	)
+
+	return err
+}
+
+func (s *SQLStore) ArchivePayload(ctx context.Context, payload ArchivedPayload) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	headers, err := json.Marshal(payload.Headers)
+	if err != nil {
+		return 0, err
+	}
+
+	if payload.Status == "" {
+		payload.Status = PayloadReceived
+	}
+
+	if payload.ReceivedAt.IsZero() {
+		payload.ReceivedAt = time.Now().UTC()
+	}
+
+	var id int64
+	err = s.db.QueryRowContext(ctx, s.dialect.rebind(
+		`INSERT INTO webhook_payloads (source, headers, payload, status, received_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`),
+		payload.Source,
+		string(headers),
+		payload.Payload,
+		string(payload.Status),
+		payload.ReceivedAt,
+	).Scan(&id)
+
+	return id, err
+}
+
+func (s *SQLStore) RecordPayloadOutcome(ctx context.Context, id int64, outcome PayloadOutcome) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(
+		`UPDATE webhook_payloads SET
+        status = $1,
+        stage = $2,
+        error = $3,
+        payment_hash = $4,
+        attempts = attempts + 1,
+        processed_at = $5
+    WHERE id = $6`),
+		string(outcome.Status),
+		string(outcome.Stage),
+		outcome.Error,
+		outcome.PaymentHash,
+		time.Now().UTC(),
+		id,
+	)
+
+	return err
+}
+
+func (s *SQLStore) ListPayloads(ctx context.Context, filter PayloadFilter) ([]ArchivedPayload, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var where []string
+	var params []any
+
+	inList := func(column string, values []any) {
+		placeholders := []string{}
+		for _, val := range values {
+			params = append(params, val)
+			placeholders = append(placeholders, fmt.Sprintf(`$%d`, len(params)))
+		}
+		where = append(where, fmt.Sprintf(`%s IN (%s)`, column, strings.Join(placeholders, ", ")))
+	}
+
+	if len(filter.IDs) > 0 {
+		values := []any{}
+		for _, id := range filter.IDs {
+			values = append(values, id)
+		}
+		inList("id", values)
+	}
+
+	if len(filter.Sources) > 0 {
+		values := []any{}
+		for _, source := range filter.Sources {
+			values = append(values, source)
+		}
+		inList("source", values)
+	}
+
+	if len(filter.Statuses) > 0 {
+		values := []any{}
+		for _, status := range filter.Statuses {
+			values = append(values, string(status))
+		}
+		inList("status", values)
+	}
+
+	if !filter.Since.IsZero() {
+		params = append(params, filter.Since.UTC())
+		where = append(where, fmt.Sprintf(`received_at >= $%d`, len(params)))
+	}
+
+	if !filter.Until.IsZero() {
+		params = append(params, filter.Until.UTC())
+		where = append(where, fmt.Sprintf(`received_at <= $%d`, len(params)))
+	}
+
+	if len(where) == 0 {
+		where = append(where, "1=1")
+	}
+
+	order := "ASC"
+	if filter.Newest {
+		order = "DESC"
+	}
+
+	query := fmt.Sprintf(`SELECT id, source, headers, payload, status, stage, error, payment_hash, attempts, received_at, processed_at FROM webhook_payloads WHERE %s ORDER BY id %s`, strings.Join(where, " AND "), order)
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, filter.Limit)
+	}
+
+	rows, err := s.query(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payloads := []ArchivedPayload{}
+	for rows.Next() {
+		var payload ArchivedPayload
+		var headers, status, stage string
+		var processedAt sql.NullTime
+
+		if err := rows.Scan(&payload.ID, &payload.Source, &headers, &payload.Payload, &status, &stage, &payload.Error, &payload.PaymentHash, &payload.Attempts, &payload.ReceivedAt, &processedAt); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(headers), &payload.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode headers for payload %d: %w", payload.ID, err)
+		}
+
+		payload.Status = PayloadStatus(status)
+		payload.Stage = PipelineStage(stage)
+		if processedAt.Valid {
+			payload.ProcessedAt = &processedAt.Time
+		}
+
+		payloads = append(payloads, payload)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payloads, nil
+}
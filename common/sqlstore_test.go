@@ -0,0 +1,400 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testSQLiteStore(t *testing.T) (*Config, *SQLStore) {
+	t.Helper()
+
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	store, err := openSQLStore(cfg)
+	if err != nil {
+		t.Fatalf("openSQLStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	return cfg, store
+}
+
+func testStoreInvoice(hash string, creationDate float64, boostagram Boostagram) IncomingInvoice {
+	return IncomingInvoice{
+		Amount:       100,
+		CreatedAt:    time.Unix(int64(creationDate), 0).UTC().Format(time.RFC3339),
+		CreationDate: creationDate,
+		Identifier:   "id-" + hash,
+		PaymentHash:  hash,
+		Value:        100,
+		Boostagram:   &boostagram,
+	}
+}
+
+func TestSQLStoreSaveInvoiceIfNew(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+	invoice := testStoreInvoice("hash-1", 1700000000, Boostagram{Podcast: "Podcast", EventGuid: "event-1"})
+
+	isNew, err := store.SaveInvoiceIfNew(ctx, invoice)
+	if err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+	if !isNew {
+		t.Fatal("SaveInvoiceIfNew() isNew = false, want true")
+	}
+
+	isNew, err = store.SaveInvoiceIfNew(ctx, invoice)
+	if err != nil {
+		t.Fatalf("SaveInvoiceIfNew() duplicate error = %v", err)
+	}
+	if isNew {
+		t.Error("SaveInvoiceIfNew() duplicate isNew = true, want false")
+	}
+
+	entry, err := store.GetOutboxEntry(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v", err)
+	}
+	if entry.Attempts != 0 || entry.PublishedAt != nil {
+		t.Errorf("outbox entry = %+v, want a fresh entry", entry)
+	}
+}
+
+func TestSQLStoreRestoreInvoiceSkipsOutbox(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	isNew, err := store.RestoreInvoice(ctx, testStoreInvoice("hash-1", 1700000000, Boostagram{}))
+	if err != nil {
+		t.Fatalf("RestoreInvoice() error = %v", err)
+	}
+	if !isNew {
+		t.Fatal("RestoreInvoice() isNew = false, want true")
+	}
+
+	if _, err := store.GetOutboxEntry(ctx, "hash-1"); err == nil {
+		t.Error("GetOutboxEntry() error = nil, want missing entry")
+	}
+}
+
+func TestSQLStoreOutgoingInvoiceSkipsOutbox(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	invoice := testStoreInvoice("hash-1", 1700000000, Boostagram{})
+	invoice.Direction = DirectionOutgoing
+	if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+
+	if _, err := store.GetOutboxEntry(ctx, "hash-1"); err == nil {
+		t.Error("GetOutboxEntry() error = nil, want no entry for an outgoing payment")
+	}
+
+	invoices, err := store.LoadInvoices(ctx, InvoiceFilter{Direction: DirectionIncoming})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 0 {
+		t.Errorf("LoadInvoices(incoming) = %+v, want none", invoices)
+	}
+
+	invoices, err = store.LoadInvoices(ctx, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].Direction != DirectionOutgoing {
+		t.Errorf("LoadInvoices() = %+v, want the outgoing invoice", invoices)
+	}
+}
+
+func TestSQLStoreUpdateInvoiceRSSPaymentDropsStreams(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	invoice := testStoreInvoice("hash-1", 1700000000, Boostagram{})
+	invoice.Boostagram = nil
+	if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+	if _, err := store.GetOutboxEntry(ctx, "hash-1"); err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v, want the payment queued", err)
+	}
+
+	invoice.RSSPayment = &RssPayment{Action: "stream"}
+	if err := store.UpdateInvoiceRSSPayment(ctx, invoice); err != nil {
+		t.Fatalf("UpdateInvoiceRSSPayment() error = %v", err)
+	}
+
+	if _, err := store.GetOutboxEntry(ctx, "hash-1"); err == nil {
+		t.Error("GetOutboxEntry() error = nil, want the stream dropped from the outbox")
+	}
+}
+
+func TestSQLStoreUpdateInvoiceRSSPayment(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	invoice := testStoreInvoice("hash-1", 1700000000, Boostagram{})
+	if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+		t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+	}
+
+	invoice.Boostagram = nil
+	invoice.RSSPayment = &RssPayment{FeedTitle: "Feed", ItemTitle: "Episode", SenderName: "alice"}
+	if err := store.UpdateInvoiceRSSPayment(ctx, invoice); err != nil {
+		t.Fatalf("UpdateInvoiceRSSPayment() error = %v", err)
+	}
+
+	invoices, err := store.LoadInvoices(ctx, InvoiceFilter{})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].RSSPayment == nil || invoices[0].RSSPayment.FeedTitle != "Feed" {
+		t.Fatalf("LoadInvoices() = %+v, want RSS payment metadata", invoices)
+	}
+
+	entry, err := store.GetOutboxEntry(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v", err)
+	}
+
+	want, err := outboxContent(invoice)
+	if err != nil {
+		t.Fatalf("outboxContent() error = %v", err)
+	}
+	if entry.Content != want {
+		t.Errorf("outbox content = %s, want %s", entry.Content, want)
+	}
+}
+
+func TestSQLStoreGetBoosts(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	invoices := []IncomingInvoice{
+		testStoreInvoice("hash-1", 1700000001, Boostagram{Podcast: "Podcasting 2.0", EventGuid: "event-1"}),
+		testStoreInvoice("hash-2", 1700000002, Boostagram{Podcast: "Other Show", EventGuid: "event-2"}),
+		testStoreInvoice("hash-3", 1700000003, Boostagram{Podcast: "podcasting 2.0", EpisodeGuid: "episode-3"}),
+		testStoreInvoice("sent-1", 1700000004, Boostagram{Podcast: "Podcasting 2.0", EventGuid: "event-1"}),
+	}
+	invoices[3].Direction = DirectionOutgoing
+	for _, invoice := range invoices {
+		if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query map[string]string
+		want  []string
+	}{
+		{"all", map[string]string{}, []string{"id-hash-3", "id-hash-2", "id-hash-1"}},
+		{"podcast", map[string]string{"q[podcast]": "PODCASTING"}, []string{"id-hash-3", "id-hash-1"}},
+		{"event guid", map[string]string{"q[eventGuid]": "event-2"}, []string{"id-hash-2"}},
+		{"episode guid", map[string]string{"q[episodeGuid]": "episode-3"}, []string{"id-hash-3"}},
+		{"since", map[string]string{"q[since]": "id-hash-2"}, []string{"id-hash-3"}},
+		{"created after", map[string]string{"q[created_at_gt]": "1700000002"}, []string{"id-hash-3", "id-hash-2"}},
+		{"paged", map[string]string{"items": "1", "page": "2"}, []string{"id-hash-2"}},
+		{"outgoing", map[string]string{"q[direction]": "outgoing"}, []string{"id-sent-1"}},
+		{"both directions", map[string]string{"q[direction]": "all", "q[eventGuid]": "event-1"}, []string{"id-sent-1", "id-hash-1"}},
+		{"after cursor", map[string]string{"items": "1", "cursor": BoostCursor{CreationDate: 1700000003, Identifier: "id-hash-3"}.Encode()}, []string{"id-hash-2"}},
+		{"before cursor", map[string]string{"items": "1", "cursor": BoostCursor{CreationDate: 1700000001, Identifier: "id-hash-1", Before: true}.Encode()}, []string{"id-hash-2"}},
+		{"cursor overrides page", map[string]string{"page": "3", "cursor": BoostCursor{CreationDate: 1700000002, Identifier: "id-hash-2"}.Encode()}, []string{"id-hash-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boosts, err := store.GetBoosts(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("GetBoosts() error = %v", err)
+			}
+
+			got := []string{}
+			for _, boost := range boosts {
+				got = append(got, boost.Identifier)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetBoosts() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("GetBoosts() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLStoreGetBoostsCursorTies(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	// Boosts in the same second must neither repeat nor vanish across pages.
+	for _, hash := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice(hash, 1700000000, Boostagram{})); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	got := []string{}
+	query := map[string]string{"items": "2"}
+	for range 5 {
+		boosts, err := store.GetBoosts(ctx, query)
+		if err != nil {
+			t.Fatalf("GetBoosts() error = %v", err)
+		}
+		if len(boosts) == 0 {
+			break
+		}
+
+		for _, boost := range boosts {
+			got = append(got, boost.Identifier)
+		}
+
+		last := boosts[len(boosts)-1]
+		query["cursor"] = BoostCursor{CreationDate: last.CreationDate, Identifier: last.Identifier}.Encode()
+	}
+
+	want := []string{"id-e", "id-d", "id-c", "id-b", "id-a"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paged boosts = %v, want %v", got, want)
+	}
+}
+
+func TestSQLStoreGetBoostsInvalidQuery(t *testing.T) {
+	_, store := testSQLiteStore(t)
+
+	for _, query := range []map[string]string{
+		{"items": "-1"},
+		{"items": "lots"},
+		{"page": "0"},
+		{"cursor": "not-a-cursor"},
+		{"q[direction]": "sideways"},
+	} {
+		if _, err := store.GetBoosts(context.Background(), query); !errors.Is(err, ErrInvalidBoostQuery) {
+			t.Errorf("GetBoosts(%v) error = %v, want ErrInvalidBoostQuery", query, err)
+		}
+	}
+}
+
+func TestSQLStoreLoadInvoices(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	for _, invoice := range []IncomingInvoice{
+		testStoreInvoice("hash-1", 1700000000, Boostagram{EventGuid: "event-1"}),
+		testStoreInvoice("hash-2", 1700001000, Boostagram{EventGuid: "event-2"}),
+		testStoreInvoice("hash-3", 1700002000, Boostagram{EventGuid: "event-1"}),
+	} {
+		if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	invoices, err := store.LoadInvoices(ctx, InvoiceFilter{
+		Since:     time.Unix(1700000500, 0),
+		EventGuid: "event-1",
+	})
+	if err != nil {
+		t.Fatalf("LoadInvoices() error = %v", err)
+	}
+
+	if len(invoices) != 1 || invoices[0].PaymentHash != "hash-3" {
+		t.Fatalf("LoadInvoices() = %+v, want only hash-3", invoices)
+	}
+	if invoices[0].Boostagram == nil || invoices[0].Boostagram.EventGuid != "event-1" {
+		t.Errorf("Boostagram = %+v, want event-1", invoices[0].Boostagram)
+	}
+}
+
+func TestSQLStoreExistingPaymentHashes(t *testing.T) {
+	t.Parallel()
+
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	for _, hash := range []string{"hash-a", "hash-b"} {
+		if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice(hash, 1700000000, Boostagram{Action: "boost"})); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	existing, err := store.ExistingPaymentHashes(ctx, []string{"hash-a", "hash-c", "hash-b"})
+	if err != nil {
+		t.Fatalf("ExistingPaymentHashes() error = %v", err)
+	}
+
+	if len(existing) != 2 || !existing["hash-a"] || !existing["hash-b"] || existing["hash-c"] {
+		t.Errorf("ExistingPaymentHashes() = %v, want hash-a and hash-b", existing)
+	}
+}
+
+func TestSQLStoreOutboxQueries(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	for _, hash := range []string{"hash-1", "hash-2"} {
+		if _, err := store.SaveInvoiceIfNew(ctx, testStoreInvoice(hash, 1700000000, Boostagram{})); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	now := time.Now().UTC()
+
+	entry, err := store.GetOutboxEntry(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetOutboxEntry() error = %v", err)
+	}
+	entry.Attempts = 3
+	entry.AckedRelays = []string{"wss://relay.example"}
+	entry.LastError = "relay unavailable"
+	entry.NextAttemptAt = now.Add(time.Hour)
+	if err := store.SaveOutboxAttempt(ctx, entry); err != nil {
+		t.Fatalf("SaveOutboxAttempt() error = %v", err)
+	}
+
+	due, err := store.DueOutboxEntries(ctx, now.Add(time.Minute), 12, 10)
+	if err != nil {
+		t.Fatalf("DueOutboxEntries() error = %v", err)
+	}
+	if len(due) != 1 || due[0].PaymentHash != "hash-2" {
+		t.Fatalf("DueOutboxEntries() = %+v, want only hash-2", due)
+	}
+
+	stuck, err := store.StuckOutboxEntries(ctx, now.Add(-time.Hour), 3)
+	if err != nil {
+		t.Fatalf("StuckOutboxEntries() error = %v", err)
+	}
+	if len(stuck) != 1 || stuck[0].PaymentHash != "hash-1" {
+		t.Fatalf("StuckOutboxEntries() = %+v, want only hash-1", stuck)
+	}
+	if len(stuck[0].AckedRelays) != 1 || stuck[0].LastError != "relay unavailable" {
+		t.Errorf("stuck entry = %+v, want saved attempt", stuck[0])
+	}
+
+	published := now
+	entry.PublishedAt = &published
+	if err := store.SaveOutboxAttempt(ctx, entry); err != nil {
+		t.Fatalf("SaveOutboxAttempt() error = %v", err)
+	}
+
+	stuck, err = store.StuckOutboxEntries(ctx, now.Add(time.Hour), 3)
+	if err != nil {
+		t.Fatalf("StuckOutboxEntries() error = %v", err)
+	}
+	if len(stuck) != 1 || stuck[0].PaymentHash != "hash-2" {
+		t.Fatalf("StuckOutboxEntries() = %+v, want only hash-2", stuck)
+	}
+}
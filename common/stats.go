@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxStatsBuckets caps the series so a minute interval over a long range
+// cannot produce an unbounded response.
+const maxStatsBuckets = 10000
+
+var statsIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+type StatsBucket struct {
+	Time   time.Time `json:"time"`
+	Sats   int64     `json:"sats"`
+	Boosts int       `json:"boosts"`
+}
+
+// BoostTotals totals the boosts and zaps matching a query. Senders counts
+// distinct sender names, leaving out anonymous boosts.
+type BoostTotals struct {
+	Sats    int64 `json:"sats"`
+	Boosts  int   `json:"boosts"`
+	Senders int   `json:"senders"`
+}
+
+// Stats adds a series of one bucket per interval from the first matching
+// boost to the last, including empty ones.
+type Stats struct {
+	BoostTotals
+	Interval string        `json:"interval"`
+	Series   []StatsBucket `json:"series"`
+}
+
+// fillStatsSeries adds the empty buckets between the non-empty ones read from
+// the store, which are keyed by their start in unix seconds.
+func fillStatsSeries(buckets map[int64]StatsBucket, first, last int64, interval time.Duration) ([]StatsBucket, error) {
+	series := []StatsBucket{}
+	if len(buckets) == 0 {
+		return series, nil
+	}
+
+	step := int64(interval / time.Second)
+	if count := (last-first)/step + 1; count > maxStatsBuckets {
+		return nil, fmt.Errorf("%w: %d buckets exceeds the maximum of %d, use a longer interval or a shorter range", ErrInvalidBoostQuery, count, maxStatsBuckets)
+	}
+
+	for start := first; start <= last; start += step {
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = StatsBucket{Time: time.Unix(start, 0).UTC()}
+		}
+		series = append(series, bucket)
+	}
+
+	return series, nil
+}
+
+func GetBoostTotals(ctx context.Context, cfg *Config, query map[string]string) (BoostTotals, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return BoostTotals{}, err
+	}
+
+	return store.GetBoostTotals(ctx, query)
+}
+
+func GetStats(ctx context.Context, cfg *Config, query map[string]string, interval time.Duration) (Stats, error) {
+	store, err := SharedStore(cfg)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return store.GetStats(ctx, query, interval)
+}
+
+// HandleStats totals the boosts matching the /api/boosts filters and buckets
+// them by interval, which is minute, hour (the default) or day.
+func HandleStats(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		name := r.FormValue("interval")
+		if name == "" {
+			name = "hour"
+		}
+
+		interval, ok := statsIntervals[name]
+		if !ok {
+			http.Error(w, "interval must be minute, hour or day", http.StatusBadRequest)
+			return
+		}
+
+		stats, err := GetStats(r.Context(), cfg, boostQuery(r), interval)
+		if errors.Is(err, ErrInvalidBoostQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		stats.Interval = name
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Printf("failed to encode stats: %v", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLStoreGetStats(t *testing.T) {
+	_, store := testSQLiteStore(t)
+	ctx := context.Background()
+
+	start := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	for i, invoice := range []IncomingInvoice{
+		testStoreInvoice("hash-1", float64(start.Add(10*time.Second).Unix()), Boostagram{Action: "boost", Podcast: "Show", SenderName: "Alice", ValueMsatTotal: 500000}),
+		testStoreInvoice("hash-2", float64(start.Add(50*time.Second).Unix()), Boostagram{Action: "zap", Podcast: "Show", SenderName: "Alice", ValueMsatTotal: 200000}),
+		testStoreInvoice("hash-3", float64(start.Add(3*time.Minute).Unix()), Boostagram{Action: "boost", Podcast: "Show", SenderName: "Bob"}),
+		testStoreInvoice("hash-4", float64(start.Add(3*time.Minute+5*time.Second).Unix()), Boostagram{Action: "boost", Podcast: "Show"}),
+		testStoreInvoice("hash-5", float64(start.Add(2*time.Minute).Unix()), Boostagram{Action: "stream", Podcast: "Show", SenderName: "Carol", ValueMsatTotal: 900000}),
+		testStoreInvoice("hash-6", float64(start.Add(2*time.Minute).Unix()), Boostagram{Action: "boost", Podcast: "Other", SenderName: "Dave", ValueMsatTotal: 900000}),
+	} {
+		if _, err := store.SaveInvoiceIfNew(ctx, invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew(%d) error = %v", i, err)
+		}
+	}
+
+	stats, err := store.GetStats(ctx, map[string]string{"q[podcast]": "Show"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+
+	if stats.Sats != 900 || stats.Boosts != 4 || stats.Senders != 2 {
+		t.Errorf("totals = %d sats, %d boosts, %d senders, want 900, 4 and 2", stats.Sats, stats.Boosts, stats.Senders)
+	}
+
+	want := []StatsBucket{
+		{Time: start, Sats: 700, Boosts: 2},
+		{Time: start.Add(time.Minute)},
+		{Time: start.Add(2 * time.Minute)},
+		{Time: start.Add(3 * time.Minute), Sats: 200, Boosts: 2},
+	}
+	if len(stats.Series) != len(want) {
+		t.Fatalf("Series = %+v, want %+v", stats.Series, want)
+	}
+	for i := range want {
+		if !stats.Series[i].Time.Equal(want[i].Time) || stats.Series[i].Sats != want[i].Sats || stats.Series[i].Boosts != want[i].Boosts {
+			t.Errorf("Series[%d] = %+v, want %+v", i, stats.Series[i], want[i])
+		}
+	}
+
+	stats, err = store.GetStats(ctx, map[string]string{"q[podcast]": "Nothing"}, time.Hour)
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.Boosts != 0 || stats.Series == nil || len(stats.Series) != 0 {
+		t.Errorf("stats = %+v, want no boosts and an empty series", stats)
+	}
+}
+
+func TestFillStatsSeriesLimit(t *testing.T) {
+	t.Parallel()
+
+	buckets := map[int64]StatsBucket{0: {}, maxStatsBuckets * 60: {}}
+	if _, err := fillStatsSeries(buckets, 0, maxStatsBuckets*60, time.Minute); err == nil {
+		t.Error("fillStatsSeries() error = nil, want too many buckets")
+	}
+	if series, err := fillStatsSeries(buckets, 0, maxStatsBuckets*60, time.Hour); err != nil || len(series) != maxStatsBuckets/60+1 {
+		t.Errorf("fillStatsSeries() = %d buckets, %v, want %d", len(series), err, maxStatsBuckets/60+1)
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	cfg := &Config{DatabaseURL: sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")}
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, hash := range []string{"hash-1", "hash-2", "hash-3"} {
+		invoice := testStoreInvoice(hash, float64(day.Add(time.Duration(i)*36*time.Hour).Unix()), Boostagram{Action: "boost", EventGuid: "event-1", SenderName: hash})
+		if _, err := store.SaveInvoiceIfNew(context.Background(), invoice); err != nil {
+			t.Fatalf("SaveInvoiceIfNew() error = %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	HandleStats(cfg)(rec, httptest.NewRequest(http.MethodGet, "/api/stats?eventGuid=event-1&interval=day", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var stats Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.Interval != "day" || stats.Boosts != 3 || stats.Senders != 3 || stats.Sats != 300 {
+		t.Errorf("stats = %+v, want three daily boosts", stats)
+	}
+	if len(stats.Series) != 4 || !stats.Series[0].Time.Equal(day) || stats.Series[2].Boosts != 0 {
+		t.Errorf("Series = %+v, want four days with the third empty", stats.Series)
+	}
+
+	for _, target := range []string{"/api/stats?interval=week", "/api/stats?direction=sideways"} {
+		rec := httptest.NewRecorder()
+		HandleStats(cfg)(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const sqlitePrefix = "sqlite://"
+
+// InvoiceStore is the storage the scoreboard runs on: invoices and the boost
+// queries, along with the nostr outbox, the payload archive and the schema.
+// SQLStore implements it for Postgres and SQLite.
+type InvoiceStore interface {
+	SaveInvoiceIfNew(ctx context.Context, invoice IncomingInvoice) (bool, error)
+	RestoreInvoice(ctx context.Context, invoice IncomingInvoice) (bool, error)
+	UpdateInvoiceRSSPayment(ctx context.Context, invoice IncomingInvoice) error
+	SetBoostHidden(ctx context.Context, identifier string, hidden bool) (bool, error)
+	GetBoosts(ctx context.Context, query map[string]string) ([]IncomingBoost, error)
+	GetLeaderboard(ctx context.Context, query map[string]string, sendersLimit, appsLimit int) (Leaderboard, error)
+	GetBoostTotals(ctx context.Context, query map[string]string) (BoostTotals, error)
+	GetStats(ctx context.Context, query map[string]string, interval time.Duration) (Stats, error)
+	LoadInvoices(ctx context.Context, filter InvoiceFilter) ([]IncomingInvoice, error)
+	ExistingPaymentHashes(ctx context.Context, hashes []string) (map[string]bool, error)
+
+	OutboxStore
+	PayloadStore
+	MigrationStore
+
+	Stats() PoolStats
+	Close() error
+}
+
+type OutboxStore interface {
+	GetOutboxEntry(ctx context.Context, paymentHash string) (OutboxEntry, error)
+	DueOutboxEntries(ctx context.Context, now time.Time, maxAttempts, limit int) ([]OutboxEntry, error)
+	StuckOutboxEntries(ctx context.Context, createdBefore time.Time, maxAttempts int) ([]OutboxEntry, error)
+	ClaimOutboxEntry(ctx context.Context, entry OutboxEntry, now, until time.Time) (bool, error)
+	SaveOutboxAttempt(ctx context.Context, entry OutboxEntry) error
+}
+
+type PayloadStore interface {
+	ArchivePayload(ctx context.Context, payload ArchivedPayload) (int64, error)
+	RecordPayloadOutcome(ctx context.Context, id int64, outcome PayloadOutcome) error
+	ListPayloads(ctx context.Context, filter PayloadFilter) ([]ArchivedPayload, error)
+}
+
+type MigrationStore interface {
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	MigrateUp(ctx context.Context, target int) ([]Migration, error)
+	MigrateDown(ctx context.Context, target int) ([]Migration, error)
+}
+
+var _ InvoiceStore = (*SQLStore)(nil)
+
+type dialect struct {
+	name   string
+	open   func(dsn string) (*sql.DB, error)
+	ilike  string
+	rebind func(query string) string
+	// floor is a format string rounding a non-negative number down.
+	floor string
+	// migrationsTable records which embedded migrations have been applied.
+	migrationsTable string
+}
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+var postgresDialect = dialect{
+	name:   "postgres",
+	open:   openPostgres,
+	ilike:  "ILIKE",
+	rebind: func(query string) string { return query },
+	floor:  "FLOOR(%s)",
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL
+)`,
+}
+
+// SQLite has no ILIKE, but its LIKE is already case-insensitive for ASCII.
+// FLOOR needs the optional math functions, but casting to an integer
+// truncates, which is the same for the non-negative timestamps it is used on.
+var sqliteDialect = dialect{
+	name:  "sqlite",
+	open:  openSQLite,
+	ilike: "LIKE",
+	rebind: func(query string) string {
+		return placeholderPattern.ReplaceAllString(query, "?$1")
+	},
+	floor: "CAST(%s AS INTEGER)",
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME NOT NULL
+)`,
+}
+
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+	timeout time.Duration
+	owned   bool
+}
+
+// OpenStore opens a new connection pool that the caller must close. Request
+// paths should use SharedStore instead.
+func OpenStore(cfg *Config) (InvoiceStore, error) {
+	store, err := openSQLStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func openSQLStore(cfg *Config) (*SQLStore, error) {
+	d := postgresDialect
+	dsn := cfg.DatabaseURL
+
+	if strings.HasPrefix(dsn, sqlitePrefix) {
+		d = sqliteDialect
+		dsn = sqliteDSN(sqlitePath(dsn))
+	}
+
+	db, err := d.open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.name == "postgres" {
+		if cfg.DatabaseMaxOpenConns > 0 {
+			db.SetMaxOpenConns(cfg.DatabaseMaxOpenConns)
+		}
+		if cfg.DatabaseMaxIdleConns > 0 {
+			db.SetMaxIdleConns(cfg.DatabaseMaxIdleConns)
+		}
+	}
+
+	store := &SQLStore{db: db, dialect: d, timeout: cfg.DatabaseQueryTimeout, owned: true}
+
+	ctx, cancel := store.withTimeout(context.Background())
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// openPostgres uses pgx for every Postgres query. The simple protocol keeps
+// it compatible with transaction-mode poolers such as PgBouncer.
+func openPostgres(dsn string) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+
+	return stdlib.OpenDB(*config), nil
+}
+
+func openSQLite(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection keeps in-memory databases alive and avoids
+	// SQLITE_BUSY errors from concurrent writers.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func sqlitePath(databaseURL string) string {
+	return strings.TrimPrefix(databaseURL, sqlitePrefix)
+}
+
+// sqliteDSN stores timestamps in SQLite's own text format so that they sort
+// and compare correctly in outbox queries.
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "_time_format=") {
+		return path
+	}
+	if strings.Contains(path, "?") {
+		return path + "&_time_format=sqlite"
+	}
+	return path + "?_time_format=sqlite"
+}
+
+func (s *SQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+func (s *SQLStore) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,360 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// boostStreamBuffer is how many boosts a stream may fall behind before it is
+// closed. The client reconnects with Last-Event-ID and catches up from the
+// database instead of slowing down ingestion.
+const boostStreamBuffer = 64
+
+var boostStreamKeepAlive = 30 * time.Second
+
+// Broker fans events such as newly saved invoices out to the open live feeds
+// in this process.
+type Broker[T any] struct {
+	mu          sync.Mutex
+	subscribers map[chan T]struct{}
+}
+
+func NewBroker[T any]() *Broker[T] {
+	return &Broker[T]{subscribers: map[chan T]struct{}{}}
+}
+
+var sharedBoostBroker = NewBroker[IncomingInvoice]()
+
+// Subscribe returns a channel of events, which is closed by the returned
+// func or when the subscriber falls too far behind.
+func (b *Broker[T]) Subscribe() (<-chan T, func()) {
+	ch := make(chan T, boostStreamBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Broker[T]) Publish(event T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// StreamPublisher feeds /api/boosts/stream. It only reaches streams served
+// by the same process, so it does nothing for serverless deployments.
+type StreamPublisher struct{}
+
+func (StreamPublisher) Publish(invoice IncomingInvoice) error {
+	sharedTotals.Invalidate()
+	sharedBoostBroker.Publish(invoice)
+	return nil
+}
+
+// boostMatcher applies the /api/boosts filters to an invoice in memory. It
+// mirrors SQLStore.boostFilters, except that q[since] is left to the replay
+// since every live invoice is newer.
+type boostMatcher struct {
+	direction    string
+	createdLt    *float64
+	createdGt    *float64
+	podcasts     []string
+	eventGuids   []string
+	episodeGuids []string
+	filtered     bool
+}
+
+func newBoostMatcher(query map[string]string) (boostMatcher, error) {
+	m := boostMatcher{}
+
+	switch direction := query["q[direction]"]; direction {
+	case "":
+		m.direction = DirectionIncoming
+	case DirectionIncoming, DirectionOutgoing, "all":
+		m.direction = direction
+	default:
+		return boostMatcher{}, fmt.Errorf("%w: unknown direction %q", ErrInvalidBoostQuery, direction)
+	}
+
+	for key, bound := range map[string]**float64{"q[created_at_lt]": &m.createdLt, "q[created_at_gt]": &m.createdGt} {
+		val, ok := query[key]
+		if !ok {
+			continue
+		}
+
+		num, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return boostMatcher{}, fmt.Errorf("%w: %s must be a unix timestamp", ErrInvalidBoostQuery, key)
+		}
+		*bound = &num
+	}
+
+	if val, ok := query["q[podcast]"]; ok {
+		m.filtered = true
+		m.podcasts = strings.Split(strings.ToLower(val), ",")
+	}
+
+	if val, ok := query["q[eventGuid]"]; ok {
+		m.eventGuids = nonEmpty(strings.Split(val, ","))
+		m.filtered = m.filtered || len(m.eventGuids) > 0
+	}
+
+	if val, ok := query["q[episodeGuid]"]; ok {
+		m.episodeGuids = nonEmpty(strings.Split(val, ","))
+		m.filtered = m.filtered || len(m.episodeGuids) > 0
+	}
+
+	return m, nil
+}
+
+func nonEmpty(values []string) []string {
+	out := []string{}
+	for _, val := range values {
+		if val != "" {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+func (m boostMatcher) Match(invoice IncomingInvoice) bool {
+	if m.direction != "all" && invoice.GetDirection() != m.direction {
+		return false
+	}
+
+	if m.createdLt != nil && invoice.CreationDate > *m.createdLt {
+		return false
+	}
+
+	if m.createdGt != nil && invoice.CreationDate < *m.createdGt {
+		return false
+	}
+
+	if !m.filtered {
+		return true
+	}
+
+	boostagram := invoice.GetBoostagram()
+	podcast := strings.ToLower(boostagram.Podcast)
+
+	for _, val := range m.podcasts {
+		if (val == "" && podcast == "") || (val != "" && strings.Contains(podcast, val)) {
+			return true
+		}
+	}
+
+	for _, val := range m.eventGuids {
+		if boostagram.EventGuid == val {
+			return true
+		}
+	}
+
+	for _, val := range m.episodeGuids {
+		if boostagram.EpisodeGuid == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamBoost is the /api/boosts representation of a newly saved invoice.
+func streamBoost(invoice IncomingInvoice) (IncomingBoost, error) {
+	boost := IncomingBoost{
+		Amount:       invoice.Amount,
+		CreatedAt:    invoice.CreatedAt,
+		CreationDate: invoice.CreationDate,
+		Identifier:   invoice.Identifier,
+		Value:        invoice.Value,
+		Direction:    invoice.GetDirection(),
+	}
+
+	metadata, err := invoice.GetSerializedMetadata()
+	if err != nil {
+		return IncomingBoost{}, err
+	}
+	if metadata != nil {
+		boost.Boostagram = json.RawMessage(metadata)
+	}
+
+	return boost, nil
+}
+
+// boostStream writes boosts as server-sent events. Each event id is the
+// /api/boosts cursor of the newest boost sent so far, so resuming from it
+// never repeats a boost even when they arrive out of order.
+type boostStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	newest  BoostCursor
+}
+
+func (s *boostStream) send(boost IncomingBoost) error {
+	position := BoostCursor{CreationDate: boost.CreationDate, Identifier: boost.Identifier}
+	if s.newest.Identifier == "" || position.CreationDate > s.newest.CreationDate ||
+		(position.CreationDate == s.newest.CreationDate && position.Identifier > s.newest.Identifier) {
+		s.newest = position
+	}
+
+	data, err := json.Marshal(boost)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(s.w, "id: %s\ndata: %s\n\n", s.newest.Encode(), data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
+
+// replay sends the stored boosts after the cursor, oldest first, and returns
+// their identifiers.
+func (s *boostStream) replay(r *http.Request, cfg *Config, query map[string]string, cursor BoostCursor) (map[string]bool, error) {
+	sent := map[string]bool{}
+
+	for {
+		page := map[string]string{"items": strconv.Itoa(maxBoostPageSize)}
+		for key, val := range query {
+			page[key] = val
+		}
+		cursor.Before = true
+		page["cursor"] = cursor.Encode()
+
+		boosts, err := GetBoosts(r.Context(), cfg, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(boosts) - 1; i >= 0; i-- {
+			if err := s.send(boosts[i]); err != nil {
+				return nil, err
+			}
+			sent[boosts[i].Identifier] = true
+		}
+
+		if len(boosts) < maxBoostPageSize {
+			return sent, nil
+		}
+		cursor = BoostCursor{CreationDate: boosts[0].CreationDate, Identifier: boosts[0].Identifier}
+	}
+}
+
+// HandleBoostStream pushes boosts matching the /api/boosts filters as they
+// are saved. A reconnecting client's Last-Event-ID first replays the boosts
+// stored after that event. The stream is fed by the ingestion pipeline in the
+// same process, so it needs the long-running serve command.
+func HandleBoostStream(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		query := boostQuery(r)
+
+		matcher, err := newBoostMatcher(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resume *BoostCursor
+		if val := r.Header.Get("Last-Event-ID"); val != "" {
+			cursor, err := DecodeBoostCursor(val)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resume = &cursor
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribe before replaying so nothing saved in between is lost.
+		invoices, unsubscribe := sharedBoostBroker.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		stream := &boostStream{w: w, flusher: flusher}
+
+		replayed := map[string]bool{}
+		if resume != nil {
+			stream.newest = *resume
+			replayed, err = stream.replay(r, cfg, query, *resume)
+			if err != nil {
+				log.Printf("failed to replay boost stream: %v", err)
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(boostStreamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case invoice, ok := <-invoices:
+				if !ok {
+					// Fell behind; the client resumes from its last event.
+					return
+				}
+
+				if !matcher.Match(invoice) || replayed[invoice.Identifier] {
+					continue
+				}
+
+				boost, err := streamBoost(invoice)
+				if err != nil {
+					log.Printf("failed to stream %s: %v", invoice.PaymentHash, err)
+					continue
+				}
+
+				if err := stream.send(boost); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamEvent struct {
+	ID    string
+	Boost IncomingBoost
+}
+
+// readStream decodes events from an /api/boosts/stream response, skipping
+// keep-alive comments.
+func readStream(t *testing.T, res *http.Response) <-chan streamEvent {
+	t.Helper()
+
+	events := make(chan streamEvent, 16)
+	go func() {
+		defer close(events)
+
+		scanner := bufio.NewScanner(res.Body)
+		var event streamEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Boost); err != nil {
+					t.Errorf("failed to decode event: %v", err)
+				}
+			case line == "" && event.ID != "":
+				events <- event
+				event = streamEvent{}
+			}
+		}
+	}()
+
+	return events
+}
+
+func nextStreamEvent(t *testing.T, events <-chan streamEvent) streamEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed, want an event")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a stream event")
+	}
+	return streamEvent{}
+}
+
+func openBoostStream(t *testing.T, url, lastEventID string) <-chan streamEvent {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s = %d %s, want an event stream", url, res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	return readStream(t, res)
+}
+
+// testLiveConfig returns a config whose pipelines store to a fresh database
+// and publish to stub relays, for tests of the live boost feeds.
+func testLiveConfig(t *testing.T) *Config {
+	t.Helper()
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	return cfg
+}
+
+// testIngestBoost runs a boost through the pipeline, which feeds the live
+// boost feeds.
+func testIngestBoost(t *testing.T, cfg *Config, hash string, creationDate float64, boostagram Boostagram) {
+	t.Helper()
+
+	invoice := testStoreInvoice(hash, creationDate, boostagram)
+	invoice.Type = "incoming"
+	payload, err := json.Marshal(invoice)
+	if err != nil {
+		t.Fatalf("failed to marshal invoice: %v", err)
+	}
+
+	pipeline := NewPipeline(cfg, AlbySource{})
+	pipeline.Enrichers = nil
+	if _, err := pipeline.Process(payload); err != nil {
+		t.Fatalf("Process(%s) error = %v", hash, err)
+	}
+}
+
+func TestHandleBoostStream(t *testing.T) {
+	cfg := testLiveConfig(t)
+
+	// Cleanups run last first, so the streams are cancelled before Close
+	// waits for their handlers to return.
+	srv := httptest.NewServer(HandleBoostStream(cfg))
+	t.Cleanup(srv.Close)
+
+	// Subscriptions are registered before the headers are sent, so the
+	// stream is live once it opens.
+	events := openBoostStream(t, srv.URL+"?podcast=stream+show", "")
+
+	ingest := func(hash string, creationDate float64, podcast string) {
+		t.Helper()
+		testIngestBoost(t, cfg, hash, creationDate, Boostagram{Action: "boost", Podcast: podcast})
+	}
+
+	ingest("hash-1", 1700000002, "Stream Show")
+	ingest("hash-2", 1700000003, "Other Show")
+	ingest("hash-3", 1700000001, "Stream Show")
+
+	first := nextStreamEvent(t, events)
+	if first.Boost.Identifier != "id-hash-1" {
+		t.Fatalf("first event = %+v, want hash-1", first.Boost)
+	}
+
+	second := nextStreamEvent(t, events)
+	if second.Boost.Identifier != "id-hash-3" {
+		t.Fatalf("second event = %+v, want hash-3 with other shows filtered out", second.Boost)
+	}
+	if second.ID != first.ID {
+		t.Errorf("id after an older boost = %q, want it to stay at %q", second.ID, first.ID)
+	}
+
+	ingest("hash-4", 1700000004, "Stream Show")
+	ingest("hash-5", 1700000005, "Stream Show")
+
+	// Resuming after hash-1 replays the newer stored boosts, oldest first.
+	resumed := openBoostStream(t, srv.URL+"?podcast=stream+show", first.ID)
+	for _, want := range []string{"id-hash-4", "id-hash-5"} {
+		if event := nextStreamEvent(t, resumed); event.Boost.Identifier != want {
+			t.Fatalf("replayed %+v, want %s", event.Boost, want)
+		}
+	}
+
+	ingest("hash-6", 1700000006, "Stream Show")
+	if event := nextStreamEvent(t, resumed); event.Boost.Identifier != "id-hash-6" {
+		t.Errorf("live event after replay = %+v, want hash-6", event.Boost)
+	}
+
+	res, err := http.Get(srv.URL + "?direction=sideways")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown direction status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker[IncomingInvoice]()
+	invoices, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= boostStreamBuffer; i++ {
+		broker.Publish(IncomingInvoice{})
+	}
+
+	received := 0
+	for range invoices {
+		received++
+	}
+	if received != boostStreamBuffer {
+		t.Errorf("received %d invoices before the channel closed, want %d", received, boostStreamBuffer)
+	}
+}
+
+func TestBoostMatcher(t *testing.T) {
+	t.Parallel()
+
+	invoice := testStoreInvoice("hash-1", 1700000000, Boostagram{Podcast: "The Show", EventGuid: "event-1"})
+
+	for _, tt := range []struct {
+		query map[string]string
+		want  bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"q[direction]": DirectionOutgoing}, false},
+		{map[string]string{"q[direction]": "all"}, true},
+		{map[string]string{"q[podcast]": "show"}, true},
+		{map[string]string{"q[podcast]": "other,"}, false},
+		{map[string]string{"q[podcast]": "other", "q[eventGuid]": "event-1"}, true},
+		{map[string]string{"q[eventGuid]": ""}, true},
+		{map[string]string{"q[episodeGuid]": "episode-1"}, false},
+		{map[string]string{"q[created_at_gt]": "1700000001"}, false},
+		{map[string]string{"q[created_at_lt]": "1700000000"}, true},
+	} {
+		matcher, err := newBoostMatcher(tt.query)
+		if err != nil {
+			t.Fatalf("newBoostMatcher(%v) error = %v", tt.query, err)
+		}
+		if got := matcher.Match(invoice); got != tt.want {
+			t.Errorf("Match() with %v = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := newBoostMatcher(map[string]string{"q[created_at_lt]": "yesterday"}); err == nil {
+		t.Error("newBoostMatcher() with a bad timestamp error = nil, want error")
+	}
+}
@@ -28,6 +28,7 @@ type IncomingInvoice struct {
 	CreatedAt    string           `json:"created_at"`
 	CreationDate float64          `json:"creation_date"`
 	Description  string           `json:"description"` // invoice description
+	Direction    string           `json:"direction"`   // incoming or outgoing, empty means incoming
 	Identifier   string           `json:"identifier"`
 	Metadata     *InvoiceMetadata `json:"metadata"`
 	PayerName    string           `json:"payer_name"` // lnurl payer name
@@ -0,0 +1,307 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+var (
+	wsHeartbeat    = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+
+	// wsTotalsDelay is how long a connection waits after a boost before
+	// sending totals, so a burst of boosts costs one update.
+	wsTotalsDelay = time.Second
+)
+
+var errWSBehind = errors.New("client fell behind")
+
+// wsMessage is sent in both directions on /api/ws. Clients send "filter"
+// with the /api/boosts filter parameters, each a string or a list of
+// strings. The server sends "boost", "moderation", "totals" and "error".
+type wsMessage struct {
+	Type       string                     `json:"type"`
+	Filter     map[string]json.RawMessage `json:"filter,omitempty"`
+	Boost      *IncomingBoost             `json:"boost,omitempty"`
+	Moderation *ModerationChange          `json:"moderation,omitempty"`
+	Totals     *BoostTotals               `json:"totals,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// wsFilterQuery turns a filter message into the query used by GetBoosts.
+func wsFilterQuery(filter map[string]json.RawMessage) (map[string]string, error) {
+	form := url.Values{}
+	for key, raw := range filter {
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			var val string
+			if err := json.Unmarshal(raw, &val); err != nil {
+				return nil, fmt.Errorf("%w: %s must be a string or a list of strings", ErrInvalidBoostQuery, key)
+			}
+			values = []string{val}
+		}
+		form[key] = values
+	}
+
+	return boostFormQuery(form), nil
+}
+
+// totalsCache shares boost totals between connections with the same filters,
+// so a boost costs one query per distinct filter rather than one per
+// connection. Every saved boost invalidates it.
+type totalsCache struct {
+	mu      sync.Mutex
+	entries map[string]*totalsEntry
+}
+
+type totalsEntry struct {
+	ready  chan struct{}
+	totals BoostTotals
+	err    error
+}
+
+var sharedTotals = &totalsCache{entries: map[string]*totalsEntry{}}
+
+func (c *totalsCache) Get(ctx context.Context, cfg *Config, query map[string]string) (BoostTotals, error) {
+	form := url.Values{}
+	for key, val := range query {
+		form.Set(key, val)
+	}
+	key := form.Encode()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &totalsEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		// Other connections wait on this query, so it must outlive the
+		// connection that started it.
+		entry.totals, entry.err = GetBoostTotals(context.WithoutCancel(ctx), cfg, query)
+		if entry.err != nil {
+			c.mu.Lock()
+			if c.entries[key] == entry {
+				delete(c.entries, key)
+			}
+			c.mu.Unlock()
+		}
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.totals, entry.err
+	case <-ctx.Done():
+		return BoostTotals{}, ctx.Err()
+	}
+}
+
+// Invalidate drops every cached total. Queries already running finish for
+// the connections waiting on them, but later callers query again.
+func (c *totalsCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+// wsFeed is one /api/ws connection. Only its run loop writes to conn.
+type wsFeed struct {
+	cfg     *Config
+	conn    *websocket.Conn
+	query   map[string]string
+	matcher boostMatcher
+}
+
+func (f *wsFeed) write(ctx context.Context, msg wsMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+
+	return wsjson.Write(ctx, f.conn, msg)
+}
+
+func (f *wsFeed) sendTotals(ctx context.Context) error {
+	totals, err := sharedTotals.Get(ctx, f.cfg, f.query)
+	if err != nil {
+		log.Printf("failed to total boosts for websocket: %v", err)
+		return f.write(ctx, wsMessage{Type: "error", Error: "failed to load totals"})
+	}
+
+	return f.write(ctx, wsMessage{Type: "totals", Totals: &totals})
+}
+
+// setFilter replaces the connection's filters, keeping the old ones if the
+// new ones are invalid.
+func (f *wsFeed) setFilter(ctx context.Context, filter map[string]json.RawMessage) error {
+	query, err := wsFilterQuery(filter)
+	if err == nil {
+		var matcher boostMatcher
+		if matcher, err = newBoostMatcher(query); err == nil {
+			f.query, f.matcher = query, matcher
+			return f.sendTotals(ctx)
+		}
+	}
+
+	return f.write(ctx, wsMessage{Type: "error", Error: err.Error()})
+}
+
+// readFilters forwards the client's filter messages until the connection
+// fails. It must never block, since heartbeat pongs are only handled while it
+// reads, so a filter still waiting to be applied is replaced by a newer one.
+func (f *wsFeed) readFilters(ctx context.Context, filters chan map[string]json.RawMessage) error {
+	for {
+		var msg wsMessage
+		if err := wsjson.Read(ctx, f.conn, &msg); err != nil {
+			return err
+		}
+
+		if msg.Type != "filter" {
+			continue
+		}
+
+		select {
+		case <-filters:
+		default:
+		}
+		filters <- msg.Filter
+	}
+}
+
+func (f *wsFeed) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	invoices, unsubscribe := sharedBoostBroker.Subscribe()
+	defer unsubscribe()
+
+	changes, unsubscribeChanges := sharedModerationBroker.Subscribe()
+	defer unsubscribeChanges()
+
+	filters := make(chan map[string]json.RawMessage, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- f.readFilters(ctx, filters)
+	}()
+
+	if err := f.sendTotals(ctx); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(wsHeartbeat)
+	defer heartbeat.Stop()
+
+	// totalsDue is set while an update of the totals is waiting out
+	// wsTotalsDelay.
+	var totalsDue <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case <-heartbeat.C:
+			pingCtx, cancelPing := context.WithTimeout(ctx, wsWriteTimeout)
+			err := f.conn.Ping(pingCtx)
+			cancelPing()
+			if err != nil {
+				return fmt.Errorf("heartbeat failed: %w", err)
+			}
+		case filter := <-filters:
+			if err := f.setFilter(ctx, filter); err != nil {
+				return err
+			}
+		case <-totalsDue:
+			totalsDue = nil
+			if err := f.sendTotals(ctx); err != nil {
+				return err
+			}
+		case change, ok := <-changes:
+			if !ok {
+				f.conn.Close(websocket.StatusTryAgainLater, errWSBehind.Error())
+				return errWSBehind
+			}
+
+			// Changes are sent whatever the filters, since a client only
+			// acts on boosts it has.
+			if err := f.write(ctx, wsMessage{Type: "moderation", Moderation: &change}); err != nil {
+				return err
+			}
+
+			if totalsDue == nil {
+				totalsDue = time.After(wsTotalsDelay)
+			}
+		case invoice, ok := <-invoices:
+			if !ok {
+				f.conn.Close(websocket.StatusTryAgainLater, errWSBehind.Error())
+				return errWSBehind
+			}
+
+			if !f.matcher.Match(invoice) {
+				continue
+			}
+
+			boost, err := streamBoost(invoice)
+			if err != nil {
+				log.Printf("failed to send %s over websocket: %v", invoice.PaymentHash, err)
+				continue
+			}
+
+			if err := f.write(ctx, wsMessage{Type: "boost", Boost: &boost}); err != nil {
+				return err
+			}
+
+			if totalsDue == nil {
+				totalsDue = time.After(wsTotalsDelay)
+			}
+		}
+	}
+}
+
+// HandleWebSocket pushes boosts matching the connection's filters as they
+// are saved and boosts being hidden or restored, followed by the updated
+// totals once a burst of changes settles. The filters start from the
+// /api/boosts query parameters and are replaced by each filter message. A
+// client that stops reading is disconnected once the broker's buffer fills
+// and should reconnect and reload from /api/boosts. Like the boost stream
+// it needs the long-running serve command.
+func HandleWebSocket(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		query := boostQuery(r)
+
+		matcher, err := newBoostMatcher(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Boards are served from other origins, as with the other endpoints.
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: []string{"*"}})
+		if err != nil {
+			log.Printf("failed to accept websocket: %v", err)
+			return
+		}
+		defer conn.CloseNow()
+
+		feed := &wsFeed{cfg: cfg, conn: conn, query: query, matcher: matcher}
+		feed.run(r.Context())
+	}
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+func readWSMessage(t *testing.T, ctx context.Context, conn *websocket.Conn, want string) wsMessage {
+	t.Helper()
+
+	var msg wsMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatalf("Read() error = %v, want a %s message", err, want)
+	}
+	if msg.Type != want {
+		t.Fatalf("message = %+v, want type %s", msg, want)
+	}
+	return msg
+}
+
+func TestHandleWebSocket(t *testing.T) {
+	cfg := testLiveConfig(t)
+
+	heartbeat, totalsDelay := wsHeartbeat, wsTotalsDelay
+	wsHeartbeat, wsTotalsDelay = 20*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { wsHeartbeat, wsTotalsDelay = heartbeat, totalsDelay })
+
+	srv := httptest.NewServer(HandleWebSocket(cfg))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"?podcast=ws+show", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.CloseNow()
+
+	if msg := readWSMessage(t, ctx, conn, "totals"); msg.Totals.Boosts != 0 {
+		t.Errorf("initial totals = %+v, want none", msg.Totals)
+	}
+
+	// Outlive a few heartbeats; the client answers pings while reading.
+	time.Sleep(100 * time.Millisecond)
+
+	testIngestBoost(t, cfg, "hash-1", 1700000001, Boostagram{Action: "boost", Podcast: "Other Show", EventGuid: "event-2"})
+	testIngestBoost(t, cfg, "hash-2", 1700000002, Boostagram{Action: "boost", Podcast: "WS Show", SenderName: "Alice"})
+
+	if msg := readWSMessage(t, ctx, conn, "boost"); msg.Boost.Identifier != "id-hash-2" {
+		t.Fatalf("boost = %+v, want hash-2 with other shows filtered out", msg.Boost)
+	}
+	if msg := readWSMessage(t, ctx, conn, "totals"); msg.Totals.Boosts != 1 || msg.Totals.Sats != 100 || msg.Totals.Senders != 1 {
+		t.Errorf("totals = %+v, want the one boost", msg.Totals)
+	}
+
+	if err := wsjson.Write(ctx, conn, map[string]any{"type": "filter", "filter": map[string]any{"direction": "sideways"}}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if msg := readWSMessage(t, ctx, conn, "error"); !strings.Contains(msg.Error, "sideways") {
+		t.Errorf("error = %q, want it to name the direction", msg.Error)
+	}
+
+	if err := wsjson.Write(ctx, conn, map[string]any{"type": "filter", "filter": map[string]any{"podcast": []string{"ws show", "other show"}, "eventGuid": "event-3"}}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if msg := readWSMessage(t, ctx, conn, "totals"); msg.Totals.Boosts != 2 {
+		t.Errorf("totals after the filter change = %+v, want both shows", msg.Totals)
+	}
+
+	testIngestBoost(t, cfg, "hash-3", 1700000003, Boostagram{Action: "boost", EventGuid: "event-3"})
+	testIngestBoost(t, cfg, "hash-4", 1700000004, Boostagram{Action: "boost", EventGuid: "event-3"})
+	if msg := readWSMessage(t, ctx, conn, "boost"); msg.Boost.Identifier != "id-hash-3" {
+		t.Errorf("boost = %+v, want hash-3 from the new event", msg.Boost)
+	}
+	if msg := readWSMessage(t, ctx, conn, "boost"); msg.Boost.Identifier != "id-hash-4" {
+		t.Errorf("boost = %+v, want hash-4 before the totals", msg.Boost)
+	}
+	if msg := readWSMessage(t, ctx, conn, "totals"); msg.Totals.Boosts != 4 {
+		t.Errorf("totals = %+v, want one update covering both new boosts", msg.Totals)
+	}
+
+	if err := SetBoostHidden(ctx, cfg, ModerationChange{Identifier: "id-hash-3", Hidden: true}); err != nil {
+		t.Fatalf("SetBoostHidden() error = %v", err)
+	}
+	if msg := readWSMessage(t, ctx, conn, "moderation"); msg.Moderation.Identifier != "id-hash-3" || !msg.Moderation.Hidden {
+		t.Errorf("moderation = %+v, want hash-3 hidden", msg.Moderation)
+	}
+	if msg := readWSMessage(t, ctx, conn, "totals"); msg.Totals.Boosts != 3 {
+		t.Errorf("totals = %+v, want the hidden boost left out", msg.Totals)
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func TestHandleWebSocketDisconnectsSlowClients(t *testing.T) {
+	cfg := testLiveConfig(t)
+
+	srv := httptest.NewServer(HandleWebSocket(cfg))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.CloseNow()
+
+	// The server blocks writing to a client that is not reading, so the
+	// published invoices back up until the broker gives up on it. Large
+	// messages fill the socket buffers quickly.
+	message := strings.Repeat("x", 16*1024)
+	for i := 0; i < 16*boostStreamBuffer; i++ {
+		sharedBoostBroker.Publish(testStoreInvoice("hash", 1700000000, Boostagram{Action: "boost", Message: message}))
+	}
+
+	for {
+		var msg wsMessage
+		err := wsjson.Read(ctx, conn, &msg)
+		if err == nil {
+			continue
+		}
+		if status := websocket.CloseStatus(err); status != websocket.StatusTryAgainLater {
+			t.Fatalf("Read() error = %v, want a try again later close", err)
+		}
+		return
+	}
+}
+
+func TestTotalsCache(t *testing.T) {
+	cfg := testLiveConfig(t)
+	cache := &totalsCache{entries: map[string]*totalsEntry{}}
+	ctx := context.Background()
+	query := map[string]string{"q[podcast]": "Cached Show"}
+
+	testIngestBoost(t, cfg, "hash-1", 1700000001, Boostagram{Action: "boost", Podcast: "Cached Show"})
+	if totals, err := cache.Get(ctx, cfg, query); err != nil || totals.Boosts != 1 {
+		t.Fatalf("Get() = %+v, %v, want one boost", totals, err)
+	}
+
+	// Only the cache's own invalidation makes it query again.
+	testIngestBoost(t, cfg, "hash-2", 1700000002, Boostagram{Action: "boost", Podcast: "Cached Show"})
+	if totals, err := cache.Get(ctx, cfg, query); err != nil || totals.Boosts != 1 {
+		t.Errorf("Get() = %+v, %v, want the cached total", totals, err)
+	}
+
+	cache.Invalidate()
+	if totals, err := cache.Get(ctx, cfg, query); err != nil || totals.Boosts != 2 {
+		t.Errorf("Get() after Invalidate = %+v, %v, want both boosts", totals, err)
+	}
+}
+
+func TestWSFilterQuery(t *testing.T) {
+	t.Parallel()
+
+	query, err := wsFilterQuery(map[string]json.RawMessage{
+		"podcast":   json.RawMessage(`["One", "Two"]`),
+		"eventGuid": json.RawMessage(`"event-1"`),
+		"since":     json.RawMessage(`""`),
+	})
+	if err != nil {
+		t.Fatalf("wsFilterQuery() error = %v", err)
+	}
+	if len(query) != 2 || query["q[podcast]"] != "One,Two" || query["q[eventGuid]"] != "event-1" {
+		t.Errorf("query = %v, want the podcasts joined and the event guid", query)
+	}
+
+	if _, err := wsFilterQuery(map[string]json.RawMessage{"podcast": json.RawMessage(`1`)}); !errors.Is(err, ErrInvalidBoostQuery) {
+		t.Errorf("wsFilterQuery() with a number error = %v, want ErrInvalidBoostQuery", err)
+	}
+}
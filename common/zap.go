@@ -0,0 +1,435 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// ZapTargets are the events and addressable events (such as kind 30311 live
+// streams) whose zap receipts are ingested.
+type ZapTargets struct {
+	Addresses []string
+	EventIDs  []string
+}
+
+// ParseZapTargets accepts naddr, nevent and note codes as well as raw
+// "kind:pubkey:identifier" addresses and hex event ids.
+func ParseZapTargets(values []string) (ZapTargets, error) {
+	targets := ZapTargets{}
+
+	for _, val := range values {
+		switch {
+		case strings.HasPrefix(val, "naddr1"):
+			_, data, err := nip19.Decode(val)
+			if err != nil {
+				return ZapTargets{}, fmt.Errorf("invalid naddr %q: %w", val, err)
+			}
+			pointer := data.(nostr.EntityPointer)
+			targets.Addresses = append(targets.Addresses, pointer.AsTagReference())
+		case strings.HasPrefix(val, "nevent1"):
+			_, data, err := nip19.Decode(val)
+			if err != nil {
+				return ZapTargets{}, fmt.Errorf("invalid nevent %q: %w", val, err)
+			}
+			targets.EventIDs = append(targets.EventIDs, data.(nostr.EventPointer).ID)
+		case strings.HasPrefix(val, "note1"):
+			_, data, err := nip19.Decode(val)
+			if err != nil {
+				return ZapTargets{}, fmt.Errorf("invalid note %q: %w", val, err)
+			}
+			targets.EventIDs = append(targets.EventIDs, data.(string))
+		case strings.Count(val, ":") >= 2:
+			parts := strings.SplitN(val, ":", 3)
+			if _, err := strconv.Atoi(parts[0]); err != nil || !nostr.IsValid32ByteHex(parts[1]) {
+				return ZapTargets{}, fmt.Errorf("invalid address %q", val)
+			}
+			targets.Addresses = append(targets.Addresses, val)
+		case nostr.IsValid32ByteHex(val):
+			targets.EventIDs = append(targets.EventIDs, val)
+		default:
+			return ZapTargets{}, fmt.Errorf("unrecognised zap target %q", val)
+		}
+	}
+
+	return targets, nil
+}
+
+// Filters returns one filter per tag type, since a single filter with both
+// #a and #e would only match receipts that reference both.
+func (t ZapTargets) Filters(since time.Time) nostr.Filters {
+	ts := nostr.Timestamp(since.Unix())
+	filters := nostr.Filters{}
+
+	if len(t.Addresses) > 0 {
+		filters = append(filters, nostr.Filter{Kinds: []int{nostr.KindZap}, Tags: nostr.TagMap{"a": t.Addresses}, Since: &ts})
+	}
+	if len(t.EventIDs) > 0 {
+		filters = append(filters, nostr.Filter{Kinds: []int{nostr.KindZap}, Tags: nostr.TagMap{"e": t.EventIDs}, Since: &ts})
+	}
+
+	return filters
+}
+
+// ZapReceipt is a zap receipt that passed NIP-57 validation.
+type ZapReceipt struct {
+	Event   nostr.Event
+	Request nostr.Event
+	Bolt11  Bolt11Invoice
+}
+
+// ZapSource accepts receipts signed by one of Zappers, the nostrPubkey of
+// the LNURL servers paid for the zaps.
+type ZapSource struct {
+	Zappers []string
+}
+
+func (ZapSource) Name() string {
+	return "zap"
+}
+
+func (s ZapSource) Parse(payload []byte) (IncomingInvoice, error) {
+	var ev nostr.Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return IncomingInvoice{}, fmt.Errorf("failed to unmarshal zap receipt: %w", err)
+	}
+
+	receipt, err := ValidateZapReceipt(ev, s.Zappers)
+	if err != nil {
+		return IncomingInvoice{}, err
+	}
+
+	return receipt.Invoice(), nil
+}
+
+// ValidateZapReceipt checks a kind 9735 event against NIP-57 appendix F: it
+// must be signed by one of the recipient's zappers, the embedded zap request
+// must be a signed kind 9734 event for the same recipient, and the bolt11
+// must commit to it and match its amount.
+func ValidateZapReceipt(ev nostr.Event, zappers []string) (ZapReceipt, error) {
+	if ev.Kind != nostr.KindZap {
+		return ZapReceipt{}, ErrIgnoredPayload
+	}
+
+	if ok, err := ev.CheckSignature(); !ok {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s has an invalid signature: %v", ev.ID, err)
+	}
+
+	// Anyone can sign a receipt; only the LNURL server knows it was paid.
+	if !slices.Contains(zappers, ev.PubKey) {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s is signed by %s, which is not a trusted zapper", ev.ID, ev.PubKey)
+	}
+
+	bolt11 := ev.Tags.Find("bolt11")
+	description := ev.Tags.Find("description")
+	if len(bolt11) < 2 || len(description) < 2 {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s is missing its bolt11 or description tag", ev.ID)
+	}
+
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(description[1]), &request); err != nil {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s has an invalid zap request: %w", ev.ID, err)
+	}
+
+	if request.Kind != nostr.KindZapRequest {
+		return ZapReceipt{}, fmt.Errorf("zap request in %s has kind %d", ev.ID, request.Kind)
+	}
+
+	if ok, err := request.CheckSignature(); !ok {
+		return ZapReceipt{}, fmt.Errorf("zap request in %s has an invalid signature: %v", ev.ID, err)
+	}
+
+	recipients := 0
+	for range request.Tags.FindAll("p") {
+		recipients++
+	}
+	if recipients != 1 {
+		return ZapReceipt{}, fmt.Errorf("zap request in %s must have exactly one p tag", ev.ID)
+	}
+
+	if recipient := request.Tags.Find("p")[1]; ev.Tags.FindWithValue("p", recipient) == nil {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s is not for the requested recipient", ev.ID)
+	}
+
+	for _, key := range []string{"e", "a"} {
+		if tag := request.Tags.Find(key); tag != nil && ev.Tags.FindWithValue(key, tag[1]) == nil {
+			return ZapReceipt{}, fmt.Errorf("zap receipt %s does not reference the requested %s tag", ev.ID, key)
+		}
+	}
+
+	invoice, err := DecodeBolt11(bolt11[1])
+	if err != nil {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s: %w", ev.ID, err)
+	}
+
+	if invoice.AmountMsat <= 0 {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s has no amount in its bolt11", ev.ID)
+	}
+
+	if amount := request.Tags.Find("amount"); amount != nil {
+		requested, err := strconv.ParseInt(amount[1], 10, 64)
+		if err != nil || requested != invoice.AmountMsat {
+			return ZapReceipt{}, fmt.Errorf("zap receipt %s pays %d msat but %s was requested", ev.ID, invoice.AmountMsat, amount[1])
+		}
+	}
+
+	hash := sha256.Sum256([]byte(description[1]))
+	if invoice.DescriptionHash != hex.EncodeToString(hash[:]) {
+		return ZapReceipt{}, fmt.Errorf("zap receipt %s bolt11 does not commit to its zap request", ev.ID)
+	}
+
+	return ZapReceipt{Event: ev, Request: request, Bolt11: invoice}, nil
+}
+
+// Invoice stores the zap under the receipt's event id. The payment hash comes
+// from the bolt11 so that a zap also seen by a wallet webhook is only counted
+// once.
+func (z ZapReceipt) Invoice() IncomingInvoice {
+	sats := float64(z.Bolt11.AmountMsat / 1000)
+	created := time.Unix(int64(z.Event.CreatedAt), 0)
+
+	paymentHash := z.Bolt11.PaymentHash
+	if paymentHash == "" {
+		paymentHash = "zap-" + z.Event.ID
+	}
+
+	sender, err := nip19.EncodePublicKey(z.Request.PubKey)
+	if err != nil {
+		sender = z.Request.PubKey
+	}
+
+	message := z.Request.Content
+	if message == "" {
+		message = z.Event.Content
+	}
+
+	target := ""
+	if tag := z.Event.Tags.Find("a"); tag != nil {
+		target = tag[1]
+	} else if tag := z.Event.Tags.Find("e"); tag != nil {
+		target = tag[1]
+	}
+
+	return IncomingInvoice{
+		Amount: sats,
+		Value:  sats,
+		Boostagram: &Boostagram{
+			Action:         "zap",
+			Podcast:        "Nostr",
+			AppName:        "Nostr",
+			SenderName:     sender,
+			Message:        message,
+			ValueMsatTotal: int(z.Bolt11.AmountMsat),
+		},
+		Comment:      message,
+		Description:  target,
+		PayerName:    sender,
+		PaymentHash:  paymentHash,
+		Identifier:   z.Event.ID,
+		Type:         "incoming",
+		CreationDate: float64(created.Unix()),
+		CreatedAt:    created.UTC().Format(time.RFC3339),
+	}
+}
+
+// lnurlScheme is only changed by tests, which serve LNURL over plain HTTP.
+var lnurlScheme = "https"
+
+var (
+	lnurlZappersMu sync.Mutex
+	lnurlZappers   = map[string]string{}
+)
+
+// fetchLNURLZapper reads the nostrPubkey a lightning address signs its zap
+// receipts with, per NIP-57 appendix A.
+func fetchLNURLZapper(ctx context.Context, address string) (string, error) {
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok || name == "" || domain == "" {
+		return "", fmt.Errorf("invalid lightning address %q", address)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lnurlScheme+"://"+domain+"/.well-known/lnurlp/"+url.PathEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch LNURL for %s: %w", address, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("LNURL for %s returned %s", address, res.Status)
+	}
+
+	var pay struct {
+		AllowsNostr bool   `json:"allowsNostr"`
+		NostrPubkey string `json:"nostrPubkey"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pay); err != nil {
+		return "", fmt.Errorf("failed to decode LNURL for %s: %w", address, err)
+	}
+
+	if !pay.AllowsNostr || !nostr.IsValid32ByteHex(pay.NostrPubkey) {
+		return "", fmt.Errorf("%s does not support zaps", address)
+	}
+
+	return pay.NostrPubkey, nil
+}
+
+// ZapperPubKeys returns the configured zapper keys and the one published by
+// NostrZapLNURL, which is looked up once per process.
+func ZapperPubKeys(ctx context.Context, cfg *Config) ([]string, error) {
+	zappers := slices.Clone(cfg.NostrZapperPubKeys)
+
+	if cfg.NostrZapLNURL != "" {
+		lnurlZappersMu.Lock()
+		defer lnurlZappersMu.Unlock()
+
+		pk, ok := lnurlZappers[cfg.NostrZapLNURL]
+		if !ok {
+			var err error
+			if pk, err = fetchLNURLZapper(ctx, cfg.NostrZapLNURL); err != nil {
+				return nil, err
+			}
+			lnurlZappers[cfg.NostrZapLNURL] = pk
+		}
+		zappers = append(zappers, pk)
+	}
+
+	if len(zappers) == 0 {
+		return nil, errors.New("no zapper pubkey is configured")
+	}
+
+	return zappers, nil
+}
+
+// subscribeZapReceipts merges one relay pool subscription per filter. The
+// channel closes once every subscription has ended, and the pool's relays are
+// closed once ctx is done, so callers cancel it then.
+var subscribeZapReceipts = func(ctx context.Context, relays []string, filters nostr.Filters) <-chan nostr.RelayEvent {
+	pool := nostr.NewSimplePool(ctx)
+	go func() {
+		<-ctx.Done()
+		closeRelayPool(pool, "zap subscription ended")
+	}()
+	out := make(chan nostr.RelayEvent)
+
+	var wg sync.WaitGroup
+	for _, filter := range filters {
+		wg.Add(1)
+		go func(filter nostr.Filter) {
+			defer wg.Done()
+			for ev := range pool.SubscribeMany(ctx, slices.Clone(relays), filter) {
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(filter)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// RunZapIngester stores zap receipts for the configured targets until ctx is
+// cancelled. It starts NostrZapLookback in the past so zaps sent while the
+// server was down are picked up; replays are deduplicated by payment hash.
+func RunZapIngester(ctx context.Context, cfg *Config) {
+	targets, err := ParseZapTargets(cfg.NostrZapTargets)
+	if err != nil {
+		log.Printf("zap ingester not started: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	since := time.Now().Add(-cfg.NostrZapLookback)
+
+	var zappers []string
+	for {
+		if zappers, err = ZapperPubKeys(ctx, cfg); err == nil {
+			break
+		}
+		log.Printf("zap ingester waiting for zapper pubkey, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+	backoff = time.Second
+
+	for {
+		received := false
+		subCtx, cancel := context.WithCancel(ctx)
+		for relayEvent := range subscribeZapReceipts(subCtx, cfg.NostrRelays, targets.Filters(since)) {
+			received = true
+
+			ev := relayEvent.Event
+			raw, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("failed to encode zap receipt %s: %v", ev.ID, err)
+				continue
+			}
+
+			pipeline := NewPipeline(cfg, ZapSource{Zappers: zappers})
+			if _, err := pipeline.Ingest(nil, raw); err != nil && !errors.Is(err, ErrIgnoredPayload) {
+				log.Printf("failed to process zap receipt %s: %v", ev.ID, err)
+			}
+
+			if created := ev.CreatedAt.Time(); created.After(since) {
+				since = created
+			}
+		}
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if received {
+			backoff = time.Second
+		}
+
+		log.Printf("zap subscription closed, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const zapTestPaymentHash = "0303030303030303030303030303030303030303030303030303030303030303"
+
+type zapTestKeys struct {
+	sender, recipient, provider string
+}
+
+func newZapTestKeys() zapTestKeys {
+	return zapTestKeys{
+		sender:    nostr.GeneratePrivateKey(),
+		recipient: nostr.GeneratePrivateKey(),
+		provider:  nostr.GeneratePrivateKey(),
+	}
+}
+
+func zapTestPubKey(t *testing.T, sk string) string {
+	t.Helper()
+
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	return pk
+}
+
+// testZapReceipt builds a signed receipt for a signed zap request. modify
+// can tamper with the request before it is signed and embedded.
+func testZapReceipt(t *testing.T, keys zapTestKeys, address, hrp string, modify func(request *nostr.Event)) nostr.Event {
+	t.Helper()
+
+	recipient := zapTestPubKey(t, keys.recipient)
+
+	request := nostr.Event{
+		Kind:      nostr.KindZapRequest,
+		CreatedAt: nostr.Timestamp(1700000000),
+		Content:   "Great stream!",
+		Tags: nostr.Tags{
+			{"relays", "wss://relay.example"},
+			{"amount", "21000"},
+			{"p", recipient},
+			{"a", address},
+		},
+	}
+	if modify != nil {
+		modify(&request)
+	}
+	if err := request.Sign(keys.sender); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	description, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to marshal zap request: %v", err)
+	}
+	hash := sha256.Sum256(description)
+
+	receipt := nostr.Event{
+		Kind:      nostr.KindZap,
+		CreatedAt: nostr.Timestamp(1700000005),
+		Tags: nostr.Tags{
+			{"p", recipient},
+			{"a", address},
+			{"P", request.PubKey},
+			{"bolt11", testBolt11(t, hrp, zapTestPaymentHash, hex.EncodeToString(hash[:]))},
+			{"description", string(description)},
+		},
+	}
+	if err := receipt.Sign(keys.provider); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	return receipt
+}
+
+func zapTestAddress(t *testing.T, keys zapTestKeys) string {
+	return "30311:" + zapTestPubKey(t, keys.recipient) + ":live-show"
+}
+
+func TestParseZapTargets(t *testing.T) {
+	t.Parallel()
+
+	pk := zapTestPubKey(t, nostr.GeneratePrivateKey())
+	eventID := strings.Repeat("ab", 32)
+
+	naddr, err := nip19.EncodeEntity(pk, 30311, "live-show", nil)
+	if err != nil {
+		t.Fatalf("EncodeEntity() error = %v", err)
+	}
+	nevent, err := nip19.EncodeEvent(eventID, nil, "")
+	if err != nil {
+		t.Fatalf("EncodeEvent() error = %v", err)
+	}
+
+	targets, err := ParseZapTargets([]string{naddr, nevent, "30311:" + pk + ":other", eventID})
+	if err != nil {
+		t.Fatalf("ParseZapTargets() error = %v", err)
+	}
+
+	wantAddresses := []string{"30311:" + pk + ":live-show", "30311:" + pk + ":other"}
+	if strings.Join(targets.Addresses, ",") != strings.Join(wantAddresses, ",") {
+		t.Errorf("Addresses = %v, want %v", targets.Addresses, wantAddresses)
+	}
+	if len(targets.EventIDs) != 2 || targets.EventIDs[0] != eventID || targets.EventIDs[1] != eventID {
+		t.Errorf("EventIDs = %v, want the event id twice", targets.EventIDs)
+	}
+
+	filters := targets.Filters(time.Unix(1700000000, 0))
+	if len(filters) != 2 || filters[0].Tags["a"] == nil || filters[1].Tags["e"] == nil {
+		t.Errorf("Filters() = %v, want separate #a and #e filters", filters)
+	}
+
+	for _, bad := range []string{"npub1xyz", "30311:nothex:d", "hello"} {
+		if _, err := ParseZapTargets([]string{bad}); err == nil {
+			t.Errorf("ParseZapTargets(%q) error = nil, want error", bad)
+		}
+	}
+}
+
+func TestZapSourceParse(t *testing.T) {
+	t.Parallel()
+
+	keys := newZapTestKeys()
+	address := zapTestAddress(t, keys)
+	receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatalf("failed to marshal receipt: %v", err)
+	}
+
+	invoice, err := ZapSource{Zappers: []string{zapTestPubKey(t, keys.provider)}}.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if invoice.Amount != 21 {
+		t.Errorf("Amount = %v, want 21", invoice.Amount)
+	}
+	if invoice.Identifier != receipt.ID {
+		t.Errorf("Identifier = %q, want the receipt id", invoice.Identifier)
+	}
+	if invoice.PaymentHash != zapTestPaymentHash {
+		t.Errorf("PaymentHash = %q, want the bolt11 payment hash", invoice.PaymentHash)
+	}
+	if invoice.Description != address {
+		t.Errorf("Description = %q, want the zapped address", invoice.Description)
+	}
+
+	npub, _ := nip19.EncodePublicKey(zapTestPubKey(t, keys.sender))
+	boostagram := invoice.GetBoostagram()
+	if boostagram.Action != "zap" || boostagram.SenderName != npub || boostagram.Message != "Great stream!" || boostagram.ValueMsatTotal != 21000 {
+		t.Errorf("Boostagram = %+v, want a zap from the sender", boostagram)
+	}
+}
+
+func TestValidateZapReceiptErrors(t *testing.T) {
+	t.Parallel()
+
+	keys := newZapTestKeys()
+	address := zapTestAddress(t, keys)
+
+	tests := []struct {
+		name    string
+		receipt func() nostr.Event
+	}{
+		{
+			name: "amount mismatch",
+			receipt: func() nostr.Event {
+				return testZapReceipt(t, keys, address, "lnbc100n", nil)
+			},
+		},
+		{
+			name: "no amount in bolt11",
+			receipt: func() nostr.Event {
+				return testZapReceipt(t, keys, address, "lnbc", func(r *nostr.Event) { r.Tags = r.Tags.FilterOut([]string{"amount"}) })
+			},
+		},
+		{
+			name: "two recipients",
+			receipt: func() nostr.Event {
+				return testZapReceipt(t, keys, address, "lnbc210n", func(r *nostr.Event) {
+					r.Tags = append(r.Tags, nostr.Tag{"p", zapTestPubKey(t, keys.sender)})
+				})
+			},
+		},
+		{
+			name: "different target",
+			receipt: func() nostr.Event {
+				return testZapReceipt(t, keys, address, "lnbc210n", func(r *nostr.Event) {
+					r.Tags = append(r.Tags.FilterOut([]string{"a"}), nostr.Tag{"a", "30311:" + zapTestPubKey(t, keys.sender) + ":other"})
+				})
+			},
+		},
+		{
+			name: "tampered request",
+			receipt: func() nostr.Event {
+				receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+				description := receipt.Tags.Find("description")
+				description[1] = strings.Replace(description[1], "Great stream!", "Great stream!!", 1)
+				if err := receipt.Sign(keys.provider); err != nil {
+					t.Fatalf("Sign() error = %v", err)
+				}
+				return receipt
+			},
+		},
+		{
+			name: "description hash mismatch",
+			receipt: func() nostr.Event {
+				receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+				bolt11 := receipt.Tags.Find("bolt11")
+				bolt11[1] = testBolt11(t, "lnbc210n", zapTestPaymentHash, zapTestPaymentHash)
+				if err := receipt.Sign(keys.provider); err != nil {
+					t.Fatalf("Sign() error = %v", err)
+				}
+				return receipt
+			},
+		},
+		{
+			name: "signed by another key",
+			receipt: func() nostr.Event {
+				receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+				if err := receipt.Sign(keys.sender); err != nil {
+					t.Fatalf("Sign() error = %v", err)
+				}
+				return receipt
+			},
+		},
+		{
+			name: "bad receipt signature",
+			receipt: func() nostr.Event {
+				receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+				receipt.Content = "changed"
+				return receipt
+			},
+		},
+	}
+
+	zappers := []string{zapTestPubKey(t, keys.provider)}
+	if _, err := ValidateZapReceipt(testZapReceipt(t, keys, address, "lnbc210n", nil), zappers); err != nil {
+		t.Fatalf("ValidateZapReceipt() error = %v, want a valid receipt", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ValidateZapReceipt(tt.receipt(), zappers); err == nil || errors.Is(err, ErrIgnoredPayload) {
+				t.Errorf("ValidateZapReceipt() error = %v, want validation error", err)
+			}
+		})
+	}
+
+	if _, err := ValidateZapReceipt(nostr.Event{Kind: nostr.KindTextNote}, zappers); !errors.Is(err, ErrIgnoredPayload) {
+		t.Errorf("other kind error = %v, want ErrIgnoredPayload", err)
+	}
+}
+
+func TestRunZapIngester(t *testing.T) {
+	keys := newZapTestKeys()
+	address := zapTestAddress(t, keys)
+
+	cfg := testNostrConfig(t)
+	cfg.DatabaseURL = sqlitePrefix + filepath.Join(t.TempDir(), "scoreboard.db")
+	cfg.NostrZapTargets = []string{address}
+	cfg.NostrZapLookback = time.Hour
+	cfg.NostrZapperPubKeys = []string{zapTestPubKey(t, keys.provider)}
+	stubRelays(t, nil)
+
+	store, err := SharedStore(cfg)
+	if err != nil {
+		t.Fatalf("SharedStore() error = %v", err)
+	}
+	if _, err := store.MigrateUp(context.Background(), 0); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	receipt := testZapReceipt(t, keys, address, "lnbc210n", nil)
+
+	// A self-signed receipt for the same target must not be counted.
+	forged := testZapReceipt(t, keys, address, "lnbc210n", nil)
+	if err := forged.Sign(keys.sender); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	subscribed := make(chan nostr.Filters, 1)
+
+	original := subscribeZapReceipts
+	subscribeZapReceipts = func(ctx context.Context, relays []string, filters nostr.Filters) <-chan nostr.RelayEvent {
+		events := make(chan nostr.RelayEvent, 2)
+		select {
+		case subscribed <- filters:
+			// Relays may deliver the same receipt for both filters.
+			events <- nostr.RelayEvent{Event: &receipt}
+			events <- nostr.RelayEvent{Event: &receipt}
+		default:
+		}
+		close(events)
+		return events
+	}
+	t.Cleanup(func() { subscribeZapReceipts = original })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunZapIngester(ctx, cfg)
+		close(done)
+	}()
+
+	filters := <-subscribed
+	if len(filters) != 1 || filters[0].Tags["a"][0] != address || filters[0].Kinds[0] != nostr.KindZap {
+		t.Errorf("filters = %v, want kind 9735 for the address", filters)
+	}
+
+	var invoices []IncomingInvoice
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if invoices, err = LoadInvoices(cfg, InvoiceFilter{}); err != nil {
+			t.Fatalf("LoadInvoices() error = %v", err)
+		}
+		if len(invoices) > 0 {
+			break
+		}
+	}
+
+	cancel()
+	<-done
+
+	if len(invoices) != 1 || invoices[0].Identifier != receipt.ID || invoices[0].GetBoostagram().Action != "zap" {
+		t.Fatalf("invoices = %+v, want the zap stored once", invoices)
+	}
+}
+
+func TestSubscribeZapReceiptsDisconnects(t *testing.T) {
+	relay := newFakeRelay(t)
+
+	// RunZapIngester cancels each subscription's context once it ends.
+	ctx, cancel := context.WithCancel(context.Background())
+	events := subscribeZapReceipts(ctx, []string{relay.URL}, nostr.Filters{{Kinds: []int{nostr.KindZap}}})
+
+	select {
+	case <-relay.reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("never subscribed")
+	}
+	cancel()
+	for range events {
+	}
+
+	select {
+	case <-relay.disconnects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay connection still open after the subscription ended")
+	}
+}
+
+func TestZapperPubKeys(t *testing.T) {
+	provider := zapTestPubKey(t, nostr.GeneratePrivateKey())
+	configured := zapTestPubKey(t, nostr.GeneratePrivateKey())
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/.well-known/lnurlp/shows":
+			fmt.Fprintf(w, `{"tag":"payRequest","allowsNostr":true,"nostrPubkey":%q}`, provider)
+		case "/.well-known/lnurlp/nozaps":
+			fmt.Fprint(w, `{"tag":"payRequest"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	original := lnurlScheme
+	lnurlScheme = "http"
+	t.Cleanup(func() { lnurlScheme = original })
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	cfg := &Config{NostrZapperPubKeys: []string{configured}, NostrZapLNURL: "shows@" + host}
+
+	for range 2 {
+		zappers, err := ZapperPubKeys(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("ZapperPubKeys() error = %v", err)
+		}
+		if len(zappers) != 2 || zappers[0] != configured || zappers[1] != provider {
+			t.Errorf("ZapperPubKeys() = %v, want the configured and LNURL keys", zappers)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("LNURL fetched %d times, want once", requests)
+	}
+
+	for _, cfg := range []*Config{{NostrZapLNURL: "nozaps@" + host}, {NostrZapLNURL: "missing@" + host}, {}} {
+		if _, err := ZapperPubKeys(context.Background(), cfg); err == nil {
+			t.Errorf("ZapperPubKeys(%q) error = nil, want error", cfg.NostrZapLNURL)
+		}
+	}
+}
@@ -669,6 +669,19 @@ class NostrWatcher {
   }
 }
 
+// Returns the cursor from the rel="next" entry of a Link header, null when
+// the header has links but no next page, or undefined without a header.
+function nextBoostCursor(linkHeader) {
+  if (!linkHeader) return undefined
+
+  const match = linkHeader.match(/<([^>]+)>;\s*rel="next"/)
+  if (!match) return null
+
+  // The link may be relative, so read its query string directly
+  const query = match[1].split('#')[0].split('?')[1] || ''
+  return new URLSearchParams(query).get('cursor')
+}
+
 class StoredBoosts {
   constructor(filters = {}) {
     this.filters = filters
@@ -677,13 +690,18 @@ class StoredBoosts {
 
   async load(callback, resolveNostr) {
     let page = 1
+    let cursor = null
     const items = 1000
     let lastBoostAt = this.filters.after || null
 
     try {
       while (page <= this.maxPages) {
         const query = new URLSearchParams()
-        query.set("page", page)
+        if (cursor) {
+          query.set("cursor", cursor)
+        } else {
+          query.set("page", page)
+        }
         query.set("items", items)
 
         // Apply filters to query
@@ -735,6 +753,9 @@ class StoredBoosts {
 
         if (!boosts || boosts.length === 0) break
 
+        // Follow the cursor so boosts arriving mid-load don't shift pages
+        const next = nextBoostCursor(result.headers.get('Link'))
+
         // Update last boost time
         lastBoostAt = Math.max(
           lastBoostAt || 0,
@@ -775,6 +796,9 @@ class StoredBoosts {
           }
         }
 
+        if (next === null) break
+
+        cursor = next
         page++
       }
 